@@ -0,0 +1,244 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxBaggageMembers and maxBaggageBytes bound the baggage propagated by
+// a transaction, following the limits in the W3C Baggage specification.
+// Members beyond these limits are dropped.
+const (
+	maxBaggageMembers = 180
+	maxBaggageBytes   = 8192
+)
+
+// Baggage holds application-defined key-value pairs, such as a tenant ID
+// or locale, to be propagated across service boundaries alongside the
+// trace context.
+//
+// Baggage values are immutable. Use NewBaggage or Baggage.With to create
+// new values.
+type Baggage struct {
+	// members is a pointer so that TraceContext remains comparable.
+	members *[]BaggageMember
+}
+
+// NewBaggage returns a Baggage containing members.
+func NewBaggage(members ...BaggageMember) Baggage {
+	if len(members) == 0 {
+		return Baggage{}
+	}
+	members = append([]BaggageMember(nil), members...)
+	return Baggage{members: &members}
+}
+
+// With returns a copy of b with the member for key set to value,
+// replacing any existing member with the same key.
+func (b Baggage) With(key, value string) Baggage {
+	members := make([]BaggageMember, 0, b.Len()+1)
+	for _, m := range b.list() {
+		if m.Key != key {
+			members = append(members, m)
+		}
+	}
+	members = append(members, BaggageMember{Key: key, Value: value})
+	return Baggage{members: &members}
+}
+
+// Get returns the value of the member with the given key, and a boolean
+// indicating whether or not such a member exists.
+func (b Baggage) Get(key string) (string, bool) {
+	for _, m := range b.list() {
+		if m.Key == key {
+			return m.Value, true
+		}
+	}
+	return "", false
+}
+
+// Len returns the number of members in b.
+func (b Baggage) Len() int {
+	return len(b.list())
+}
+
+// Members returns a copy of the members of b.
+func (b Baggage) Members() []BaggageMember {
+	if b.Len() == 0 {
+		return nil
+	}
+	return append([]BaggageMember(nil), b.list()...)
+}
+
+func (b Baggage) list() []BaggageMember {
+	if b.members == nil {
+		return nil
+	}
+	return *b.members
+}
+
+// String returns b in the W3C Baggage header format: a comma-separated
+// list of members, with values percent-encoded as necessary.
+func (b Baggage) String() string {
+	if b.Len() == 0 {
+		return ""
+	}
+	var buf bytes.Buffer
+	for i, m := range b.list() {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		m.writeBuf(&buf)
+	}
+	return buf.String()
+}
+
+// Validate validates the baggage.
+//
+// This will return non-nil if any members are invalid,
+// or if a member key is repeated.
+func (b Baggage) Validate() error {
+	recorded := make(map[string]int)
+	for i, m := range b.list() {
+		if err := m.Validate(); err != nil {
+			return errors.Wrapf(err, "invalid baggage member at position %d", i)
+		}
+		if prev, ok := recorded[m.Key]; ok {
+			return fmt.Errorf("duplicate baggage key %q at positions %d and %d", m.Key, prev, i)
+		}
+		recorded[m.Key] = i
+	}
+	return nil
+}
+
+// valid returns b with any invalid members, and any members whose key
+// repeats that of an earlier member, removed, along with the number of
+// members removed.
+func (b Baggage) valid() (Baggage, int) {
+	if b.Validate() == nil {
+		return b, 0
+	}
+	members := b.list()
+	valid := make([]BaggageMember, 0, len(members))
+	recorded := make(map[string]bool)
+	for _, m := range members {
+		if m.Validate() != nil || recorded[m.Key] {
+			continue
+		}
+		recorded[m.Key] = true
+		valid = append(valid, m)
+	}
+	return NewBaggage(valid...), len(members) - len(valid)
+}
+
+// limit returns b with any members beyond maxMembers, or beyond maxBytes
+// when encoded, removed, along with the number of members removed.
+func (b Baggage) limit(maxMembers, maxBytes int) (Baggage, int) {
+	var buf bytes.Buffer
+	members := b.list()
+	for i, m := range members {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		m.writeBuf(&buf)
+		if i == maxMembers || buf.Len() > maxBytes {
+			return NewBaggage(members[:i]...), len(members) - i
+		}
+	}
+	return b, 0
+}
+
+// BaggageMember holds a baggage member: a key/value pair, with optional
+// properties.
+type BaggageMember struct {
+	// Key holds the member's key.
+	Key string
+
+	// Value holds the member's value, unencoded.
+	Value string
+
+	// Properties holds the member's properties, if any, as they appear
+	// in the header without the leading semicolon, e.g. "p1;p2=v".
+	Properties string
+}
+
+func (m *BaggageMember) writeBuf(buf *bytes.Buffer) {
+	const hex = "0123456789ABCDEF"
+	buf.WriteString(m.Key)
+	buf.WriteByte('=')
+	for i := 0; i < len(m.Value); i++ {
+		c := m.Value[i]
+		if c == '%' || !isBaggageOctet(c) {
+			buf.WriteByte('%')
+			buf.WriteByte(hex[c>>4])
+			buf.WriteByte(hex[c&0xF])
+			continue
+		}
+		buf.WriteByte(c)
+	}
+	if m.Properties != "" {
+		buf.WriteByte(';')
+		buf.WriteString(m.Properties)
+	}
+}
+
+// Validate validates the baggage member.
+//
+// This will return non-nil if the key is not a valid token,
+// or if the properties contain a comma.
+func (m *BaggageMember) Validate() error {
+	if m.Key == "" {
+		return errors.New("key is empty")
+	}
+	for i := 0; i < len(m.Key); i++ {
+		if !isTokenChar(m.Key[i]) {
+			return fmt.Errorf("invalid key %q", m.Key)
+		}
+	}
+	if strings.IndexByte(m.Properties, ',') != -1 {
+		return errors.Errorf("invalid properties for key %q", m.Key)
+	}
+	return nil
+}
+
+// isBaggageOctet reports whether c may appear unencoded in a baggage value.
+func isBaggageOctet(c byte) bool {
+	return c == 0x21 ||
+		(c >= 0x23 && c <= 0x2B) ||
+		(c >= 0x2D && c <= 0x3A) ||
+		(c >= 0x3C && c <= 0x5B) ||
+		(c >= 0x5D && c <= 0x7E)
+}
+
+// isTokenChar reports whether c is a valid RFC 7230 token character.
+func isTokenChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	switch c {
+	case '!', '#', '$', '%', '&', '\'', '*', '+', '-', '.', '^', '_', '`', '|', '~':
+		return true
+	}
+	return false
+}
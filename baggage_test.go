@@ -0,0 +1,123 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/apmtest"
+	"go.elastic.co/apm/transport/transporttest"
+)
+
+func TestBaggage(t *testing.T) {
+	var baggage apm.Baggage
+	assert.Equal(t, "", baggage.String())
+	assert.NoError(t, baggage.Validate())
+
+	baggage = baggage.With("tenant", "acme").With("locale", "en-AU").With("tenant", "acme;corp")
+	assert.Equal(t, "locale=en-AU,tenant=acme%3Bcorp", baggage.String())
+	value, ok := baggage.Get("tenant")
+	assert.True(t, ok)
+	assert.Equal(t, "acme;corp", value)
+	_, ok = baggage.Get("missing")
+	assert.False(t, ok)
+	assert.NoError(t, baggage.Validate())
+
+	baggage = apm.NewBaggage(apm.BaggageMember{Key: "a b", Value: "c"})
+	assert.EqualError(t, baggage.Validate(), `invalid baggage member at position 0: invalid key "a b"`)
+
+	baggage = apm.NewBaggage(apm.BaggageMember{Key: "a"}, apm.BaggageMember{Key: "a"})
+	assert.EqualError(t, baggage.Validate(), `duplicate baggage key "a" at positions 0 and 1`)
+}
+
+func TestTransactionBaggageLimit(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	var logger apmtest.RecordLogger
+	tracer.SetLogger(&logger)
+
+	members := make([]apm.BaggageMember, 200)
+	for i := range members {
+		members[i] = apm.BaggageMember{Key: fmt.Sprintf("key%d", i), Value: "value"}
+	}
+	tx := tracer.StartTransactionOptions("name", "type", apm.TransactionOptions{
+		TraceContext: apm.TraceContext{Baggage: apm.NewBaggage(members...)},
+	})
+	assert.Equal(t, 180, tx.TraceContext().Baggage.Len())
+	tx.End()
+
+	members = []apm.BaggageMember{
+		{Key: "a", Value: strings.Repeat("x", 5000)},
+		{Key: "b", Value: strings.Repeat("x", 5000)},
+	}
+	tx = tracer.StartTransactionOptions("name", "type", apm.TransactionOptions{
+		TraceContext: apm.TraceContext{Baggage: apm.NewBaggage(members...)},
+	})
+	assert.Equal(t, 1, tx.TraceContext().Baggage.Len())
+	tx.End()
+	tracer.Flush(nil)
+	require.Len(t, transport.Payloads().Transactions, 2)
+
+	var warnings []string
+	for _, record := range logger.Records {
+		if record.Level == "warning" {
+			warnings = append(warnings, record.Message)
+		}
+	}
+	assert.Equal(t, []string{
+		`transaction "name" baggage exceeds the maximum of 180 members or 8192 bytes, dropped 20 members`,
+		`transaction "name" baggage exceeds the maximum of 180 members or 8192 bytes, dropped 1 members`,
+	}, warnings)
+}
+
+func TestTransactionBaggageInvalid(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	var logger apmtest.RecordLogger
+	tracer.SetLogger(&logger)
+
+	tx := tracer.StartTransactionOptions("name", "type", apm.TransactionOptions{
+		TraceContext: apm.TraceContext{Baggage: apm.NewBaggage(
+			apm.BaggageMember{Key: "tenant", Value: "acme"},
+			apm.BaggageMember{Key: "a b", Value: "c"},
+			apm.BaggageMember{Key: "tenant", Value: "other"},
+			apm.BaggageMember{Key: "locale", Value: "en-AU"},
+		)},
+	})
+	// Only the invalid and duplicate members are dropped.
+	assert.Equal(t, "tenant=acme,locale=en-AU", tx.TraceContext().Baggage.String())
+	tx.End()
+	tracer.Flush(nil)
+	require.Len(t, transport.Payloads().Transactions, 1)
+
+	var warnings []string
+	for _, record := range logger.Records {
+		if record.Level == "warning" {
+			warnings = append(warnings, record.Message)
+		}
+	}
+	assert.Equal(t, []string{
+		`transaction "name" baggage has invalid or duplicate members, dropped 2 members`,
+	}, warnings)
+}
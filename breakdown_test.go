@@ -58,6 +58,34 @@ func TestBreakdownMetrics_NonSampled(t *testing.T) {
 	}, payloadsBreakdownMetrics(transport))
 }
 
+func TestBreakdownMetrics_NonSampledDropSpans(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	// Spans of non-sampled transactions do not attribute to breakdown
+	// metrics when they are dropped immediately; the transaction's
+	// self-time covers its entire duration.
+	tracer.SetSampler(apm.NewRatioSampler(0))
+	tracer.SetDropUnsampledSpans(true)
+
+	t0 := time.Now()
+	tx := tracer.StartTransactionOptions("test", "request", apm.TransactionOptions{Start: t0})
+	span := tx.StartSpanOptions("whatever", "db.mysql", apm.SpanOptions{Start: t0.Add(10 * time.Millisecond)})
+	assert.True(t, span.Dropped())
+	span.Duration = 10 * time.Millisecond // t0 + 20ms
+	span.End()
+	tx.Duration = 30 * time.Millisecond
+	tx.End()
+
+	tracer.Flush(nil)
+	tracer.SendMetrics(nil)
+
+	assertBreakdownMetrics(t, []model.Metrics{
+		transactionDurationMetrics("test", "request", 1, 30*time.Millisecond),
+		spanSelfTimeMetrics("test", "request", "app", "", 1, 30*time.Millisecond),
+	}, payloadsBreakdownMetrics(transport))
+}
+
 func TestBreakdownMetrics_SpanDropped(t *testing.T) {
 	tracer, transport := transporttest.NewRecorderTracer()
 	defer tracer.Close()
@@ -56,6 +56,11 @@ const (
 	envCentralConfig               = "ELASTIC_APM_CENTRAL_CONFIG"
 	envBreakdownMetrics            = "ELASTIC_APM_BREAKDOWN_METRICS"
 	envUseElasticTraceparentHeader = "ELASTIC_APM_USE_ELASTIC_TRACEPARENT_HEADER"
+	envSpanCompressionEnabled      = "ELASTIC_APM_SPAN_COMPRESSION_ENABLED"
+	envSpanCompressionMaxDuration  = "ELASTIC_APM_SPAN_COMPRESSION_EXACT_MATCH_MAX_DURATION"
+	envDropUnsampledSpans          = "ELASTIC_APM_DROP_UNSAMPLED_SPANS"
+	envDisableInstrumentations     = "ELASTIC_APM_DISABLE_INSTRUMENTATIONS"
+	envTransactionMaxDuration      = "ELASTIC_APM_TRANSACTION_MAX_DURATION"
 
 	// NOTE(axw) profiling environment variables are experimental.
 	// They may be removed in a future minor version without being
@@ -64,16 +69,17 @@ const (
 	envCPUProfileDuration  = "ELASTIC_APM_CPU_PROFILE_DURATION"
 	envHeapProfileInterval = "ELASTIC_APM_HEAP_PROFILE_INTERVAL"
 
-	defaultAPIRequestSize        = 750 * configutil.KByte
-	defaultAPIRequestTime        = 10 * time.Second
-	defaultAPIBufferSize         = 1 * configutil.MByte
-	defaultMetricsBufferSize     = 750 * configutil.KByte
-	defaultMetricsInterval       = 30 * time.Second
-	defaultMaxSpans              = 500
-	defaultCaptureHeaders        = true
-	defaultCaptureBody           = CaptureBodyOff
-	defaultSpanFramesMinDuration = 5 * time.Millisecond
-	defaultStackTraceLimit       = 50
+	defaultAPIRequestSize             = 750 * configutil.KByte
+	defaultAPIRequestTime             = 10 * time.Second
+	defaultAPIBufferSize              = 1 * configutil.MByte
+	defaultMetricsBufferSize          = 750 * configutil.KByte
+	defaultMetricsInterval            = 30 * time.Second
+	defaultMaxSpans                   = 500
+	defaultCaptureHeaders             = true
+	defaultCaptureBody                = CaptureBodyOff
+	defaultSpanFramesMinDuration      = 5 * time.Millisecond
+	defaultStackTraceLimit            = 50
+	defaultSpanCompressionMaxDuration = 50 * time.Millisecond
 
 	minAPIBufferSize     = 10 * configutil.KByte
 	maxAPIBufferSize     = 100 * configutil.MByte
@@ -165,7 +171,7 @@ func initialAPIRequestSize() (int, error) {
 }
 
 func initialMaxSpans() (int, error) {
-	value := os.Getenv(envMaxSpans)
+	value := configutil.Getenv(envMaxSpans)
 	if value == "" {
 		return defaultMaxSpans, nil
 	}
@@ -178,7 +184,7 @@ func initialMaxSpans() (int, error) {
 
 // initialSampler returns a nil Sampler if all transactions should be sampled.
 func initialSampler() (Sampler, error) {
-	value := os.Getenv(envTransactionSampleRate)
+	value := configutil.Getenv(envTransactionSampleRate)
 	return parseSampleRate(envTransactionSampleRate, value)
 }
 
@@ -209,7 +215,7 @@ func initialCaptureHeaders() (bool, error) {
 }
 
 func initialCaptureBody() (CaptureBodyMode, error) {
-	value := os.Getenv(envCaptureBody)
+	value := configutil.Getenv(envCaptureBody)
 	if value == "" {
 		return defaultCaptureBody, nil
 	}
@@ -231,19 +237,35 @@ func parseCaptureBody(name, value string) (CaptureBodyMode, error) {
 }
 
 func initialService() (name, version, environment string) {
-	name = os.Getenv(envServiceName)
-	version = os.Getenv(envServiceVersion)
-	environment = os.Getenv(envEnvironment)
+	name = configutil.Getenv(envServiceName)
+	version = configutil.Getenv(envServiceVersion)
+	environment = configutil.Getenv(envEnvironment)
 	if name == "" {
-		name = filepath.Base(os.Args[0])
-		if runtime.GOOS == "windows" {
-			name = strings.TrimSuffix(name, filepath.Ext(name))
-		}
+		name = executableServiceName()
 	}
 	name = sanitizeServiceName(name)
 	return name, version, environment
 }
 
+// executableServiceName returns the name of the executable, without
+// any ".exe" extension on Windows, for use as the default service name.
+func executableServiceName() string {
+	var path string
+	if len(os.Args) > 0 {
+		path = os.Args[0]
+	}
+	if path == "" {
+		// os.Args may be empty if the process was executed without
+		// arguments, in which case we fall back to os.Executable.
+		path, _ = os.Executable()
+	}
+	name := filepath.Base(path)
+	if runtime.GOOS == "windows" {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return name
+}
+
 func initialSpanFramesMinDuration() (time.Duration, error) {
 	return configutil.ParseDurationEnv(envSpanFramesMinDuration, defaultSpanFramesMinDuration)
 }
@@ -257,7 +279,7 @@ func initialDisabledMetrics() wildcard.Matchers {
 }
 
 func initialStackTraceLimit() (int, error) {
-	value := os.Getenv(envStackTraceLimit)
+	value := configutil.Getenv(envStackTraceLimit)
 	if value == "" {
 		return defaultStackTraceLimit, nil
 	}
@@ -280,6 +302,26 @@ func initialUseElasticTraceparentHeader() (bool, error) {
 	return configutil.ParseBoolEnv(envUseElasticTraceparentHeader, true)
 }
 
+func initialSpanCompressionEnabled() (bool, error) {
+	return configutil.ParseBoolEnv(envSpanCompressionEnabled, false)
+}
+
+func initialSpanCompressionMaxDuration() (time.Duration, error) {
+	return configutil.ParseDurationEnv(envSpanCompressionMaxDuration, defaultSpanCompressionMaxDuration)
+}
+
+func initialDisabledInstrumentations() wildcard.Matchers {
+	return configutil.ParseWildcardPatternsEnv(envDisableInstrumentations, nil)
+}
+
+func initialDropUnsampledSpans() (bool, error) {
+	return configutil.ParseBoolEnv(envDropUnsampledSpans, false)
+}
+
+func initialTransactionMaxDuration() (time.Duration, error) {
+	return configutil.ParseDurationEnv(envTransactionMaxDuration, 0)
+}
+
 func initialCPUProfileIntervalDuration() (time.Duration, time.Duration, error) {
 	interval, err := configutil.ParseDurationEnv(envCPUProfileInterval, 0)
 	if err != nil || interval <= 0 {
@@ -445,4 +487,19 @@ type instrumentationConfigValues struct {
 	spanFramesMinDuration time.Duration
 	stackTraceLimit       int
 	propagateLegacyHeader bool
+
+	spanCompressionEnabled     bool
+	spanCompressionMaxDuration time.Duration
+	transactionMaxDuration     time.Duration
+	dropUnsampledSpans         bool
+	disabledInstrumentations   wildcard.Matchers
+
+	sampleDecisionObserver func(transactionName string, sampled bool)
+	samplingHeader         string
+	errorSampler           ErrorSampler
+	transactionTypes       transactionTypes
+
+	// omitHandledErrorStacktraces is the inverse of the value passed to
+	// SetHandledErrorStacktraces, so the zero value is the default.
+	omitHandledErrorStacktraces bool
 }
@@ -71,6 +71,65 @@ func (c *Context) reset() {
 	}
 }
 
+// CopyFrom replaces the contents of c with a copy of the contents of src,
+// reusing memory already allocated by c where possible.
+//
+// CopyFrom may be used to efficiently initialize the context of many
+// transactions or errors from a template Context, holding fields that
+// are common to them all, e.g. labels or user details:
+//
+//	var template apm.Context
+//	template.SetLabel("region", "us-east-1")
+//	...
+//	tx.Context.CopyFrom(&template)
+//	tx.Context.SetLabel("request_kind", kind)
+//
+// Header and body capture configuration is not copied from src; c's
+// configuration is retained. Values referenced by src (e.g. header
+// values and request body form values) are shared with c, and must
+// not be modified.
+func (c *Context) CopyFrom(src *Context) {
+	if c == src {
+		return
+	}
+	captureHeaders, captureBodyMask := c.captureHeaders, c.captureBodyMask
+	custom := append(c.model.Custom[:0], src.model.Custom...)
+	tags := append(c.model.Tags[:0], src.model.Tags...)
+	requestHeaders := append(c.request.Headers[:0], src.request.Headers...)
+	responseHeaders := append(c.response.Headers[:0], src.response.Headers...)
+
+	*c = *src
+	c.captureHeaders = captureHeaders
+	c.captureBodyMask = captureBodyMask
+	c.model.Custom = custom
+	c.model.Tags = tags
+	c.request.Headers = requestHeaders
+	c.response.Headers = responseHeaders
+
+	// Update internal pointers to refer to c rather than src.
+	if c.model.Request != nil {
+		c.model.Request = &c.request
+	}
+	if c.request.Body != nil {
+		c.request.Body = &c.requestBody
+	}
+	if c.request.Socket != nil {
+		c.request.Socket = &c.requestSocket
+	}
+	if c.model.Response != nil {
+		c.model.Response = &c.response
+	}
+	if c.model.User != nil {
+		c.model.User = &c.user
+	}
+	if c.model.Service != nil {
+		c.model.Service = &c.service
+	}
+	if c.service.Framework != nil {
+		c.service.Framework = &c.serviceFramework
+	}
+}
+
 // SetTag calls SetLabel(key, value).
 //
 // SetTag is deprecated, and will be removed in a future major version.
@@ -231,6 +290,20 @@ func (c *Context) SetHTTPStatusCode(statusCode int) {
 	c.model.Response = &c.response
 }
 
+// responseFinished is the value pointed to by model.Response.Finished
+// for responses recorded with SetHTTPResponse.
+var responseFinished = true
+
+// SetHTTPResponse records the status code and headers of a finished HTTP
+// response. Headers are recorded only if header capture is enabled, and
+// the values of sensitive headers such as Set-Cookie are redacted when
+// the transaction or error is encoded.
+func (c *Context) SetHTTPResponse(statusCode int, headers http.Header) {
+	c.SetHTTPStatusCode(statusCode)
+	c.SetHTTPResponseHeaders(headers)
+	c.response.Finished = &responseFinished
+}
+
 // SetUserID sets the ID of the authenticated user.
 func (c *Context) SetUserID(id string) {
 	c.user.ID = truncateString(id)
@@ -19,6 +19,7 @@ package apm_test
 
 import (
 	"context"
+	"net/http"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -48,6 +49,15 @@ func TestContextLabels(t *testing.T) {
 	}, tx.Context.Tags)
 }
 
+func TestContextLabelsLargeInteger(t *testing.T) {
+	tx := testSendTransaction(t, func(tx *apm.Transaction) {
+		tx.Context.SetLabel("id", int64(9007199254740993))
+		tx.Context.SetCustom("id", int64(-9007199254740993))
+	})
+	assert.Equal(t, model.IfaceMap{{Key: "id", Value: int64(9007199254740993)}}, tx.Context.Tags)
+	assert.Equal(t, model.IfaceMap{{Key: "id", Value: int64(-9007199254740993)}}, tx.Context.Custom)
+}
+
 func TestContextUser(t *testing.T) {
 	t.Run("email", func(t *testing.T) {
 		tx := testSendTransaction(t, func(tx *apm.Transaction) {
@@ -119,6 +129,101 @@ func TestContextCustom(t *testing.T) {
 	}, tx.Context.Custom)
 }
 
+func TestContextHTTPResponse(t *testing.T) {
+	tx := testSendTransaction(t, func(tx *apm.Transaction) {
+		tx.Context.SetHTTPResponse(http.StatusCreated, http.Header{
+			"Content-Type": []string{"application/json"},
+			"Set-Cookie":   []string{"session=secret"},
+		})
+	})
+	require.NotNil(t, tx.Context)
+	require.NotNil(t, tx.Context.Response)
+	finished := true
+	assert.Equal(t, &model.Response{
+		StatusCode: http.StatusCreated,
+		Headers: model.Headers{
+			{Key: "Content-Type", Values: []string{"application/json"}},
+			{Key: "Set-Cookie", Values: []string{"[REDACTED]"}},
+		},
+		Finished: &finished,
+	}, tx.Context.Response)
+}
+
+func TestContextNoHTTPResponse(t *testing.T) {
+	tx := testSendTransaction(t, func(tx *apm.Transaction) {
+		tx.Context.SetLabel("foo", "bar")
+	})
+	require.NotNil(t, tx.Context)
+	assert.Nil(t, tx.Context.Response)
+}
+
+func TestContextCopyFrom(t *testing.T) {
+	var template apm.Context
+	template.SetLabel("foo", "bar")
+	template.SetCustom("baz", "qux")
+	template.SetUsername("schnibble")
+	template.SetFramework("framework", "1.0")
+	template.SetHTTPStatusCode(202)
+
+	tx := testSendTransaction(t, func(tx *apm.Transaction) {
+		tx.Context.SetLabel("discarded", "label")
+		tx.Context.CopyFrom(&template)
+		tx.Context.SetLabel("dynamic", "value")
+		tx.Context.SetUserID("123")
+	})
+	require.NotNil(t, tx.Context)
+	assert.Equal(t, model.IfaceMap{
+		{Key: "dynamic", Value: "value"},
+		{Key: "foo", Value: "bar"},
+	}, tx.Context.Tags)
+	assert.Equal(t, model.IfaceMap{{Key: "baz", Value: "qux"}}, tx.Context.Custom)
+	assert.Equal(t, &model.User{Username: "schnibble", ID: "123"}, tx.Context.User)
+	assert.Equal(t, &model.Framework{Name: "framework", Version: "1.0"}, tx.Context.Service.Framework)
+	assert.Equal(t, &model.Response{StatusCode: 202}, tx.Context.Response)
+
+	// The template must not have been modified via the copy.
+	tx = testSendTransaction(t, func(tx *apm.Transaction) {
+		tx.Context.CopyFrom(&template)
+	})
+	assert.Equal(t, model.IfaceMap{{Key: "foo", Value: "bar"}}, tx.Context.Tags)
+	assert.Equal(t, &model.User{Username: "schnibble"}, tx.Context.User)
+}
+
+func BenchmarkContextCopyFrom(b *testing.B) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	var template apm.Context
+	template.SetLabel("region", "us-east-1")
+	template.SetLabel("tier", "frontend")
+	template.SetCustom("deployment", "canary")
+	template.SetUsername("schnibble")
+	template.SetFramework("framework", "1.0")
+
+	b.Run("CopyFrom", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			tx := tracer.StartTransaction("name", "type")
+			tx.Context.CopyFrom(&template)
+			tx.Context.SetLabel("request", i)
+			tx.Discard()
+		}
+	})
+	b.Run("Setters", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			tx := tracer.StartTransaction("name", "type")
+			tx.Context.SetLabel("region", "us-east-1")
+			tx.Context.SetLabel("tier", "frontend")
+			tx.Context.SetCustom("deployment", "canary")
+			tx.Context.SetUsername("schnibble")
+			tx.Context.SetFramework("framework", "1.0")
+			tx.Context.SetLabel("request", i)
+			tx.Discard()
+		}
+	})
+}
+
 func testSendTransaction(t *testing.T, f func(tx *apm.Transaction)) model.Transaction {
 	transaction, _, _ := apmtest.WithTransaction(func(ctx context.Context) {
 		f(apm.TransactionFromContext(ctx))
@@ -18,6 +18,7 @@
 package apm
 
 import (
+	"context"
 	"crypto/rand"
 	"fmt"
 	"net"
@@ -45,6 +46,11 @@ const (
 // err is either v (if v implements error), or otherwise
 // fmt.Errorf("%v", v). The value v is expected to have
 // come from a panic.
+//
+// If v implements error, including runtime.Error values such
+// as those for nil pointer dereferences, the exception type is
+// that of v, as for NewError. Otherwise, the exception type is
+// set to the type of v, e.g. "string" for panic("boom").
 func (t *Tracer) Recovered(v interface{}) *Error {
 	var e *Error
 	switch v := v.(type) {
@@ -52,10 +58,64 @@ func (t *Tracer) Recovered(v interface{}) *Error {
 		e = t.NewError(v)
 	default:
 		e = t.NewError(fmt.Errorf("%v", v))
+		if reflectType := reflect.TypeOf(v); reflectType != nil {
+			if reflectType.Name() == "" && reflectType.Kind() == reflect.Ptr {
+				reflectType = reflectType.Elem()
+			}
+			e.exception.Type.Name = reflectType.Name()
+			if e.exception.Type.Name == "" {
+				e.exception.Type.Name = reflectType.String()
+			}
+			e.exception.Type.Name = truncateString(e.exception.Type.Name)
+			e.exception.Type.PackagePath = truncateString(reflectType.PkgPath())
+		}
 	}
 	return e
 }
 
+// RecoverWithContext recovers a panic, reporting the recovered value as
+// an error with the context of tx, such as the HTTP request, copied onto
+// it. RecoverWithContext must be deferred directly, as in
+//
+//	defer tracer.RecoverWithContext(ctx, tx)
+//
+// in order for it to recover the panic. The panic is not re-raised.
+//
+// If tx is nil, the transaction in ctx will be used, if any. If ctx
+// contains a span, the error will be associated with that span.
+func (t *Tracer) RecoverWithContext(ctx context.Context, tx *Transaction) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	e := t.Recovered(v)
+	if tx == nil {
+		tx = TransactionFromContext(ctx)
+	}
+	var txType string
+	if tx != nil {
+		tx.mu.RLock()
+		traceContext := tx.traceContext
+		if !tx.ended() {
+			txType = tx.Type
+			e.Context.CopyFrom(&tx.Context)
+		}
+		tx.mu.RUnlock()
+		// The transaction's custom context is copied along with the
+		// rest of its context above, so it is not passed in here.
+		e.setSpanData(traceContext, traceContext.Span, txType, nil)
+	}
+	if span := SpanFromContext(ctx); span != nil {
+		span.mu.Lock()
+		if !span.ended() {
+			span.errorCount++
+		}
+		span.mu.Unlock()
+		e.setSpanData(span.traceContext, span.transactionID, txType, nil)
+	}
+	e.Send()
+}
+
 // NewError returns a new Error with details taken from err.
 // NewError will panic if called with a nil error.
 //
@@ -89,13 +149,27 @@ func (t *Tracer) NewError(err error) *Error {
 	if err == nil {
 		panic("NewError must be called with a non-nil error")
 	}
+	return t.newErrorFromError(err, false)
+}
+
+// newErrorFromError returns a new Error with details taken from err,
+// as described for NewError, and with the Handled field set to handled.
+//
+// If handled is true and the tracer has been configured to omit
+// stacktraces from handled errors, no stacktrace will be captured.
+func (t *Tracer) newErrorFromError(err error, handled bool) *Error {
 	e := t.newError()
 	e.cause = err
 	e.err = err.Error()
+	e.Handled = handled
 	rand.Read(e.ID[:]) // ignore error, can't do anything about it
-	initException(&e.exception, err, e.stackTraceLimit)
-	if len(e.exception.stacktrace) == 0 {
-		e.SetStacktrace(2)
+	stackTraceLimit := e.stackTraceLimit
+	if handled && t.instrumentationConfig().omitHandledErrorStacktraces {
+		stackTraceLimit = 0
+	}
+	initException(&e.exception, err, stackTraceLimit)
+	if len(e.exception.stacktrace) == 0 && stackTraceLimit != 0 {
+		e.setStacktrace(3)
 	}
 	return e
 }
@@ -172,6 +246,7 @@ type ErrorData struct {
 	logStacktrace      []stacktrace.Frame
 	transactionSampled bool
 	transactionType    string
+	explicitStacktrace bool
 
 	// ID is the unique identifier of the error. This is set by
 	// the various error constructors, and is exposed only so
@@ -202,8 +277,11 @@ type ErrorData struct {
 	Culprit string
 
 	// Timestamp records the time at which the error occurred.
-	// This is set when the Error object is created, but may
-	// be overridden any time before the Send method is called.
+	// This is set to the current time when the Error object is
+	// created, but may be overridden any time before the Send
+	// method is called, e.g. for errors constructed from events
+	// that occurred in the past. The tracer sends the timestamp
+	// as it is at the time Send is called, without modification.
 	Timestamp time.Time
 
 	// Handled records whether or not the error was handled. This
@@ -259,7 +337,15 @@ func (e *Error) SetTransaction(tx *Transaction) {
 // If any custom context has been recorded in s's transaction, it will
 // also be carried across to e, but will not override any custom context
 // already recorded on e.
+//
+// If s has not been ended, then its error count will be incremented.
 func (e *Error) SetSpan(s *Span) {
+	s.mu.Lock()
+	if !s.ended() {
+		s.errorCount++
+	}
+	s.mu.Unlock()
+
 	var txType string
 	var custom model.IfaceMap
 	if s.tx != nil {
@@ -302,10 +388,26 @@ func (e *Error) setSpanData(
 //
 // Send will set e.ErrorData to nil, so the error must not be
 // modified after Send returns.
+//
+// If the error is not associated with a sampled transaction, and
+// the tracer has been configured with an ErrorSampler, the error
+// will be discarded if the sampler does not sample it.
 func (e *Error) Send() {
 	if e == nil || e.sent() {
 		return
 	}
+	instrumentationConfig := e.tracer.instrumentationConfig()
+	if !e.transactionSampled && instrumentationConfig.errorSampler != nil {
+		if !instrumentationConfig.errorSampler.SampleError(e.ID) {
+			e.ErrorData.reset()
+			e.ErrorData = nil
+			return
+		}
+	}
+	if e.Handled && !e.explicitStacktrace && instrumentationConfig.omitHandledErrorStacktraces {
+		e.exception.clearStacktraces()
+		e.logStacktrace = e.logStacktrace[:0]
+	}
 	e.ErrorData.enqueue()
 	e.ErrorData = nil
 }
@@ -359,6 +461,14 @@ func (e *exceptionData) reset() {
 	}
 }
 
+// clearStacktraces clears the stacktraces of e and its causes.
+func (e *exceptionData) clearStacktraces() {
+	e.stacktrace = e.stacktrace[:0]
+	for i := range e.cause {
+		e.cause[i].clearStacktraces()
+	}
+}
+
 func initException(e *exceptionData, err error, stackTraceLimit int) {
 	b := exceptionDataBuilder{stackTraceLimit: stackTraceLimit}
 	b.init(e, err)
@@ -484,7 +594,16 @@ func (b *exceptionDataBuilder) initErrorStacktrace(out *[]stacktrace.Frame, err
 // SetStacktrace sets the stacktrace for the error,
 // skipping the first skip number of frames, excluding
 // the SetStacktrace function.
+//
+// A stacktrace set with SetStacktrace is always reported,
+// even if the error is handled and the tracer has been
+// configured with SetHandledErrorStacktraces(false).
 func (e *Error) SetStacktrace(skip int) {
+	e.setStacktrace(skip + 1)
+	e.explicitStacktrace = true
+}
+
+func (e *Error) setStacktrace(skip int) {
 	out := &e.exception.stacktrace
 	if e.log.Message != "" {
 		out = &e.logStacktrace
@@ -28,6 +28,7 @@ import (
 	"strconv"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
@@ -180,6 +181,64 @@ func TestErrorAutoStackTraceReuse(t *testing.T) {
 	}
 }
 
+func TestErrorHandledStacktraces(t *testing.T) {
+	tracer, r := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetHandledErrorStacktraces(false)
+
+	sendError := func(handled, explicit bool) {
+		e := tracer.NewError(errors.New("boom"))
+		e.Handled = handled
+		if explicit {
+			e.SetStacktrace(0)
+		}
+		e.Send()
+	}
+	sendError(true, false)
+	sendError(true, true)
+	sendError(false, false)
+	tracer.Flush(nil)
+
+	payloads := r.Payloads()
+	require.Len(t, payloads.Errors, 3)
+	assert.Empty(t, payloads.Errors[0].Exception.Stacktrace)
+	assert.NotEmpty(t, payloads.Errors[1].Exception.Stacktrace)
+	assert.NotEmpty(t, payloads.Errors[2].Exception.Stacktrace)
+}
+
+func TestCaptureErrorHandledStacktraces(t *testing.T) {
+	tracer, r := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	ctx := apm.ContextWithTransaction(context.Background(), tx)
+	var sent int
+	captureError := func() {
+		apm.CaptureError(ctx, errors.New("boom")).Send()
+		sent++
+	}
+	allocsCaptured := testing.AllocsPerRun(10, captureError)
+	sentCaptured := sent
+	tracer.SetHandledErrorStacktraces(false)
+	allocsOmitted := testing.AllocsPerRun(10, captureError)
+	tx.End()
+
+	// The stacktrace is not captured at all for errors
+	// known to be handled when they are created.
+	assert.Less(t, allocsOmitted, allocsCaptured)
+
+	tracer.Flush(nil)
+	payloads := r.Payloads()
+	require.Len(t, payloads.Errors, sent)
+	for i, e := range payloads.Errors {
+		if i < sentCaptured {
+			assert.NotEmpty(t, e.Exception.Stacktrace)
+		} else {
+			assert.Empty(t, e.Exception.Stacktrace)
+		}
+	}
+}
+
 func TestCaptureErrorNoTransaction(t *testing.T) {
 	// When there's no transaction or span in the context,
 	// CaptureError returns Error with nil ErrorData as it has no tracer with
@@ -215,6 +274,29 @@ func TestErrorLogRecord(t *testing.T) {
 	assert.Equal(t, "makeError", err0.Culprit) // based on exception stacktrace
 }
 
+func TestErrorTimestamp(t *testing.T) {
+	tracer, recorder := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	before := time.Now()
+	e := tracer.NewError(errors.New("boom"))
+	assert.False(t, e.Timestamp.Before(before))
+	assert.False(t, e.Timestamp.After(time.Now()))
+	e.Send()
+
+	timestamp := time.Date(2019, time.March, 4, 5, 6, 7, 890000000, time.FixedZone("UTC+10", 10*60*60))
+	e = tracer.NewError(errors.New("bang"))
+	e.Timestamp = timestamp
+	time.Sleep(time.Millisecond)
+	e.Send()
+	tracer.Flush(nil)
+
+	payloads := recorder.Payloads()
+	require.Len(t, payloads.Errors, 2)
+	assert.False(t, time.Time(payloads.Errors[0].Timestamp).Before(before.Truncate(time.Microsecond)))
+	assert.Equal(t, timestamp.UTC(), time.Time(payloads.Errors[1].Timestamp))
+}
+
 func TestErrorCauserInterface(t *testing.T) {
 	type Causer interface {
 		Cause() error
@@ -274,6 +356,34 @@ func TestErrorTransactionNotSampled(t *testing.T) {
 	assertErrorTransactionSampled(t, payloads.Errors[0], false)
 }
 
+func TestErrorTransactionNotSampledCorrelation(t *testing.T) {
+	tracer, recorder := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetSampler(apm.NewRatioSampler(0))
+
+	tx := tracer.StartTransaction("name", "type")
+	ctx := apm.ContextWithTransaction(context.Background(), tx)
+	apm.CaptureError(ctx, errors.New("boom")).Send()
+	tx.End()
+
+	tracer.Flush(nil)
+	payloads := recorder.Payloads()
+	require.Len(t, payloads.Errors, 1)
+	require.Len(t, payloads.Transactions, 1)
+	assert.Len(t, payloads.Spans, 0)
+
+	// The unsampled transaction is sent, minimally, so that
+	// the error can be correlated with it.
+	transaction := payloads.Transactions[0]
+	error0 := payloads.Errors[0]
+	require.NotNil(t, transaction.Sampled)
+	assert.False(t, *transaction.Sampled)
+	assert.Nil(t, transaction.Context)
+	assert.Equal(t, transaction.ID, error0.TransactionID)
+	assert.Equal(t, transaction.TraceID, error0.TraceID)
+	assertErrorTransactionSampled(t, error0, false)
+}
+
 func TestErrorTransactionSampledNoTransaction(t *testing.T) {
 	tracer, recorder := transporttest.NewRecorderTracer()
 	defer tracer.Close()
@@ -438,6 +548,38 @@ func testErrorCauseCulpritHelper() error {
 	return errors.Errorf("something happened here")
 }
 
+func TestErrorCulpritFunc(t *testing.T) {
+	tracer, recorder := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	// Skip frames of the helper function, as if it were part of
+	// an error package.
+	tracer.SetCulpritFunc(func(frames []model.StacktraceFrame) string {
+		for _, frame := range frames {
+			if frame.Function != "testErrorCauseCulpritHelper" {
+				return frame.Function
+			}
+		}
+		return ""
+	})
+	tracer.NewError(testErrorCauseCulpritHelper()).Send()
+
+	// An explicitly set culprit takes precedence.
+	e := tracer.NewError(testErrorCauseCulpritHelper())
+	e.Culprit = "explicit"
+	e.Send()
+
+	tracer.SetCulpritFunc(nil)
+	tracer.NewError(testErrorCauseCulpritHelper()).Send()
+	tracer.Flush(nil)
+
+	payloads := recorder.Payloads()
+	require.Len(t, payloads.Errors, 3)
+	assert.Equal(t, "TestErrorCulpritFunc", payloads.Errors[0].Culprit)
+	assert.Equal(t, "explicit", payloads.Errors[1].Culprit)
+	assert.Equal(t, "testErrorCauseCulpritHelper", payloads.Errors[2].Culprit)
+}
+
 func TestErrorCauseCauser(t *testing.T) {
 	err := &causer{
 		error: errors.New("error"),
@@ -584,3 +726,56 @@ func (es errorslice) Error() string {
 func (es errorslice) Cause() error {
 	return es[0]
 }
+
+func TestErrorDeduplication(t *testing.T) {
+	tracer, r := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetErrorDeduplication(time.Hour, 2)
+
+	sendError := func(message string) {
+		e := tracer.NewError(errors.New(message))
+		e.SetStacktrace(0)
+		e.Send()
+	}
+	for i := 0; i < 5; i++ {
+		sendError("boom")
+	}
+	sendError("bang")
+	sendError("bang")
+	sendError("whimper") // exceeds maxDistinct, sent immediately
+	tracer.Flush(nil)
+
+	payloads := r.Payloads()
+	require.Len(t, payloads.Errors, 3)
+	assert.Equal(t, "whimper", payloads.Errors[0].Exception.Message)
+	assert.Nil(t, payloads.Errors[0].Context)
+	assert.Equal(t, "boom", payloads.Errors[1].Exception.Message)
+	assert.Equal(t, model.IfaceMap{{Key: "occurrences", Value: 5.0}}, payloads.Errors[1].Context.Tags)
+	assert.Equal(t, "bang", payloads.Errors[2].Exception.Message)
+	assert.Equal(t, model.IfaceMap{{Key: "occurrences", Value: 2.0}}, payloads.Errors[2].Context.Tags)
+
+	assert.Equal(t, apm.TracerStats{ErrorsSent: 3}, tracer.Stats())
+}
+
+func TestErrorDeduplicationWindow(t *testing.T) {
+	tracer, r := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetErrorDeduplication(10*time.Millisecond, 10)
+
+	for i := 0; i < 2; i++ {
+		tracer.NewError(errors.New("boom")).Send()
+	}
+
+	// The error is sent when the deduplication window ends, without flushing.
+	deadline := time.After(10 * time.Second)
+	for len(r.Payloads().Errors) == 0 {
+		select {
+		case <-deadline:
+			t.Fatal("timed out waiting for error")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	payloads := r.Payloads()
+	require.Len(t, payloads.Errors, 1)
+	assert.Equal(t, model.IfaceMap{{Key: "occurrences", Value: 2.0}}, payloads.Errors[0].Context.Tags)
+}
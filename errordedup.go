@@ -0,0 +1,118 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm
+
+import (
+	"time"
+
+	"go.elastic.co/apm/stacktrace"
+)
+
+// errorDeduplicator collapses identical errors occurring within a window
+// into a single error, labeled with the number of occurrences. Errors are
+// considered identical if they have the same type, message, and top stack
+// frame.
+//
+// errorDeduplicator is used only by the tracer loop, and is not safe for
+// concurrent use.
+type errorDeduplicator struct {
+	pending []pendingError
+	index   map[errorKey]int
+}
+
+type pendingError struct {
+	err   *ErrorData
+	count int
+}
+
+type errorKey struct {
+	typeName    string
+	typePackage string
+	message     string
+	frame       stacktrace.Frame
+}
+
+func newErrorKey(e *ErrorData) errorKey {
+	key := errorKey{
+		typeName:    e.exception.Type.Name,
+		typePackage: e.exception.Type.PackagePath,
+		message:     e.exception.message,
+	}
+	stack := e.exception.stacktrace
+	if key.message == "" {
+		key.message = e.log.Message
+		stack = e.logStacktrace
+	}
+	if len(stack) > 0 {
+		key.frame = stack[0]
+	}
+	return key
+}
+
+// add adds e to the set of pending errors, returning true if e has been
+// retained or discarded as a duplicate. If add returns false, then there
+// are already maxDistinct pending errors and e should be sent immediately.
+func (d *errorDeduplicator) add(e *ErrorData, maxDistinct int) bool {
+	key := newErrorKey(e)
+	if i, ok := d.index[key]; ok {
+		d.pending[i].count++
+		e.reset()
+		return true
+	}
+	if len(d.pending) >= maxDistinct {
+		return false
+	}
+	if d.index == nil {
+		d.index = make(map[errorKey]int)
+	}
+	d.index[key] = len(d.pending)
+	d.pending = append(d.pending, pendingError{err: e, count: 1})
+	return true
+}
+
+// len returns the number of distinct pending errors.
+func (d *errorDeduplicator) len() int {
+	return len(d.pending)
+}
+
+// flush calls f with each pending error, labeling those which occurred
+// more than once with the number of occurrences, and then clears the
+// pending errors.
+func (d *errorDeduplicator) flush(f func(*ErrorData)) {
+	for i, p := range d.pending {
+		if p.count > 1 {
+			p.err.Context.SetLabel("occurrences", p.count)
+		}
+		f(p.err)
+		d.pending[i] = pendingError{}
+	}
+	d.pending = d.pending[:0]
+	for key := range d.index {
+		delete(d.index, key)
+	}
+}
+
+// errorDeduplicationConfig holds the error deduplication configuration.
+type errorDeduplicationConfig struct {
+	window      time.Duration
+	maxDistinct int
+}
+
+func (c errorDeduplicationConfig) enabled() bool {
+	return c.window > 0 && c.maxDistinct > 0
+}
@@ -0,0 +1,140 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm
+
+import (
+	"go.elastic.co/apm/internal/apmstrings"
+	"go.elastic.co/apm/model"
+)
+
+// TransactionFilters returns a transaction filter, for use with
+// Tracer.SetTransactionFilter, which calls each of filters in order.
+// If any of the filters returns false, the transaction is dropped
+// and the remaining filters are not called.
+func TransactionFilters(filters ...func(*model.Transaction) bool) func(*model.Transaction) bool {
+	return func(tx *model.Transaction) bool {
+		for _, filter := range filters {
+			if !filter(tx) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// SpanFilters returns a span filter, for use with Tracer.SetSpanFilter,
+// which calls each of filters in order. If any of the filters returns
+// false, the span is dropped and the remaining filters are not called.
+func SpanFilters(filters ...func(*model.Span) bool) func(*model.Span) bool {
+	return func(span *model.Span) bool {
+		for _, filter := range filters {
+			if !filter(span) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// ErrorFilters returns an error filter, for use with Tracer.SetErrorFilter,
+// which calls each of filters in order. If any of the filters returns
+// false, the error is dropped and the remaining filters are not called.
+func ErrorFilters(filters ...func(*model.Error) bool) func(*model.Error) bool {
+	return func(e *model.Error) bool {
+		for _, filter := range filters {
+			if !filter(e) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// DropSpans returns a span filter, for use with Tracer.SetSpanFilter,
+// which drops spans for which drop returns true.
+func DropSpans(drop func(*model.Span) bool) func(*model.Span) bool {
+	return func(span *model.Span) bool {
+		return !drop(span)
+	}
+}
+
+// RedactTransactionLabels returns a transaction filter, for use with
+// Tracer.SetTransactionFilter, which replaces the values of labels
+// with any of the given keys with "[REDACTED]".
+func RedactTransactionLabels(keys ...string) func(*model.Transaction) bool {
+	return func(tx *model.Transaction) bool {
+		if tx.Context != nil {
+			redactLabels(tx.Context.Tags, keys)
+		}
+		return true
+	}
+}
+
+// RedactSpanLabels returns a span filter, for use with Tracer.SetSpanFilter,
+// which replaces the values of labels with any of the given keys with
+// "[REDACTED]".
+func RedactSpanLabels(keys ...string) func(*model.Span) bool {
+	return func(span *model.Span) bool {
+		if span.Context != nil {
+			redactLabels(span.Context.Tags, keys)
+		}
+		return true
+	}
+}
+
+// RedactErrorLabels returns an error filter, for use with Tracer.SetErrorFilter,
+// which replaces the values of labels with any of the given keys with
+// "[REDACTED]".
+func RedactErrorLabels(keys ...string) func(*model.Error) bool {
+	return func(e *model.Error) bool {
+		if e.Context != nil {
+			redactLabels(e.Context.Tags, keys)
+		}
+		return true
+	}
+}
+
+func redactLabels(labels model.IfaceMap, keys []string) {
+	for i := range labels {
+		for _, key := range keys {
+			if labels[i].Key == key {
+				labels[i].Value = redacted
+				break
+			}
+		}
+	}
+}
+
+// TruncateErrorMessages returns an error filter, for use with
+// Tracer.SetErrorFilter, which truncates the exception and log
+// messages of errors, including those of the exception's causes,
+// to at most maxLength characters.
+func TruncateErrorMessages(maxLength int) func(*model.Error) bool {
+	return func(e *model.Error) bool {
+		e.Log.Message, _ = apmstrings.Truncate(e.Log.Message, maxLength)
+		truncateExceptionMessages(&e.Exception, maxLength)
+		return true
+	}
+}
+
+func truncateExceptionMessages(e *model.Exception, maxLength int) {
+	e.Message, _ = apmstrings.Truncate(e.Message, maxLength)
+	for i := range e.Cause {
+		truncateExceptionMessages(&e.Cause[i], maxLength)
+	}
+}
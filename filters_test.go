@@ -0,0 +1,104 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/model"
+)
+
+func TestFilters(t *testing.T) {
+	var calls []string
+	filter := func(name string, keep bool) func(*model.Span) bool {
+		return func(*model.Span) bool {
+			calls = append(calls, name)
+			return keep
+		}
+	}
+	assert.True(t, apm.SpanFilters()(&model.Span{}))
+	assert.True(t, apm.SpanFilters(filter("a", true), filter("b", true))(&model.Span{}))
+	assert.Equal(t, []string{"a", "b"}, calls)
+
+	// Filters after the first to drop the span are not called.
+	calls = nil
+	assert.False(t, apm.SpanFilters(filter("a", false), filter("b", true))(&model.Span{}))
+	assert.Equal(t, []string{"a"}, calls)
+}
+
+func TestDropSpans(t *testing.T) {
+	filter := apm.DropSpans(func(span *model.Span) bool {
+		return span.Type == "cache"
+	})
+	assert.False(t, filter(&model.Span{Type: "cache"}))
+	assert.True(t, filter(&model.Span{Type: "db"}))
+}
+
+func TestRedactLabels(t *testing.T) {
+	labels := func() model.IfaceMap {
+		return model.IfaceMap{
+			{Key: "password", Value: "hunter2"},
+			{Key: "tenant", Value: "acme"},
+			{Key: "token", Value: 123},
+		}
+	}
+	redactedLabels := model.IfaceMap{
+		{Key: "password", Value: "[REDACTED]"},
+		{Key: "tenant", Value: "acme"},
+		{Key: "token", Value: "[REDACTED]"},
+	}
+
+	tx := &model.Transaction{Context: &model.Context{Tags: labels()}}
+	assert.True(t, apm.RedactTransactionLabels("password", "token")(tx))
+	assert.Equal(t, redactedLabels, tx.Context.Tags)
+
+	span := &model.Span{Context: &model.SpanContext{Tags: labels()}}
+	assert.True(t, apm.RedactSpanLabels("password", "token")(span))
+	assert.Equal(t, redactedLabels, span.Context.Tags)
+
+	e := &model.Error{Context: &model.Context{Tags: labels()}}
+	assert.True(t, apm.RedactErrorLabels("password", "token")(e))
+	assert.Equal(t, redactedLabels, e.Context.Tags)
+
+	// Events without context are left alone.
+	assert.True(t, apm.RedactTransactionLabels("password")(&model.Transaction{}))
+	assert.True(t, apm.RedactSpanLabels("password")(&model.Span{}))
+	assert.True(t, apm.RedactErrorLabels("password")(&model.Error{}))
+}
+
+func TestTruncateErrorMessages(t *testing.T) {
+	e := &model.Error{
+		Exception: model.Exception{
+			Message: "exception message",
+			Cause:   []model.Exception{{Message: "cause message"}},
+		},
+		Log: model.Log{Message: "log message"},
+	}
+	assert.True(t, apm.TruncateErrorMessages(5)(e))
+	assert.Equal(t, "excep", e.Exception.Message)
+	assert.Equal(t, "cause", e.Exception.Cause[0].Message)
+	assert.Equal(t, "log m", e.Log.Message)
+
+	// Messages are truncated at character boundaries.
+	e = &model.Error{Exception: model.Exception{Message: "ÿÿÿÿ"}}
+	apm.TruncateErrorMessages(2)(e)
+	assert.Equal(t, "ÿÿ", e.Exception.Message)
+}
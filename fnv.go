@@ -40,3 +40,10 @@ func (f *fnv1a) add(s string) {
 		*f *= prime64
 	}
 }
+
+func (f *fnv1a) addBytes(b []byte) {
+	for i := 0; i < len(b); i++ {
+		*f ^= fnv1a(b[i])
+		*f *= prime64
+	}
+}
@@ -35,9 +35,37 @@ func ContextWithTransaction(parent context.Context, t *Transaction) context.Cont
 	return apmcontext.ContextWithTransaction(parent, t)
 }
 
+// ContextWithInternalOperation returns a copy of parent which is marked
+// as being part of an internal operation of the agent, such as sending
+// data to the APM Server. StartSpan and StartSpanOptions return dropped
+// spans for such contexts, and instrumentation modules do not create
+// spans for them, preventing the agent from tracing itself recursively.
+//
+// The tracer marks the contexts of its own operations; this may also be
+// used for operations performed on behalf of the agent, e.g. within a
+// custom Transport or Processor.
+func ContextWithInternalOperation(parent context.Context) context.Context {
+	return context.WithValue(parent, internalOperationKey{}, true)
+}
+
+// IsInternalOperation reports whether ctx was marked as being part of an
+// internal operation of the agent, using ContextWithInternalOperation.
+func IsInternalOperation(ctx context.Context) bool {
+	internal, _ := ctx.Value(internalOperationKey{}).(bool)
+	return internal
+}
+
+type internalOperationKey struct{}
+
 // SpanFromContext returns the current Span in context, if any. The span must
 // have been added to the context previously using ContextWithSpan, or the
 // top-level StartSpan function.
+//
+// SpanFromContext returns nil if ctx does not contain a span. This is the
+// case for contexts containing only a transaction, and for contexts returned
+// by StartSpan when the span was dropped, since dropped spans are not added
+// to the context. In either case, StartSpan will use the transaction in the
+// context, if any, as the parent of new spans.
 func SpanFromContext(ctx context.Context) *Span {
 	value, _ := apmcontext.SpanFromContext(ctx).(*Span)
 	return value
@@ -81,11 +109,15 @@ func StartSpan(ctx context.Context, name, spanType string) (*Span, context.Conte
 // stored in the resulting context.
 //
 // If opts.Parent is non-zero, its value will be used in preference to any parent
-// span in ctx.
+// span in ctx. If ctx is marked as an internal operation of the agent (see
+// ContextWithInternalOperation), the returned span is dropped.
 //
 // StartSpanOptions always returns a non-nil Span. Its End method must be called
 // when the span completes.
 func StartSpanOptions(ctx context.Context, name, spanType string, opts SpanOptions) (*Span, context.Context) {
+	if IsInternalOperation(ctx) {
+		return newDroppedSpan(), ctx
+	}
 	var span *Span
 	if opts.parent = SpanFromContext(ctx); opts.parent != nil {
 		if opts.parent.tx == nil && opts.parent.tracer != nil {
@@ -106,7 +138,8 @@ func StartSpanOptions(ctx context.Context, name, spanType string, opts SpanOptio
 // CaptureError returns a new Error related to the sampled transaction
 // and span present in the context, if any, and sets its exception info
 // from err. The Error.Handled field will be set to true, and a stacktrace
-// set either from err, or from the caller.
+// set either from err, or from the caller, unless the tracer has been
+// configured with SetHandledErrorStacktraces(false).
 //
 // If the provided error is nil, then CaptureError will also return nil;
 // otherwise a non-nil Error will always be returned. If there is no
@@ -120,16 +153,14 @@ func CaptureError(ctx context.Context, err error) *Error {
 		if span.tracer == nil {
 			return &Error{cause: err, err: err.Error()}
 		}
-		e := span.tracer.NewError(err)
-		e.Handled = true
+		e := span.tracer.newErrorFromError(err, true)
 		e.SetSpan(span)
 		return e
 	} else if tx := TransactionFromContext(ctx); tx != nil {
 		if tx.tracer == nil {
 			return &Error{cause: err, err: err.Error()}
 		}
-		e := tx.tracer.NewError(err)
-		e.Handled = true
+		e := tx.tracer.newErrorFromError(err, true)
 		e.SetTransaction(tx)
 		return e
 	} else {
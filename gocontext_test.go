@@ -19,6 +19,8 @@ package apm_test
 
 import (
 	"context"
+	"io"
+	"io/ioutil"
 	"sync"
 	"testing"
 	"time"
@@ -125,6 +127,29 @@ func TestContextStartSpanOptions(t *testing.T) {
 	assert.Equal(t, model.Time(span0Start), spans[3].Timestamp)
 }
 
+func TestSpanFromContext(t *testing.T) {
+	assert.Nil(t, apm.SpanFromContext(context.Background()))
+
+	tracer := apmtest.NewDiscardTracer()
+	defer tracer.Close()
+	tracer.SetMaxSpans(1)
+
+	tx := tracer.StartTransaction("name", "type")
+	defer tx.End()
+	ctx := apm.ContextWithTransaction(context.Background(), tx)
+	assert.Nil(t, apm.SpanFromContext(ctx))
+
+	span1, ctx := apm.StartSpan(ctx, "span1", "type")
+	defer span1.End()
+	assert.Equal(t, span1, apm.SpanFromContext(ctx))
+
+	// span2 is dropped, so it is not added to the context.
+	span2, ctx := apm.StartSpan(ctx, "span2", "type")
+	defer span2.End()
+	assert.True(t, span2.Dropped())
+	assert.Equal(t, span1, apm.SpanFromContext(ctx))
+}
+
 func TestDetachedContext(t *testing.T) {
 	funcB := func(ctx context.Context) chan chan error {
 		chch := make(chan chan error)
@@ -173,3 +198,41 @@ func TestDetachedContext(t *testing.T) {
 		assert.Equal(t, tx.TraceID, span.TraceID)
 	}
 }
+
+func TestContextInternalOperation(t *testing.T) {
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		assert.False(t, apm.IsInternalOperation(ctx))
+		internalCtx := apm.ContextWithInternalOperation(ctx)
+		assert.True(t, apm.IsInternalOperation(internalCtx))
+
+		span, spanCtx := apm.StartSpan(internalCtx, "internal", "type")
+		assert.True(t, span.Dropped())
+		assert.Equal(t, internalCtx, spanCtx)
+		span.End()
+
+		span, _ = apm.StartSpan(ctx, "external", "type")
+		span.End()
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, "external", spans[0].Name)
+}
+
+func TestTracerInternalOperationContext(t *testing.T) {
+	internal := make(chan bool, 1)
+	tracer, err := apm.NewTracerOptions(apm.TracerOptions{
+		Transport: sendStreamFunc(func(ctx context.Context, r io.Reader) error {
+			select {
+			case internal <- apm.IsInternalOperation(ctx):
+			default:
+			}
+			_, err := ioutil.ReadAll(r)
+			return err
+		}),
+	})
+	require.NoError(t, err)
+	defer tracer.Close()
+
+	tracer.StartTransaction("name", "type").End()
+	tracer.Flush(nil)
+	assert.True(t, <-internal)
+}
@@ -0,0 +1,104 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+var (
+	instrumentationsMu sync.Mutex
+	instrumentations   = make(map[string]struct{})
+)
+
+// Instrumentation identifies an instrumentation module, such as one of
+// the packages under go.elastic.co/apm/module, so that spans created by
+// the module can be disabled using the ELASTIC_APM_DISABLE_INSTRUMENTATIONS
+// environment variable or Tracer.SetDisabledInstrumentations.
+//
+// Instrumentation modules should register themselves once, and then start
+// spans using the Instrumentation's StartSpan or StartSpanOptions methods,
+// or by first checking Enabled.
+type Instrumentation struct {
+	name string
+}
+
+// RegisterInstrumentation registers an instrumentation module with the
+// given name, and returns an Instrumentation for it. Multiple modules may
+// register with the same name, e.g. "redis" for each Redis client module,
+// in which case they are enabled or disabled together.
+func RegisterInstrumentation(name string) *Instrumentation {
+	instrumentationsMu.Lock()
+	instrumentations[name] = struct{}{}
+	instrumentationsMu.Unlock()
+	return &Instrumentation{name: name}
+}
+
+// RegisteredInstrumentations returns the sorted names of the registered
+// instrumentation modules.
+func RegisteredInstrumentations() []string {
+	instrumentationsMu.Lock()
+	names := make([]string, 0, len(instrumentations))
+	for name := range instrumentations {
+		names = append(names, name)
+	}
+	instrumentationsMu.Unlock()
+	sort.Strings(names)
+	return names
+}
+
+// Name returns the name with which the instrumentation module was registered.
+func (i *Instrumentation) Name() string {
+	return i.name
+}
+
+// Enabled reports whether the instrumentation module is enabled for the
+// tracer of the transaction or span in ctx. If ctx contains neither a
+// transaction nor a span, Enabled returns true.
+func (i *Instrumentation) Enabled(ctx context.Context) bool {
+	var tracer *Tracer
+	if span := SpanFromContext(ctx); span != nil {
+		tracer = span.tracer
+		if tracer == nil && span.tx != nil {
+			tracer = span.tx.tracer
+		}
+	} else if tx := TransactionFromContext(ctx); tx != nil {
+		tracer = tx.tracer
+	}
+	if tracer == nil {
+		return true
+	}
+	return !tracer.instrumentationConfig().disabledInstrumentations.MatchAny(i.name)
+}
+
+// StartSpan is equivalent to calling StartSpanOptions with a zero SpanOptions struct.
+func (i *Instrumentation) StartSpan(ctx context.Context, name, spanType string) (*Span, context.Context) {
+	return i.StartSpanOptions(ctx, name, spanType, SpanOptions{})
+}
+
+// StartSpanOptions is equivalent to the package-level StartSpanOptions
+// function, except that if the instrumentation module is disabled, the
+// returned span is dropped and ctx is returned unmodified.
+func (i *Instrumentation) StartSpanOptions(ctx context.Context, name, spanType string, opts SpanOptions) (*Span, context.Context) {
+	if !i.Enabled(ctx) {
+		return newDroppedSpan(), ctx
+	}
+	return StartSpanOptions(ctx, name, spanType, opts)
+}
@@ -0,0 +1,92 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/transport/transporttest"
+)
+
+func TestRegisterInstrumentation(t *testing.T) {
+	instrumentation := apm.RegisterInstrumentation("registered")
+	assert.Equal(t, "registered", instrumentation.Name())
+	assert.Contains(t, apm.RegisteredInstrumentations(), "registered")
+
+	// Without a transaction or span in the context,
+	// the instrumentation is considered enabled.
+	assert.True(t, instrumentation.Enabled(context.Background()))
+}
+
+func TestTracerSetDisabledInstrumentations(t *testing.T) {
+	tracer, r := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	foo := apm.RegisterInstrumentation("foo")
+	bar := apm.RegisterInstrumentation("bar")
+	tracer.SetDisabledInstrumentations("f*")
+
+	tx := tracer.StartTransaction("name", "type")
+	ctx := apm.ContextWithTransaction(context.Background(), tx)
+	assert.False(t, foo.Enabled(ctx))
+	assert.True(t, bar.Enabled(ctx))
+
+	span, spanCtx := foo.StartSpan(ctx, "foo", "type")
+	assert.True(t, span.Dropped())
+	assert.Equal(t, ctx, spanCtx)
+	span.End()
+
+	span, spanCtx = bar.StartSpan(ctx, "bar", "type")
+	assert.False(t, span.Dropped())
+	assert.False(t, foo.Enabled(spanCtx))
+	span.End()
+
+	// Changes take effect for new spans.
+	tracer.SetDisabledInstrumentations()
+	span, _ = foo.StartSpan(ctx, "foo", "type")
+	assert.False(t, span.Dropped())
+	span.End()
+	tx.End()
+
+	tracer.Flush(nil)
+	payloads := r.Payloads()
+	require.Len(t, payloads.Spans, 2)
+	assert.Equal(t, "bar", payloads.Spans[0].Name)
+	assert.Equal(t, "foo", payloads.Spans[1].Name)
+}
+
+func TestTracerDisabledInstrumentationsEnv(t *testing.T) {
+	os.Setenv("ELASTIC_APM_DISABLE_INSTRUMENTATIONS", "sql, redis")
+	defer os.Unsetenv("ELASTIC_APM_DISABLE_INSTRUMENTATIONS")
+
+	tracer, _ := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	defer tx.End()
+	ctx := apm.ContextWithTransaction(context.Background(), tx)
+	assert.False(t, apm.RegisterInstrumentation("sql").Enabled(ctx))
+	assert.False(t, apm.RegisterInstrumentation("redis").Enabled(ctx))
+	assert.True(t, apm.RegisterInstrumentation("http").Enabled(ctx))
+}
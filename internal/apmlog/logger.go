@@ -27,6 +27,8 @@ import (
 	"time"
 
 	"go.elastic.co/fastjson"
+
+	"go.elastic.co/apm/internal/configutil"
 )
 
 var (
@@ -45,7 +47,7 @@ func init() {
 }
 
 func initDefaultLogger() {
-	fileStr := strings.TrimSpace(os.Getenv("ELASTIC_APM_LOG_FILE"))
+	fileStr := strings.TrimSpace(configutil.Getenv("ELASTIC_APM_LOG_FILE"))
 	if fileStr == "" {
 		return
 	}
@@ -66,7 +68,7 @@ func initDefaultLogger() {
 	}
 
 	logLevel := errorLevel
-	if levelStr := strings.TrimSpace(os.Getenv("ELASTIC_APM_LOG_LEVEL")); levelStr != "" {
+	if levelStr := strings.TrimSpace(configutil.Getenv("ELASTIC_APM_LOG_LEVEL")); levelStr != "" {
 		level, err := parseLogLevel(levelStr)
 		if err != nil {
 			log.Printf("invalid ELASTIC_APM_LOG_LEVEL %q, falling back to %q", levelStr, logLevel)
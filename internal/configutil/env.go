@@ -18,7 +18,6 @@
 package configutil
 
 import (
-	"os"
 	"strconv"
 	"time"
 
@@ -31,7 +30,7 @@ import (
 // and, if set, parses it as a duration. If the environment variable
 // is unset, defaultDuration is returned.
 func ParseDurationEnv(envKey string, defaultDuration time.Duration) (time.Duration, error) {
-	value := os.Getenv(envKey)
+	value := Getenv(envKey)
 	if value == "" {
 		return defaultDuration, nil
 	}
@@ -46,7 +45,7 @@ func ParseDurationEnv(envKey string, defaultDuration time.Duration) (time.Durati
 // and, if set, parses it as a size. If the environment variable
 // is unset, defaultSize is returned.
 func ParseSizeEnv(envKey string, defaultSize Size) (Size, error) {
-	value := os.Getenv(envKey)
+	value := Getenv(envKey)
 	if value == "" {
 		return defaultSize, nil
 	}
@@ -57,11 +56,26 @@ func ParseSizeEnv(envKey string, defaultSize Size) (Size, error) {
 	return s, nil
 }
 
+// ParseIntEnv gets the value of the environment variable envKey
+// and, if set, parses it as an integer. If the environment variable
+// is unset, defaultValue is returned.
+func ParseIntEnv(envKey string, defaultValue int) (int, error) {
+	value := Getenv(envKey)
+	if value == "" {
+		return defaultValue, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to parse %s", envKey)
+	}
+	return i, nil
+}
+
 // ParseBoolEnv gets the value of the environment variable envKey
 // and, if set, parses it as a boolean. If the environment variable
 // is unset, defaultValue is returned.
 func ParseBoolEnv(envKey string, defaultValue bool) (bool, error) {
-	value := os.Getenv(envKey)
+	value := Getenv(envKey)
 	if value == "" {
 		return defaultValue, nil
 	}
@@ -76,7 +90,7 @@ func ParseBoolEnv(envKey string, defaultValue bool) (bool, error) {
 // and, if set, parses it as a list separated by sep. If the environment
 // variable is unset, defaultValue is returned.
 func ParseListEnv(envKey, sep string, defaultValue []string) []string {
-	value := os.Getenv(envKey)
+	value := Getenv(envKey)
 	if value == "" {
 		return defaultValue
 	}
@@ -87,7 +101,7 @@ func ParseListEnv(envKey, sep string, defaultValue []string) []string {
 // and, if set, parses it as a list of wildcard patterns. If the environment
 // variable is unset, defaultValue is returned.
 func ParseWildcardPatternsEnv(envKey string, defaultValue wildcard.Matchers) wildcard.Matchers {
-	value := os.Getenv(envKey)
+	value := Getenv(envKey)
 	if value == "" {
 		return defaultValue
 	}
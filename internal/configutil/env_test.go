@@ -120,6 +120,25 @@ func TestParseSizeEnv(t *testing.T) {
 	assert.EqualError(t, err, "failed to parse ELASTIC_APM_TEST_SIZE: invalid size blah")
 }
 
+func TestParseIntEnv(t *testing.T) {
+	const envKey = "ELASTIC_APM_TEST_INT"
+	os.Unsetenv(envKey)
+	defer os.Unsetenv(envKey)
+
+	i, err := configutil.ParseIntEnv(envKey, 42)
+	assert.NoError(t, err)
+	assert.Equal(t, 42, i)
+
+	os.Setenv(envKey, "-1")
+	i, err = configutil.ParseIntEnv(envKey, 42)
+	assert.NoError(t, err)
+	assert.Equal(t, -1, i)
+
+	os.Setenv(envKey, "blah")
+	_, err = configutil.ParseIntEnv(envKey, 42)
+	assert.EqualError(t, err, `failed to parse ELASTIC_APM_TEST_INT: strconv.Atoi: parsing "blah": invalid syntax`)
+}
+
 func TestParseBoolEnv(t *testing.T) {
 	const envKey = "ELASTIC_APM_TEST_BOOL"
 	os.Unsetenv(envKey)
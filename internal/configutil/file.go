@@ -0,0 +1,230 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package configutil
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	envConfigFile = "ELASTIC_APM_CONFIG_FILE"
+	envKeyPrefix  = "ELASTIC_APM_"
+)
+
+var (
+	configFileOnce   sync.Once
+	configFileValues map[string]string
+)
+
+// Getenv returns the value of the environment variable envKey. If the
+// environment variable is unset or empty, and a configuration file has
+// been specified with ELASTIC_APM_CONFIG_FILE, then the value of envKey
+// in the configuration file is returned instead, if any.
+//
+// The configuration file is read the first time Getenv is called. If the
+// file cannot be read or parsed, the error is logged and the file ignored.
+func Getenv(envKey string) string {
+	if value := os.Getenv(envKey); value != "" {
+		return value
+	}
+	configFileOnce.Do(func() {
+		path := os.Getenv(envConfigFile)
+		if path == "" {
+			return
+		}
+		values, err := ReadConfigFile(path)
+		if err != nil {
+			log.Printf("[apm]: failed to load %s: %s (ignoring)", envConfigFile, err)
+			return
+		}
+		configFileValues = values
+	})
+	return configFileValues[envKey]
+}
+
+// ReadConfigFile reads the configuration file at path, returning its
+// values keyed by environment variable name.
+//
+// Files with the extension ".json" are decoded as a JSON object, and all
+// other files as YAML. In either case, the file must hold a flat mapping
+// of keys to scalar values, or lists of scalar values, which are joined
+// with commas. Keys may be specified as environment variable names, e.g.
+// "ELASTIC_APM_SERVER_URL", or without the "ELASTIC_APM_" prefix and in
+// any case, e.g. "server_url".
+func ReadConfigFile(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]string
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		values, err = parseJSONConfig(data)
+	} else {
+		values, err = parseYAMLConfig(data)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", path)
+	}
+	return values, nil
+}
+
+func configFileKey(key string) string {
+	key = strings.ToUpper(strings.TrimSpace(key))
+	if !strings.HasPrefix(key, envKeyPrefix) {
+		key = envKeyPrefix + key
+	}
+	return key
+}
+
+func parseJSONConfig(data []byte) (map[string]string, error) {
+	var object map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&object); err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(object))
+	for key, value := range object {
+		var s string
+		if list, ok := value.([]interface{}); ok {
+			elems := make([]string, len(list))
+			for i, elem := range list {
+				elemString, err := jsonScalarString(elem)
+				if err != nil {
+					return nil, errors.Wrapf(err, "invalid value for %q", key)
+				}
+				elems[i] = elemString
+			}
+			s = strings.Join(elems, ",")
+		} else {
+			var err error
+			if s, err = jsonScalarString(value); err != nil {
+				return nil, errors.Wrapf(err, "invalid value for %q", key)
+			}
+		}
+		values[configFileKey(key)] = s
+	}
+	return values, nil
+}
+
+func jsonScalarString(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case json.Number:
+		return value.String(), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	}
+	return "", fmt.Errorf("expected a string, number, or boolean, got %T", value)
+}
+
+// parseYAMLConfig parses a YAML document holding a flat mapping of keys
+// to scalar values, or flow sequences of scalar values such as "[a, b]".
+func parseYAMLConfig(data []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "---" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, fmt.Errorf("line %d: nested values are not supported", lineno)
+		}
+		colon := strings.Index(line, ":")
+		if colon <= 0 {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", lineno)
+		}
+		key := line[:colon]
+		value, err := parseYAMLValue(strings.TrimSpace(line[colon+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineno, err)
+		}
+		values[configFileKey(key)] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func parseYAMLValue(value string) (string, error) {
+	if strings.HasPrefix(value, "[") {
+		end := strings.LastIndex(value, "]")
+		if end == -1 || stripYAMLComment(value[end+1:]) != "" {
+			return "", errors.New("invalid flow sequence")
+		}
+		var elems []string
+		for _, elem := range strings.Split(value[1:end], ",") {
+			elem, err := parseYAMLScalar(strings.TrimSpace(elem))
+			if err != nil {
+				return "", err
+			}
+			elems = append(elems, elem)
+		}
+		return strings.Join(elems, ","), nil
+	}
+	if value == "" || value == "|" || value == ">" {
+		return "", errors.New("nested values are not supported")
+	}
+	return parseYAMLScalar(value)
+}
+
+func parseYAMLScalar(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		end := strings.LastIndex(value, `"`)
+		if end == 0 || stripYAMLComment(value[end+1:]) != "" {
+			return "", errors.New("unterminated string")
+		}
+		return strconv.Unquote(value[:end+1])
+	case strings.HasPrefix(value, "'"):
+		end := strings.LastIndex(value, "'")
+		if end == 0 || stripYAMLComment(value[end+1:]) != "" {
+			return "", errors.New("unterminated string")
+		}
+		return strings.Replace(value[1:end], "''", "'", -1), nil
+	}
+	return stripYAMLComment(value), nil
+}
+
+// stripYAMLComment returns s with any trailing comment, introduced
+// by a '#' at the start of s or following whitespace, removed.
+func stripYAMLComment(s string) string {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t') {
+			s = s[:i]
+			break
+		}
+	}
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,127 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package configutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadConfigFileJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configutil")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := writeConfigFile(t, dir, "config.json", `{
+  "server_url": "http://apm.testing:8200",
+  "ELASTIC_APM_SECRET_TOKEN": "secret",
+  "transaction_sample_rate": 0.5,
+  "breakdown_metrics": false,
+  "sanitize_field_names": ["password", "*token*"]
+}`)
+	values, err := ReadConfigFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"ELASTIC_APM_SERVER_URL":              "http://apm.testing:8200",
+		"ELASTIC_APM_SECRET_TOKEN":            "secret",
+		"ELASTIC_APM_TRANSACTION_SAMPLE_RATE": "0.5",
+		"ELASTIC_APM_BREAKDOWN_METRICS":       "false",
+		"ELASTIC_APM_SANITIZE_FIELD_NAMES":    "password,*token*",
+	}, values)
+
+	path = writeConfigFile(t, dir, "invalid.json", `{"server_url": {"nested": true}}`)
+	_, err = ReadConfigFile(path)
+	assert.EqualError(t, err, "failed to parse "+path+
+		`: invalid value for "server_url": expected a string, number, or boolean, got map[string]interface {}`)
+}
+
+func TestReadConfigFileYAML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configutil")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := writeConfigFile(t, dir, "config.yml", `---
+# APM configuration
+server_url: http://apm.testing:8200 # comment
+ELASTIC_APM_SECRET_TOKEN: "secret # not a comment"
+service_name: 'it''s'
+transaction_sample_rate: 0.5
+sanitize_field_names: [password, "*token*"]
+`)
+	values, err := ReadConfigFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"ELASTIC_APM_SERVER_URL":              "http://apm.testing:8200",
+		"ELASTIC_APM_SECRET_TOKEN":            "secret # not a comment",
+		"ELASTIC_APM_SERVICE_NAME":            "it's",
+		"ELASTIC_APM_TRANSACTION_SAMPLE_RATE": "0.5",
+		"ELASTIC_APM_SANITIZE_FIELD_NAMES":    "password,*token*",
+	}, values)
+
+	for content, expect := range map[string]string{
+		"server_url:\n  host: apm": "line 1: nested values are not supported",
+		"server_url":               `line 1: expected "key: value"`,
+		`secret_token: "secret`:    "line 1: unterminated string",
+	} {
+		_, err := ReadConfigFile(writeConfigFile(t, dir, "invalid.yml", content))
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), expect)
+		}
+	}
+}
+
+func TestGetenvConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configutil")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := writeConfigFile(t, dir, "config.yml", "server_url: http://file.testing\nsecret_token: secret\n")
+	os.Setenv(envConfigFile, path)
+	os.Setenv("ELASTIC_APM_SERVER_URL", "http://env.testing")
+	defer os.Unsetenv(envConfigFile)
+	defer os.Unsetenv("ELASTIC_APM_SERVER_URL")
+	resetConfigFile()
+	defer resetConfigFile()
+
+	// Environment variables override the configuration file.
+	assert.Equal(t, "http://env.testing", Getenv("ELASTIC_APM_SERVER_URL"))
+	assert.Equal(t, "secret", Getenv("ELASTIC_APM_SECRET_TOKEN"))
+	assert.Equal(t, "", Getenv("ELASTIC_APM_SERVICE_NAME"))
+
+	// Invalid configuration files are ignored.
+	os.Setenv(envConfigFile, writeConfigFile(t, dir, "invalid.json", "{"))
+	resetConfigFile()
+	assert.Equal(t, "", Getenv("ELASTIC_APM_SECRET_TOKEN"))
+}
+
+func resetConfigFile() {
+	configFileOnce = sync.Once{}
+	configFileValues = nil
+}
+
+func writeConfigFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
@@ -18,11 +18,14 @@
 package model
 
 import (
+	"bytes"
 	"encoding/hex"
 	"encoding/json"
+	"math"
 	"net/http"
 	"net/url"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -53,7 +56,8 @@ func (t *Time) UnmarshalJSON(data []byte) error {
 func (v *HTTPSpanContext) UnmarshalJSON(data []byte) error {
 	var httpSpanContext struct {
 		URL        string
-		StatusCode int `json:"status_code"`
+		StatusCode int                      `json:"status_code"`
+		Response   *HTTPSpanContextResponse `json:"response"`
 	}
 	if err := json.Unmarshal(data, &httpSpanContext); err != nil {
 		return err
@@ -64,6 +68,7 @@ func (v *HTTPSpanContext) UnmarshalJSON(data []byte) error {
 	}
 	v.URL = u
 	v.StatusCode = httpSpanContext.StatusCode
+	v.Response = httpSpanContext.Response
 	return nil
 }
 
@@ -81,6 +86,11 @@ func (v *HTTPSpanContext) MarshalFastJSON(w *fastjson.Writer) error {
 		w.RawString(`,"status_code":`)
 		w.Int64(int64(v.StatusCode))
 	}
+	if v.Response != nil && len(v.Response.Headers) != 0 {
+		w.RawString(`,"response":{"headers":`)
+		v.Response.Headers.MarshalFastJSON(w)
+		w.RawByte('}')
+	}
 	w.RawByte('}')
 	return nil
 }
@@ -553,23 +563,49 @@ func (m IfaceMap) MarshalFastJSON(w *fastjson.Writer) (firstErr error) {
 		}
 		w.String(item.Key)
 		w.RawByte(':')
-		if err := fastjson.Marshal(w, item.Value); err != nil && firstErr == nil {
-			firstErr = err
+		switch v := item.Value.(type) {
+		case float64:
+			marshalFloat(w, v, 64)
+		case float32:
+			marshalFloat(w, float64(v), 32)
+		default:
+			if err := fastjson.Marshal(w, item.Value); err != nil && firstErr == nil {
+				firstErr = err
+			}
 		}
 	}
 	w.RawByte('}')
 	return nil
 }
 
+// marshalFloat writes the JSON representation of f to w, without
+// using scientific notation, which is not accepted by the server
+// for label values. Integral values are written without a fraction.
+func marshalFloat(w *fastjson.Writer, f float64, bitSize int) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		// Not representable in JSON; retain the existing behaviour.
+		w.Float64(f)
+		return
+	}
+	var buf [32]byte
+	w.RawBytes(strconv.AppendFloat(buf[:0], f, 'f', -1, bitSize))
+}
+
 // UnmarshalJSON unmarshals the JSON data into m.
+//
+// Numbers are decoded as float64, except for integers which
+// cannot be represented exactly as float64, which are decoded
+// as int64 or uint64 to avoid losing precision.
 func (m *IfaceMap) UnmarshalJSON(data []byte) error {
 	var mm map[string]interface{}
-	if err := json.Unmarshal(data, &mm); err != nil {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&mm); err != nil {
 		return err
 	}
 	*m = make(IfaceMap, 0, len(mm))
 	for k, v := range mm {
-		*m = append(*m, IfaceMapItem{Key: k, Value: v})
+		*m = append(*m, IfaceMapItem{Key: k, Value: convertJSONNumbers(v)})
 	}
 	sort.Slice(*m, func(i, j int) bool {
 		return (*m)[i].Key < (*m)[j].Key
@@ -577,6 +613,34 @@ func (m *IfaceMap) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// convertJSONNumbers replaces json.Number values within v with
+// float64, int64, or uint64 values, as described for
+// IfaceMap.UnmarshalJSON.
+func convertJSONNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		f, _ := v.Float64()
+		if !strings.ContainsAny(string(v), ".eE") && (f >= 1<<53 || f <= -(1<<53)) {
+			if i, err := v.Int64(); err == nil {
+				return i
+			}
+			if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+				return u
+			}
+		}
+		return f
+	case map[string]interface{}:
+		for k, elem := range v {
+			v[k] = convertJSONNumbers(elem)
+		}
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = convertJSONNumbers(elem)
+		}
+	}
+	return v
+}
+
 // MarshalFastJSON exists to prevent code generation for IfaceMapItem.
 func (*IfaceMapItem) MarshalFastJSON(*fastjson.Writer) error {
 	panic("unreachable")
@@ -380,6 +380,10 @@ func (v *Transaction) MarshalFastJSON(w *fastjson.Writer) error {
 			firstErr = err
 		}
 	}
+	if v.Outcome != "" {
+		w.RawString(",\"outcome\":")
+		w.String(v.Outcome)
+	}
 	if !v.ParentID.isZero() {
 		w.RawString(",\"parent_id\":")
 		if err := v.ParentID.MarshalFastJSON(w); err != nil && firstErr == nil {
@@ -437,12 +441,26 @@ func (v *Span) MarshalFastJSON(w *fastjson.Writer) error {
 		w.RawString(",\"action\":")
 		w.String(v.Action)
 	}
+	if v.Composite != nil {
+		w.RawString(",\"composite\":")
+		if err := v.Composite.MarshalFastJSON(w); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
 	if v.Context != nil {
 		w.RawString(",\"context\":")
 		if err := v.Context.MarshalFastJSON(w); err != nil && firstErr == nil {
 			firstErr = err
 		}
 	}
+	if v.ErrorCount != 0 {
+		w.RawString(",\"error_count\":")
+		w.Int64(int64(v.ErrorCount))
+	}
+	if v.Outcome != "" {
+		w.RawString(",\"outcome\":")
+		w.String(v.Outcome)
+	}
 	if !v.ParentID.isZero() {
 		w.RawString(",\"parent_id\":")
 		if err := v.ParentID.MarshalFastJSON(w); err != nil && firstErr == nil {
@@ -470,6 +488,18 @@ func (v *Span) MarshalFastJSON(w *fastjson.Writer) error {
 	return firstErr
 }
 
+func (v *CompositeSpan) MarshalFastJSON(w *fastjson.Writer) error {
+	w.RawByte('{')
+	w.RawString("\"compression_strategy\":")
+	w.String(v.CompressionStrategy)
+	w.RawString(",\"count\":")
+	w.Int64(int64(v.Count))
+	w.RawString(",\"sum\":")
+	w.Float64(v.Sum)
+	w.RawByte('}')
+	return nil
+}
+
 func (v *SpanContext) MarshalFastJSON(w *fastjson.Writer) error {
 	var firstErr error
 	w.RawByte('{')
@@ -510,6 +540,18 @@ func (v *SpanContext) MarshalFastJSON(w *fastjson.Writer) error {
 			firstErr = err
 		}
 	}
+	if v.Service != nil {
+		const prefix = ",\"service\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		if err := v.Service.MarshalFastJSON(w); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
 	if !v.Tags.isZero() {
 		const prefix = ",\"tags\":"
 		if first {
@@ -603,6 +645,46 @@ func (v *DestinationServiceSpanContext) MarshalFastJSON(w *fastjson.Writer) erro
 	return nil
 }
 
+func (v *ServiceSpanContext) MarshalFastJSON(w *fastjson.Writer) error {
+	var firstErr error
+	w.RawByte('{')
+	if v.Target != nil {
+		w.RawString("\"target\":")
+		if err := v.Target.MarshalFastJSON(w); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	w.RawByte('}')
+	return firstErr
+}
+
+func (v *ServiceTargetSpanContext) MarshalFastJSON(w *fastjson.Writer) error {
+	w.RawByte('{')
+	first := true
+	if v.Name != "" {
+		const prefix = ",\"name\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.String(v.Name)
+	}
+	if v.Type != "" {
+		const prefix = ",\"type\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.String(v.Type)
+	}
+	w.RawByte('}')
+	return nil
+}
+
 func (v *DatabaseSpanContext) MarshalFastJSON(w *fastjson.Writer) error {
 	w.RawByte('{')
 	first := true
@@ -19,6 +19,7 @@ package model_test
 
 import (
 	"encoding/json"
+	"math"
 	"net/http"
 	"net/url"
 	"strings"
@@ -455,6 +456,39 @@ func TestTransactionUnmarshalJSON(t *testing.T) {
 	assert.Equal(t, tx, out)
 }
 
+func TestMarshalIfaceMapNumbers(t *testing.T) {
+	in := model.IfaceMap{
+		{Key: "float", Value: 123.456},
+		{Key: "float_large", Value: 1e21},
+		{Key: "float_small", Value: float32(1e-7)},
+		{Key: "int64", Value: int64(9007199254740993)},
+		{Key: "int64_min", Value: int64(math.MinInt64)},
+		{Key: "uint64_max", Value: uint64(math.MaxUint64)},
+	}
+	var w fastjson.Writer
+	require.NoError(t, in.MarshalFastJSON(&w))
+	assert.Equal(t, `{`+
+		`"float":123.456,`+
+		`"float_large":1000000000000000000000,`+
+		`"float_small":0.0000001,`+
+		`"int64":9007199254740993,`+
+		`"int64_min":-9223372036854775808,`+
+		`"uint64_max":18446744073709551615}`,
+		string(w.Bytes()),
+	)
+
+	var out model.IfaceMap
+	require.NoError(t, json.Unmarshal(w.Bytes(), &out))
+	assert.Equal(t, model.IfaceMap{
+		{Key: "float", Value: 123.456},
+		{Key: "float_large", Value: 1e21},
+		{Key: "float_small", Value: 1e-7},
+		{Key: "int64", Value: int64(9007199254740993)},
+		{Key: "int64_min", Value: int64(math.MinInt64)},
+		{Key: "uint64_max", Value: uint64(math.MaxUint64)},
+	}, out)
+}
+
 func fakeTransaction() model.Transaction {
 	return model.Transaction{
 		TraceID:   model.TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
@@ -194,6 +194,9 @@ type Transaction struct {
 	// for HTTP requests.
 	Result string `json:"result,omitempty"`
 
+	// Outcome holds the transaction outcome: "success" or "failure".
+	Outcome string `json:"outcome,omitempty"`
+
 	// Context holds contextual information relating to the transaction.
 	Context *Context `json:"context,omitempty"`
 
@@ -242,6 +245,12 @@ type Span struct {
 	// Action identifies the action that is being undertaken, e.g. "query".
 	Action string `json:"action,omitempty"`
 
+	// Outcome holds the span outcome: "success" or "failure".
+	Outcome string `json:"outcome,omitempty"`
+
+	// ErrorCount holds the number of errors associated with the span.
+	ErrorCount int `json:"error_count,omitempty"`
+
 	// ID holds the ID of the span.
 	ID SpanID `json:"id"`
 
@@ -259,6 +268,24 @@ type Span struct {
 
 	// Stacktrace holds stack frames corresponding to the span.
 	Stacktrace []StacktraceFrame `json:"stacktrace,omitempty"`
+
+	// Composite, if non-nil, holds details of a composite span:
+	// a span representing multiple consecutive, similar spans.
+	Composite *CompositeSpan `json:"composite,omitempty"`
+}
+
+// CompositeSpan holds details of a composite span.
+type CompositeSpan struct {
+	// Count holds the number of compressed spans.
+	Count int `json:"count"`
+
+	// Sum holds the sum of the durations of the compressed spans,
+	// in milliseconds.
+	Sum float64 `json:"sum"`
+
+	// CompressionStrategy holds the strategy used for compressing
+	// the spans, e.g. "exact_match".
+	CompressionStrategy string `json:"compression_strategy"`
 }
 
 // SpanContext holds contextual information relating to the span.
@@ -273,6 +300,9 @@ type SpanContext struct {
 	// HTTP holds contextual information for HTTP client request spans.
 	HTTP *HTTPSpanContext `json:"http,omitempty"`
 
+	// Service holds information about the service targeted by the span.
+	Service *ServiceSpanContext `json:"service,omitempty"`
+
 	// Tags holds user-defined key/value pairs.
 	Tags IfaceMap `json:"tags,omitempty"`
 }
@@ -305,6 +335,23 @@ type DestinationServiceSpanContext struct {
 	Resource string `json:"resource,omitempty"`
 }
 
+// ServiceSpanContext holds contextual information about the service
+// targeted by a span.
+type ServiceSpanContext struct {
+	// Target holds the target service's identity.
+	Target *ServiceTargetSpanContext `json:"target,omitempty"`
+}
+
+// ServiceTargetSpanContext identifies the service targeted by a span,
+// which may or may not itself be instrumented.
+type ServiceTargetSpanContext struct {
+	// Type holds the target service type, e.g. "http" or "mysql".
+	Type string `json:"type,omitempty"`
+
+	// Name holds the target service name, e.g. "api.example.com:443".
+	Name string `json:"name,omitempty"`
+}
+
 // DatabaseSpanContext holds contextual information for database
 // operation spans.
 type DatabaseSpanContext struct {
@@ -330,6 +377,16 @@ type HTTPSpanContext struct {
 
 	// StatusCode holds the HTTP response status code.
 	StatusCode int `json:"status_code,omitempty"`
+
+	// Response holds details of the HTTP response, if any.
+	Response *HTTPSpanContextResponse `json:"response,omitempty"`
+}
+
+// HTTPSpanContextResponse holds contextual information for the
+// response to an HTTP client request span.
+type HTTPSpanContextResponse struct {
+	// Headers holds the response headers that were captured.
+	Headers Headers `json:"headers,omitempty"`
 }
 
 // Context holds contextual information relating to a transaction or error.
@@ -18,6 +18,12 @@
 package apm
 
 import (
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.elastic.co/apm/internal/apmstrings"
 	"go.elastic.co/apm/internal/ringbuffer"
 	"go.elastic.co/apm/model"
 	"go.elastic.co/apm/stacktrace"
@@ -35,51 +41,149 @@ const (
 // of non-sampled transactions.
 var notSampled = false
 
+// modelWriter encodes events to the tracer's buffers. If recording is
+// disabled, events are reset without being encoded.
 type modelWriter struct {
+	tracer          *Tracer
 	buffer          *ringbuffer.Buffer
 	metricsBuffer   *ringbuffer.Buffer
 	cfg             *tracerConfig
 	stats           *TracerStats
 	json            fastjson.Writer
 	modelStacktrace []model.StacktraceFrame
+
+	// bufferTimestamps holds the timestamps of the events
+	// encoded in each block of buffer, oldest first.
+	bufferTimestamps timestampQueue
 }
 
 // writeTransaction encodes tx as JSON to the buffer, and then resets tx.
 func (w *modelWriter) writeTransaction(tx *Transaction, td *TransactionData) {
+	if w.cfg.recordingDisabled {
+		tx.release(td)
+		return
+	}
 	var modelTx model.Transaction
 	w.buildModelTransaction(&modelTx, tx, td)
+	if tx.abandoned {
+		buildAbandonedTransaction(&modelTx, tx)
+	}
+	if w.cfg.transactionFilter != nil {
+		w.tracer.enterLoopCallback()
+		keep := w.cfg.transactionFilter(&modelTx)
+		w.tracer.exitLoopCallback()
+		if !keep {
+			w.stats.TransactionsFiltered++
+			tx.release(td)
+			return
+		}
+	}
+	if w.cfg.transactionObserver != nil {
+		w.tracer.enterLoopCallback()
+		w.cfg.transactionObserver(&modelTx)
+		w.tracer.exitLoopCallback()
+	}
 	w.json.RawString(`{"transaction":`)
 	modelTx.MarshalFastJSON(&w.json)
 	w.json.RawByte('}')
-	w.buffer.WriteBlock(w.json.Bytes(), transactionBlockTag)
+	w.writeBlock(transactionBlockTag, td.timestamp)
 	w.json.Reset()
-	td.reset(tx.tracer)
+	tx.release(td)
 }
 
-// writeSpan encodes s as JSON to the buffer, and then resets s.
-func (w *modelWriter) writeSpan(s *Span, sd *SpanData) {
+// writeSpan encodes sd as JSON to the buffer, and then resets sd.
+func (w *modelWriter) writeSpan(sd *SpanData) {
+	if w.cfg.recordingDisabled {
+		sd.reset(w.tracer)
+		return
+	}
 	var modelSpan model.Span
-	w.buildModelSpan(&modelSpan, s, sd)
+	w.buildModelSpan(&modelSpan, sd)
+	if w.cfg.spanFilter != nil {
+		w.tracer.enterLoopCallback()
+		keep := w.cfg.spanFilter(&modelSpan)
+		w.tracer.exitLoopCallback()
+		if !keep {
+			w.stats.SpansFiltered++
+			sd.reset(w.tracer)
+			return
+		}
+	}
+	if w.cfg.spanObserver != nil {
+		w.tracer.enterLoopCallback()
+		w.cfg.spanObserver(&modelSpan)
+		w.tracer.exitLoopCallback()
+	}
 	w.json.RawString(`{"span":`)
 	modelSpan.MarshalFastJSON(&w.json)
 	w.json.RawByte('}')
-	w.buffer.WriteBlock(w.json.Bytes(), spanBlockTag)
+	w.writeBlock(spanBlockTag, sd.timestamp)
 	w.json.Reset()
-	sd.reset(s.tracer)
+	sd.reset(w.tracer)
 }
 
 // writeError encodes e as JSON to the buffer, and then resets e.
 func (w *modelWriter) writeError(e *ErrorData) {
+	if w.cfg.recordingDisabled {
+		e.reset()
+		return
+	}
 	var modelError model.Error
 	w.buildModelError(&modelError, e)
+	if w.cfg.errorFilter != nil {
+		w.tracer.enterLoopCallback()
+		keep := w.cfg.errorFilter(&modelError)
+		w.tracer.exitLoopCallback()
+		if !keep {
+			w.stats.ErrorsFiltered++
+			e.reset()
+			return
+		}
+	}
 	w.json.RawString(`{"error":`)
 	modelError.MarshalFastJSON(&w.json)
 	w.json.RawByte('}')
-	w.buffer.WriteBlock(w.json.Bytes(), errorBlockTag)
+	w.writeBlock(errorBlockTag, e.Timestamp)
 	w.json.Reset()
 	e.reset()
 }
 
+// writeBlock writes the encoded event in w.json to the buffer as a block
+// with the given tag, recording the event's timestamp.
+func (w *modelWriter) writeBlock(tag ringbuffer.BlockTag, timestamp time.Time) {
+	if _, err := w.buffer.WriteBlock(w.json.Bytes(), tag); err == nil {
+		w.bufferTimestamps.push(timestamp)
+	}
+}
+
+// oldestBlockExpired reports whether the oldest event in the buffer
+// is older than maxAge. If maxAge is not positive, events never expire.
+func (w *modelWriter) oldestBlockExpired(maxAge time.Duration) bool {
+	if maxAge <= 0 {
+		return false
+	}
+	timestamp, ok := w.bufferTimestamps.front()
+	return ok && time.Since(timestamp) > maxAge
+}
+
+// discardOldestBlock discards the oldest block in the buffer,
+// counting its event as expired.
+func (w *modelWriter) discardOldestBlock() {
+	w.bufferTimestamps.pop()
+	h, _, err := w.buffer.WriteBlockTo(ioutil.Discard)
+	if err != nil {
+		return
+	}
+	switch h.Tag {
+	case errorBlockTag:
+		w.stats.ErrorsExpired++
+	case spanBlockTag:
+		w.stats.SpansExpired++
+	case transactionBlockTag:
+		w.stats.TransactionsExpired++
+	}
+}
+
 // writeMetrics encodes m as JSON to the w.metricsBuffer, and then resets m.
 //
 // Note that we do not write metrics to the main ring buffer (w.buffer), as
@@ -114,6 +218,7 @@ func (w *modelWriter) buildModelTransaction(out *model.Transaction, tx *Transact
 	out.Name = truncateString(td.Name)
 	out.Type = truncateString(td.Type)
 	out.Result = truncateString(td.Result)
+	out.Outcome = truncateString(td.Outcome)
 	out.Timestamp = model.Time(td.timestamp.UTC())
 	out.Duration = td.Duration.Seconds() * 1000
 	out.SpanCount.Started = td.spansCreated
@@ -132,29 +237,62 @@ func (w *modelWriter) buildModelTransaction(out *model.Transaction, tx *Transact
 	}
 }
 
-func (w *modelWriter) buildModelSpan(out *model.Span, span *Span, sd *SpanData) {
+// buildAbandonedTransaction updates out, the model of the abandoned
+// transaction tx, setting its duration and labeling it as abandoned.
+//
+// The application may still hold a reference to tx, so tx's data is
+// left unmodified; out.Context is replaced with a copy.
+func buildAbandonedTransaction(out *model.Transaction, tx *Transaction) {
+	out.Duration = tx.abandonedDuration.Seconds() * 1000
+	if !tx.traceContext.Options.Recorded() {
+		return
+	}
+	var modelContext model.Context
+	if out.Context != nil {
+		modelContext = *out.Context
+	}
+	modelContext.Tags = append(
+		modelContext.Tags[:len(modelContext.Tags):len(modelContext.Tags)],
+		model.IfaceMapItem{Key: "abandoned", Value: true},
+	)
+	out.Context = &modelContext
+}
+
+func (w *modelWriter) buildModelSpan(out *model.Span, sd *SpanData) {
 	w.modelStacktrace = w.modelStacktrace[:0]
-	out.ID = model.SpanID(span.traceContext.Span)
-	out.TraceID = model.TraceID(span.traceContext.Trace)
-	out.TransactionID = model.SpanID(span.transactionID)
+	out.ID = model.SpanID(sd.traceContext.Span)
+	out.TraceID = model.TraceID(sd.traceContext.Trace)
+	out.TransactionID = model.SpanID(sd.transactionID)
 
 	out.ParentID = model.SpanID(sd.parentID)
 	out.Name = truncateString(sd.Name)
 	out.Type = truncateString(sd.Type)
 	out.Subtype = truncateString(sd.Subtype)
 	out.Action = truncateString(sd.Action)
+	out.Outcome = truncateString(sd.outcome())
+	out.ErrorCount = sd.errorCount
 	out.Timestamp = model.Time(sd.timestamp.UTC())
 	out.Duration = sd.Duration.Seconds() * 1000
 	out.Context = sd.Context.build()
+	out.Composite = sd.composite.build()
 
 	// Copy the span type to context.destination.service.type.
 	if out.Context != nil && out.Context.Destination != nil && out.Context.Destination.Service != nil {
 		out.Context.Destination.Service.Type = out.Type
 	}
 
+	// Only send a preview of the database statement for fast spans,
+	// if requested. Slow spans, for which stack frames are captured,
+	// retain the full statement.
+	if n := sd.Context.databaseStatementPreviewLength; n > 0 && sd.Duration < sd.stackFramesMinDuration {
+		if out.Context != nil && out.Context.Database != nil {
+			out.Context.Database.Statement, _ = apmstrings.Truncate(out.Context.Database.Statement, n)
+		}
+	}
+
 	w.modelStacktrace = appendModelStacktraceFrames(w.modelStacktrace, sd.stacktrace)
 	out.Stacktrace = w.modelStacktrace
-	w.setStacktraceContext(out.Stacktrace)
+	w.setStacktraceContext(out.Stacktrace, w.cfg.spanContextLines)
 }
 
 func (w *modelWriter) buildModelError(out *model.Error, e *ErrorData) {
@@ -188,7 +326,7 @@ func (w *modelWriter) buildModelError(out *model.Error, e *ErrorData) {
 	if len(e.logStacktrace) != 0 {
 		w.modelStacktrace = appendModelStacktraceFrames(w.modelStacktrace, e.logStacktrace)
 	}
-	w.setStacktraceContext(w.modelStacktrace)
+	w.setStacktraceContext(w.modelStacktrace, w.cfg.errorContextLines)
 
 	var modelStacktraceOffset int
 	if e.exception.message != "" {
@@ -219,7 +357,7 @@ func (w *modelWriter) buildModelError(out *model.Error, e *ErrorData) {
 				}
 			}
 			if culprit == "" {
-				culprit = stacktraceCulprit(out.Stacktrace)
+				culprit = w.stacktraceCulprit(out.Stacktrace)
 			}
 			return out
 		}
@@ -237,13 +375,22 @@ func (w *modelWriter) buildModelError(out *model.Error, e *ErrorData) {
 			out.Log.Stacktrace = w.modelStacktrace[modelStacktraceOffset : modelStacktraceOffset+n]
 			modelStacktraceOffset += n
 			if out.Culprit == "" {
-				out.Culprit = stacktraceCulprit(out.Log.Stacktrace)
+				out.Culprit = w.stacktraceCulprit(out.Log.Stacktrace)
 			}
 		}
 	}
 	out.Culprit = truncateString(out.Culprit)
 }
 
+// stacktraceCulprit returns the culprit for an error with the given
+// stacktrace frames, using the configured culprit function if any.
+func (w *modelWriter) stacktraceCulprit(frames []model.StacktraceFrame) string {
+	if w.cfg.culpritFunc != nil {
+		return w.cfg.culpritFunc(frames)
+	}
+	return stacktraceCulprit(frames)
+}
+
 func stacktraceCulprit(frames []model.StacktraceFrame) string {
 	for _, frame := range frames {
 		if !frame.LibraryFrame {
@@ -253,11 +400,16 @@ func stacktraceCulprit(frames []model.StacktraceFrame) string {
 	return ""
 }
 
-func (w *modelWriter) setStacktraceContext(stack []model.StacktraceFrame) {
-	if w.cfg.contextSetter == nil || len(stack) == 0 {
+func (w *modelWriter) setStacktraceContext(stack []model.StacktraceFrame, lines contextLines) {
+	if w.cfg.contextSetter == nil || len(stack) == 0 || lines.pre < 0 || lines.post < 0 {
 		return
 	}
-	err := stacktrace.SetContext(w.cfg.contextSetter, stack, w.cfg.preContext, w.cfg.postContext)
+	var err error
+	if w.cfg.contextWorkers > 1 && len(stack) > 1 {
+		err = setStacktraceContextConcurrent(w.cfg.contextSetter, stack, lines, w.cfg.contextWorkers)
+	} else {
+		err = stacktrace.SetContext(w.cfg.contextSetter, stack, lines.pre, lines.post)
+	}
 	if err != nil {
 		if w.cfg.logger != nil {
 			w.cfg.logger.Debugf("setting context failed: %v", err)
@@ -265,3 +417,78 @@ func (w *modelWriter) setStacktraceContext(stack []model.StacktraceFrame) {
 		w.stats.Errors.SetContext++
 	}
 }
+
+// setStacktraceContextConcurrent sets the source context for stack frames
+// using up to the given number of goroutines, returning the error for the
+// earliest frame for which setting the context failed, if any.
+func setStacktraceContextConcurrent(
+	setter stacktrace.ContextSetter,
+	stack []model.StacktraceFrame,
+	lines contextLines,
+	workers int,
+) error {
+	if workers > len(stack) {
+		workers = len(stack)
+	}
+	errs := make([]error, len(stack))
+	next := int64(-1)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= len(stack) {
+					return
+				}
+				errs[i] = setter.SetContext(&stack[i], lines.pre, lines.post)
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// timestampQueue is a FIFO queue of timestamps.
+type timestampQueue struct {
+	head       int
+	timestamps []int64 // Unix nanoseconds
+}
+
+// push adds t to the back of the queue.
+func (q *timestampQueue) push(t time.Time) {
+	q.timestamps = append(q.timestamps, t.UnixNano())
+}
+
+// front returns the timestamp at the front of the queue, and
+// a boolean indicating whether the queue is non-empty.
+func (q *timestampQueue) front() (time.Time, bool) {
+	if q.head == len(q.timestamps) {
+		return time.Time{}, false
+	}
+	return time.Unix(0, q.timestamps[q.head]), true
+}
+
+// pop removes the timestamp at the front of the queue, if any.
+func (q *timestampQueue) pop() {
+	if q.head == len(q.timestamps) {
+		return
+	}
+	q.head++
+	switch {
+	case q.head == len(q.timestamps):
+		q.head = 0
+		q.timestamps = q.timestamps[:0]
+	case q.head >= 1024 && q.head*2 >= len(q.timestamps):
+		// Reclaim space at the front of the slice.
+		n := copy(q.timestamps, q.timestamps[q.head:])
+		q.timestamps = q.timestamps[:n]
+		q.head = 0
+	}
+}
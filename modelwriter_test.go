@@ -0,0 +1,60 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimestampQueue(t *testing.T) {
+	var q timestampQueue
+	_, ok := q.front()
+	assert.False(t, ok)
+	q.pop() // no-op
+
+	t0 := time.Unix(0, 0)
+	const n = 5000
+	for i := 0; i < n; i++ {
+		q.push(t0.Add(time.Duration(i)))
+	}
+	for i := 0; i < n; i++ {
+		front, ok := q.front()
+		if !assert.True(t, ok) || !assert.Equal(t, t0.Add(time.Duration(i)), front) {
+			return
+		}
+		q.pop()
+		if i%2 == 0 {
+			// Interleave pushes and pops to
+			// exercise reclaiming of space.
+			q.push(t0.Add(time.Duration(n + i/2)))
+		}
+	}
+	for i := 0; i < n/2; i++ {
+		front, ok := q.front()
+		if !assert.True(t, ok) || !assert.Equal(t, t0.Add(time.Duration(n+i)), front) {
+			return
+		}
+		q.pop()
+	}
+	_, ok = q.front()
+	assert.False(t, ok)
+	assert.Less(t, cap(q.timestamps), 2*n)
+}
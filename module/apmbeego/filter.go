@@ -47,6 +47,7 @@ func Middleware(o ...Option) func(http.Handler) http.Handler {
 	for _, o := range o {
 		o(&opts)
 	}
+	opts.tracer.SetFramework("beego", beego.VERSION)
 	return func(h http.Handler) http.Handler {
 		return apmhttp.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 			tx := apm.TransactionFromContext(req.Context())
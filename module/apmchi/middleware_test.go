@@ -54,6 +54,7 @@ func TestMiddleware(t *testing.T) {
 	assert.Equal(t, "request", transaction.Type)
 	assert.Equal(t, "HTTP 2xx", transaction.Result)
 
+	finished := true
 	assert.Equal(t, &model.Context{
 		Request: &model.Request{
 			Socket: &model.RequestSocket{
@@ -71,6 +72,7 @@ func TestMiddleware(t *testing.T) {
 		},
 		Response: &model.Response{
 			StatusCode: 200,
+			Finished:   &finished,
 			Headers: model.Headers{{
 				Key:    "Content-Type",
 				Values: []string{"text/plain; charset=utf-8"},
@@ -48,6 +48,7 @@ func Middleware(o ...Option) echo.MiddlewareFunc {
 	for _, o := range o {
 		o(&opts)
 	}
+	opts.tracer.SetFramework("echo", echo.Version)
 	return func(h echo.HandlerFunc) echo.HandlerFunc {
 		m := &middleware{
 			tracer:         opts.tracer,
@@ -32,6 +32,8 @@ import (
 	"go.elastic.co/apm/module/apmhttp"
 )
 
+var instrumentation = apm.RegisterInstrumentation("elasticsearch")
+
 // WrapRoundTripper returns an http.RoundTripper wrapping r, reporting each
 // request as a span to Elastic APM, if the request's context contains a
 // sampled transaction.
@@ -63,7 +65,7 @@ type roundTripper struct {
 func (r *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	ctx := req.Context()
 	tx := apm.TransactionFromContext(ctx)
-	if tx == nil || !tx.Sampled() {
+	if tx == nil || !tx.Sampled() || apm.IsInternalOperation(ctx) || !instrumentation.Enabled(ctx) {
 		return r.r.RoundTrip(req)
 	}
 
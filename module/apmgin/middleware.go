@@ -52,6 +52,7 @@ func Middleware(engine *gin.Engine, o ...Option) gin.HandlerFunc {
 	for _, o := range o {
 		o(m)
 	}
+	m.tracer.SetFramework("gin", gin.Version)
 	return m.handle
 }
 
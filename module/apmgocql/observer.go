@@ -28,6 +28,8 @@ import (
 	"go.elastic.co/apm/stacktrace"
 )
 
+var instrumentation = apm.RegisterInstrumentation("cassandra")
+
 func init() {
 	stacktrace.RegisterLibraryPackage(
 		"github.com/gocql",
@@ -56,7 +58,7 @@ func NewObserver(o ...Option) *Observer {
 // ObserveBatch observes batch executions, and creates spans for the
 // batch, and sub-spans for each statement therein.
 func (o *Observer) ObserveBatch(ctx context.Context, batch gocql.ObservedBatch) {
-	batchSpan, ctx := apm.StartSpanOptions(ctx, "BATCH", "db.cassandra.batch", apm.SpanOptions{
+	batchSpan, ctx := instrumentation.StartSpanOptions(ctx, "BATCH", "db.cassandra.batch", apm.SpanOptions{
 		Start: batch.Start,
 	})
 	batchSpan.Duration = batch.End.Sub(batch.Start)
@@ -67,7 +69,7 @@ func (o *Observer) ObserveBatch(ctx context.Context, batch gocql.ObservedBatch)
 	defer batchSpan.End()
 
 	for _, statement := range batch.Statements {
-		span, _ := apm.StartSpanOptions(ctx, querySignature(statement), "db.cassandra.query", apm.SpanOptions{
+		span, _ := instrumentation.StartSpanOptions(ctx, querySignature(statement), "db.cassandra.query", apm.SpanOptions{
 			Start: batch.Start,
 		})
 		span.Duration = batchSpan.Duration
@@ -87,7 +89,7 @@ func (o *Observer) ObserveBatch(ctx context.Context, batch gocql.ObservedBatch)
 
 // ObserveQuery observes query results, and creates spans for them.
 func (o *Observer) ObserveQuery(ctx context.Context, query gocql.ObservedQuery) {
-	span, _ := apm.StartSpanOptions(ctx, querySignature(query.Statement), "db.cassandra.query", apm.SpanOptions{
+	span, _ := instrumentation.StartSpanOptions(ctx, querySignature(query.Statement), "db.cassandra.query", apm.SpanOptions{
 		Start: query.Start,
 	})
 	span.Duration = query.End.Sub(query.Start)
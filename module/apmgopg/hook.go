@@ -30,6 +30,8 @@ import (
 	"go.elastic.co/apm/stacktrace"
 )
 
+var instrumentation = apm.RegisterInstrumentation("gopg")
+
 func init() {
 	stacktrace.RegisterLibraryPackage("github.com/go-pg/pg")
 }
@@ -71,7 +73,7 @@ func (qh *queryHook) BeforeQuery(evt *pg.QueryEvent) {
 		sql = fmt.Sprintf("[go-pg] error: %s", err.Error())
 	}
 
-	span, _ := apm.StartSpan(evt.DB.Context(), apmsql.QuerySignature(sql), "db.postgresql.query")
+	span, _ := instrumentation.StartSpan(evt.DB.Context(), apmsql.QuerySignature(sql), "db.postgresql.query")
 	span.Context.SetDatabase(apm.DatabaseSpanContext{
 		Statement: sql,
 
@@ -28,6 +28,8 @@ import (
 	"go.elastic.co/apm"
 )
 
+var instrumentation = apm.RegisterInstrumentation("redis")
+
 // Client is the interface returned by Wrap.
 //
 // Client implements redis.UniversalClient
@@ -150,8 +152,9 @@ func process(ctx context.Context) func(oldProcess func(cmd redis.Cmder) error) f
 	return func(oldProcess func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
 		return func(cmd redis.Cmder) error {
 			spanName := strings.ToUpper(cmd.Name())
-			span, _ := apm.StartSpan(ctx, spanName, "db.redis")
+			span, _ := instrumentation.StartSpan(ctx, spanName, "db.redis")
 			defer span.End()
+			setDestination(span)
 
 			return oldProcess(cmd)
 		}
@@ -161,7 +164,8 @@ func process(ctx context.Context) func(oldProcess func(cmd redis.Cmder) error) f
 func processPipeline(ctx context.Context) func(oldProcess func(cmds []redis.Cmder) error) func(cmds []redis.Cmder) error {
 	return func(oldProcess func(cmds []redis.Cmder) error) func(cmds []redis.Cmder) error {
 		return func(cmds []redis.Cmder) error {
-			pipelineSpan, ctx := apm.StartSpan(ctx, "(pipeline)", "db.redis")
+			pipelineSpan, ctx := instrumentation.StartSpan(ctx, "(pipeline)", "db.redis")
+			setDestination(pipelineSpan)
 
 			for i := len(cmds); i > 0; i-- {
 				cmdName := strings.ToUpper(cmds[i-1].Name())
@@ -169,8 +173,9 @@ func processPipeline(ctx context.Context) func(oldProcess func(cmds []redis.Cmde
 					cmdName = "(empty command)"
 				}
 
-				span, _ := apm.StartSpan(ctx, cmdName, "db.redis")
+				span, _ := instrumentation.StartSpan(ctx, cmdName, "db.redis")
 				defer span.End()
+				setDestination(span)
 			}
 
 			defer pipelineSpan.End()
@@ -179,3 +184,11 @@ func processPipeline(ctx context.Context) func(oldProcess func(cmds []redis.Cmde
 		}
 	}
 }
+
+// setDestination sets the span's destination service, so that Redis
+// appears as a dependency in service maps.
+func setDestination(span *apm.Span) {
+	if !span.Dropped() {
+		span.Context.SetDestination("", 0, "redis")
+	}
+}
@@ -52,6 +52,7 @@ func TestMuxMiddleware(t *testing.T) {
 	assert.Equal(t, "request", transaction.Type)
 	assert.Equal(t, "HTTP 2xx", transaction.Result)
 
+	finished := true
 	assert.Equal(t, &model.Context{
 		Request: &model.Request{
 			Socket: &model.RequestSocket{
@@ -69,6 +70,7 @@ func TestMuxMiddleware(t *testing.T) {
 		},
 		Response: &model.Response{
 			StatusCode: 200,
+			Finished:   &finished,
 			Headers: model.Headers{{
 				Key:    "Content-Type",
 				Values: []string{"text/plain; charset=utf-8"},
@@ -30,6 +30,8 @@ import (
 	"go.elastic.co/apm/module/apmsql"
 )
 
+var instrumentation = apm.RegisterInstrumentation("gorm")
+
 const (
 	apmContextKey = "elasticapm:context"
 )
@@ -112,7 +114,7 @@ func newBeforeCallback(spanType string) func(*gorm.Scope) {
 		if !ok {
 			return
 		}
-		span, ctx := apm.StartSpan(ctx, "", spanType)
+		span, ctx := instrumentation.StartSpan(ctx, "", spanType)
 		if span.Dropped() {
 			span.End()
 			ctx = nil
@@ -28,6 +28,8 @@ import (
 	"go.elastic.co/apm/module/apmhttp"
 )
 
+var instrumentation = apm.RegisterInstrumentation("grpc")
+
 // NewUnaryClientInterceptor returns a grpc.UnaryClientInterceptor that
 // traces gRPC requests with the given options.
 //
@@ -57,12 +59,12 @@ func NewUnaryClientInterceptor(o ...ClientOption) grpc.UnaryClientInterceptor {
 
 func startSpan(ctx context.Context, name string) (*apm.Span, context.Context) {
 	tx := apm.TransactionFromContext(ctx)
-	if tx == nil {
+	if tx == nil || apm.IsInternalOperation(ctx) {
 		return nil, ctx
 	}
 	traceContext := tx.TraceContext()
 	propagateLegacyHeader := tx.ShouldPropagateLegacyHeader()
-	if !traceContext.Options.Recorded() {
+	if !traceContext.Options.Recorded() || !instrumentation.Enabled(ctx) {
 		return nil, outgoingContextWithTraceContext(ctx, traceContext, propagateLegacyHeader)
 	}
 	span := tx.StartSpan(name, "external.grpc", apm.SpanFromContext(ctx))
@@ -99,9 +99,12 @@ func startTransaction(ctx context.Context, tracer *apm.Tracer, name string) (*ap
 	if md, ok := metadata.FromIncomingContext(ctx); ok {
 		traceContext, ok := getIncomingMetadataTraceContext(md, elasticTraceparentHeader)
 		if !ok {
-			traceContext, _ = getIncomingMetadataTraceContext(md, w3cTraceparentHeader)
+			traceContext, ok = getIncomingMetadataTraceContext(md, w3cTraceparentHeader)
 		}
 		opts.TraceContext = traceContext
+		if !ok {
+			opts.Sampled = getIncomingMetadataSamplingDecision(md, tracer.SamplingHeader())
+		}
 	}
 	tx := tracer.StartTransactionOptions(name, "request", opts)
 	tx.Context.SetFramework("grpc", grpc.Version)
@@ -119,6 +122,18 @@ func getIncomingMetadataTraceContext(md metadata.MD, header string) (apm.TraceCo
 	return apm.TraceContext{}, false
 }
 
+func getIncomingMetadataSamplingDecision(md metadata.MD, header string) *bool {
+	if header == "" {
+		return nil
+	}
+	if values := md.Get(header); len(values) == 1 {
+		if sampled, err := apmhttp.ParseSamplingHeader(values[0]); err == nil {
+			return &sampled
+		}
+	}
+	return nil
+}
+
 func setTransactionResult(tx *apm.Transaction, err error) {
 	if err == nil {
 		tx.Result = codes.OK.String()
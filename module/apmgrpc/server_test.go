@@ -214,6 +214,44 @@ func TestServerIgnorer(t *testing.T) {
 	assert.Empty(t, transport.Payloads())
 }
 
+func TestServerSamplingHeader(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetSamplingHeader("X-B3-Sampled")
+
+	s, _, addr := newServer(t, tracer)
+	defer s.GracefulStop()
+
+	conn, client := newClient(t, addr)
+	defer conn.Close()
+
+	for _, test := range []struct {
+		header  string
+		ratio   float64
+		sampled bool
+	}{
+		{header: "1", ratio: 0, sampled: true},
+		{header: "0", ratio: 1, sampled: false},
+		{header: "", ratio: 0, sampled: false}, // missing header, use sampler
+		{header: "", ratio: 1, sampled: true},  // missing header, use sampler
+	} {
+		tracer.SetSampler(apm.NewRatioSampler(test.ratio))
+		ctx := context.Background()
+		if test.header != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "X-B3-Sampled", test.header)
+		}
+		_, err := client.SayHello(ctx, &pb.HelloRequest{Name: "birita"})
+		require.NoError(t, err)
+
+		tracer.Flush(nil)
+		payloads := transport.Payloads()
+		require.Len(t, payloads.Transactions, 1)
+		sampled := payloads.Transactions[0].Sampled
+		assert.Equal(t, test.sampled, sampled == nil || *sampled, "header=%q ratio=%v", test.header, test.ratio)
+		transport.ResetPayloads()
+	}
+}
+
 func newServer(t *testing.T, tracer *apm.Tracer, opts ...apmgrpc.ServerOption) (*grpc.Server, *helloworldServer, net.Addr) {
 	// We always install grpc_recovery first to avoid panics
 	// aborting the test process. We install it before the
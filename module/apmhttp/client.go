@@ -26,6 +26,8 @@ import (
 	"go.elastic.co/apm"
 )
 
+var instrumentation = apm.RegisterInstrumentation("http")
+
 // WrapClient returns a new *http.Client with all fields copied
 // across, and the Transport field wrapped with WrapRoundTripper
 // such that client requests are reported as spans to Elastic APM
@@ -67,9 +69,10 @@ func WrapRoundTripper(r http.RoundTripper, o ...ClientOption) http.RoundTripper
 }
 
 type roundTripper struct {
-	r              http.RoundTripper
-	requestName    RequestNameFunc
-	requestIgnorer RequestIgnorerFunc
+	r               http.RoundTripper
+	requestName     RequestNameFunc
+	requestIgnorer  RequestIgnorerFunc
+	responseHeaders []string
 }
 
 // RoundTrip delegates to r.r, emitting a span if req's context
@@ -80,7 +83,7 @@ func (r *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	}
 	ctx := req.Context()
 	tx := apm.TransactionFromContext(ctx)
-	if tx == nil {
+	if tx == nil || apm.IsInternalOperation(ctx) {
 		return r.r.RoundTrip(req)
 	}
 
@@ -95,7 +98,7 @@ func (r *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 
 	propagateLegacyHeader := tx.ShouldPropagateLegacyHeader()
 	traceContext := tx.TraceContext()
-	if !traceContext.Options.Recorded() {
+	if !traceContext.Options.Recorded() || !instrumentation.Enabled(ctx) {
 		r.setHeaders(req, traceContext, propagateLegacyHeader)
 		return r.r.RoundTrip(req)
 	}
@@ -119,6 +122,9 @@ func (r *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 			span.End()
 		} else {
 			span.Context.SetHTTPStatusCode(resp.StatusCode)
+			if len(r.responseHeaders) != 0 {
+				r.setResponseHeaders(span, resp)
+			}
 			resp.Body = &responseBody{span: span, body: resp.Body}
 		}
 	}
@@ -134,6 +140,28 @@ func (r *roundTripper) setHeaders(req *http.Request, traceContext apm.TraceConte
 	if tracestate := traceContext.State.String(); tracestate != "" {
 		req.Header.Set(TracestateHeader, tracestate)
 	}
+	if baggage := traceContext.Baggage.String(); baggage != "" {
+		req.Header.Set(BaggageHeader, baggage)
+	}
+}
+
+func (r *roundTripper) setResponseHeaders(span *apm.Span, resp *http.Response) {
+	var headers http.Header
+	for _, k := range r.responseHeaders {
+		if values, ok := resp.Header[k]; ok {
+			if headers == nil {
+				headers = make(http.Header)
+			}
+			headers[k] = values
+		}
+	}
+	span.Context.SetHTTPResponseHeaders(headers)
+}
+
+// UntracedRoundTripper returns r.r. This is used by the transport
+// to avoid tracing requests to the APM Server.
+func (r *roundTripper) UntracedRoundTripper() http.RoundTripper {
+	return r.r
 }
 
 // CloseIdleConnections calls r.r.CloseIdleConnections if the method exists.
@@ -198,3 +226,18 @@ func WithClientRequestName(r RequestNameFunc) ClientOption {
 		rt.requestName = r
 	})
 }
+
+// WithClientResponseHeaders returns a ClientOption which sets the names
+// of HTTP response headers to record in client request spans. Only the
+// headers named are recorded; by default, no response headers are recorded.
+//
+// Header names are matched case-insensitively.
+func WithClientResponseHeaders(names ...string) ClientOption {
+	canonical := make([]string, len(names))
+	for i, name := range names {
+		canonical[i] = http.CanonicalHeaderKey(name)
+	}
+	return ClientOption(func(rt *roundTripper) {
+		rt.responseHeaders = canonical
+	})
+}
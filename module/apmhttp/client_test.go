@@ -39,6 +39,7 @@ import (
 	"go.elastic.co/apm/apmtest"
 	"go.elastic.co/apm/model"
 	"go.elastic.co/apm/module/apmhttp"
+	"go.elastic.co/apm/transport"
 	"go.elastic.co/apm/transport/transporttest"
 )
 
@@ -95,9 +96,42 @@ func TestClient(t *testing.T) {
 			URL:        serverURL,
 			StatusCode: statusCode,
 		},
+		Service: &model.ServiceSpanContext{
+			Target: &model.ServiceTargetSpanContext{
+				Type: "http",
+				Name: serverAddr.String(),
+			},
+		},
 	}, span.Context)
 }
 
+func TestClientResponseHeaders(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Cache", "HIT")
+		w.Header().Set("X-Request-Id", "abc123")
+		w.Header().Set("Set-Cookie", "secret=hunter2")
+	}))
+	defer server.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	ctx := apm.ContextWithTransaction(context.Background(), tx)
+	client := apmhttp.WrapClient(http.DefaultClient, apmhttp.WithClientResponseHeaders("x-cache", "X-Missing"))
+	resp, err := ctxhttp.Get(ctx, client, server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Spans, 1)
+	assert.Equal(t, &model.HTTPSpanContextResponse{
+		Headers: model.Headers{{Key: "X-Cache", Values: []string{"HIT"}}},
+	}, payloads.Spans[0].Context.HTTP.Response)
+}
+
 func TestClientTraceContextHeaders(t *testing.T) {
 	t.Run("with-elastic-apm-traceparent", func(t *testing.T) {
 		testClientTraceContextHeaders(t, "Elastic-Apm-Traceparent", "Traceparent")
@@ -128,6 +162,7 @@ func testClientTraceContextHeaders(t *testing.T, traceparentHeaders ...string) {
 			Span:    apm.SpanID{1},
 			Options: apm.TraceOptions(0).WithRecorded(true),
 			State:   apm.NewTraceState(apm.TraceStateEntry{Key: "vendor", Value: "tracestate"}),
+			Baggage: apm.NewBaggage(apm.BaggageMember{Key: "tenant", Value: "a b"}),
 		},
 	})
 	ctx := apm.ContextWithTransaction(context.Background(), tx)
@@ -156,6 +191,9 @@ func testClientTraceContextHeaders(t *testing.T, traceparentHeaders ...string) {
 
 	require.Contains(t, headers, "Tracestate")
 	assert.Equal(t, "vendor=tracestate", headers["Tracestate"])
+
+	require.Contains(t, headers, "Baggage")
+	assert.Equal(t, "tenant=a%20b", headers["Baggage"])
 }
 
 func TestClientSpanDropped(t *testing.T) {
@@ -222,6 +260,28 @@ func TestClientTransactionUnsampled(t *testing.T) {
 	assert.Equal(t, transaction.ID, model.SpanID(clientTraceContext.Span))
 }
 
+func TestClientInternalOperation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.Header.Get("Elastic-Apm-Traceparent")))
+	}))
+	defer server.Close()
+
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	ctx := apm.ContextWithTransaction(context.Background(), tx)
+	ctx = apm.ContextWithInternalOperation(ctx)
+	_, responseBody := mustGET(ctx, server.URL)
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	require.Len(t, payloads.Spans, 0)
+	assert.Empty(t, responseBody) // no trace context propagated
+}
+
 func TestClientError(t *testing.T) {
 	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
 		client := apmhttp.WrapClient(http.DefaultClient)
@@ -292,6 +352,20 @@ func TestClientCancelRequest(t *testing.T) {
 	}
 }
 
+func TestClientTransportSetHTTPClient(t *testing.T) {
+	httpTransport, err := transport.NewHTTPTransport()
+	require.NoError(t, err)
+
+	client := apmhttp.WrapClient(&http.Client{Timeout: time.Second})
+	httpTransport.SetHTTPClient(client)
+
+	// The APM transport must not trace its own requests,
+	// so the traced RoundTripper is unwrapped.
+	assert.Equal(t, http.DefaultTransport, httpTransport.Client.Transport)
+	assert.Equal(t, time.Second, httpTransport.Client.Timeout)
+	assert.NotEqual(t, http.DefaultTransport, client.Transport)
+}
+
 func TestWithClientRequestName(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 		w.WriteHeader(http.StatusTeapot)
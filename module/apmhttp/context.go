@@ -38,3 +38,14 @@ func StatusCodeResult(statusCode int) string {
 	}
 	return fmt.Sprintf("HTTP %d", statusCode)
 }
+
+// ServerStatusCodeOutcome returns the transaction outcome value to use for
+// the given HTTP server response status code: "failure" for 5xx status
+// codes, and "success" otherwise. Client errors (4xx) are not considered
+// failures of the server.
+func ServerStatusCodeOutcome(statusCode int) string {
+	if statusCode >= 500 {
+		return "failure"
+	}
+	return "success"
+}
@@ -20,6 +20,7 @@ package apmhttp
 import (
 	"context"
 	"net/http"
+	"strconv"
 
 	"go.elastic.co/apm"
 )
@@ -56,12 +57,15 @@ func Wrap(h http.Handler, o ...ServerOption) http.Handler {
 //
 // The http.Request's context will be updated with the transaction.
 type handler struct {
-	handler          http.Handler
-	tracer           *apm.Tracer
-	recovery         RecoveryFunc
-	panicPropagation bool
-	requestName      RequestNameFunc
-	requestIgnorer   RequestIgnorerFunc
+	handler           http.Handler
+	tracer            *apm.Tracer
+	recovery          RecoveryFunc
+	panicPropagation  bool
+	requestName       RequestNameFunc
+	requestIgnorer    RequestIgnorerFunc
+	forceSampleHeader string
+	baggageLabels     []string
+	responseWriteSpan bool
 }
 
 // ServeHTTP delegates to h.Handler, tracing the transaction with
@@ -71,11 +75,24 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		h.handler.ServeHTTP(w, req)
 		return
 	}
-	tx, req := StartTransaction(h.tracer, h.requestName(req), req)
+	var forceSampled bool
+	if h.forceSampleHeader != "" {
+		forceSampled, _ = strconv.ParseBool(req.Header.Get(h.forceSampleHeader))
+	}
+	tx, req := startTransaction(h.tracer, h.requestName(req), req, forceSampled)
 	defer tx.End()
+	if len(h.baggageLabels) != 0 {
+		baggage := tx.TraceContext().Baggage
+		for _, key := range h.baggageLabels {
+			if value, ok := baggage.Get(key); ok {
+				tx.Context.SetLabel(key, value)
+			}
+		}
+	}
 
 	body := h.tracer.CaptureHTTPRequestBody(req)
 	w, resp := WrapResponseWriter(w)
+	resp.timeWrites = h.responseWriteSpan
 	defer func() {
 		if v := recover(); v != nil {
 			if h.panicPropagation {
@@ -90,6 +107,9 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 			}
 			h.recovery(w, req, resp, body, tx, v)
 		}
+		if h.responseWriteSpan && !resp.firstWrite.IsZero() {
+			reportResponseWriteSpan(tx, resp)
+		}
 		SetTransactionContext(tx, req, resp, body)
 		body.Discard()
 	}()
@@ -105,6 +125,10 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 // If the transaction is not ignored, the request will be
 // returned with the transaction added to its context.
 func StartTransaction(tracer *apm.Tracer, name string, req *http.Request) (*apm.Transaction, *http.Request) {
+	return startTransaction(tracer, name, req, false)
+}
+
+func startTransaction(tracer *apm.Tracer, name string, req *http.Request, forceSampled bool) (*apm.Transaction, *http.Request) {
 	traceContext, ok := getRequestTraceparent(req, ElasticTraceparentHeader)
 	if !ok {
 		traceContext, ok = getRequestTraceparent(req, W3CTraceparentHeader)
@@ -112,7 +136,15 @@ func StartTransaction(tracer *apm.Tracer, name string, req *http.Request) (*apm.
 	if ok {
 		traceContext.State, _ = ParseTracestateHeader(req.Header[TracestateHeader]...)
 	}
-	tx := tracer.StartTransactionOptions(name, "request", apm.TransactionOptions{TraceContext: traceContext})
+	traceContext.Baggage, _ = ParseBaggageHeader(req.Header[BaggageHeader]...)
+	opts := apm.TransactionOptions{
+		TraceContext: traceContext,
+		ForceSampled: forceSampled,
+	}
+	if !ok {
+		opts.Sampled = getRequestSamplingDecision(req, tracer.SamplingHeader())
+	}
+	tx := tracer.StartTransactionOptions(name, "request", opts)
 	ctx := apm.ContextWithTransaction(req.Context(), tx)
 	req = RequestWithContext(ctx, req)
 	return tx, req
@@ -127,10 +159,24 @@ func getRequestTraceparent(req *http.Request, header string) (apm.TraceContext,
 	return apm.TraceContext{}, false
 }
 
-// SetTransactionContext sets tx.Result and, if the transaction is being
-// sampled, sets tx.Context with information from req, resp, and body.
+func getRequestSamplingDecision(req *http.Request, header string) *bool {
+	if header == "" {
+		return nil
+	}
+	if values := req.Header[http.CanonicalHeaderKey(header)]; len(values) == 1 {
+		if sampled, err := ParseSamplingHeader(values[0]); err == nil {
+			return &sampled
+		}
+	}
+	return nil
+}
+
+// SetTransactionContext sets tx.Result and tx.Outcome from the response
+// status code and, if the transaction is being sampled, sets tx.Context
+// with information from req, resp, and body.
 func SetTransactionContext(tx *apm.Transaction, req *http.Request, resp *Response, body *apm.BodyCapturer) {
 	tx.Result = StatusCodeResult(resp.StatusCode)
+	tx.Outcome = ServerStatusCodeOutcome(resp.StatusCode)
 	if !tx.Sampled() {
 		return
 	}
@@ -142,115 +188,22 @@ func SetTransactionContext(tx *apm.Transaction, req *http.Request, resp *Respons
 func SetContext(ctx *apm.Context, req *http.Request, resp *Response, body *apm.BodyCapturer) {
 	ctx.SetHTTPRequest(req)
 	ctx.SetHTTPRequestBody(body)
-	ctx.SetHTTPStatusCode(resp.StatusCode)
-	ctx.SetHTTPResponseHeaders(resp.Headers)
-}
-
-// WrapResponseWriter wraps an http.ResponseWriter and returns the wrapped
-// value along with a *Response which will be filled in when the handler
-// is called. The *Response value must not be inspected until after the
-// request has been handled, to avoid data races. If neither of the
-// ResponseWriter's Write or WriteHeader methods are called, then the
-// response's StatusCode field will be zero.
-//
-// The returned http.ResponseWriter implements http.Pusher and http.Hijacker
-// if and only if the provided http.ResponseWriter does.
-func WrapResponseWriter(w http.ResponseWriter) (http.ResponseWriter, *Response) {
-	rw := responseWriter{
-		ResponseWriter: w,
-		resp: Response{
-			Headers: w.Header(),
-		},
-	}
-	h, _ := w.(http.Hijacker)
-	p, _ := w.(http.Pusher)
-	switch {
-	case h != nil && p != nil:
-		rwhp := &responseWriterHijackerPusher{
-			responseWriter: rw,
-			Hijacker:       h,
-			Pusher:         p,
-		}
-		return rwhp, &rwhp.resp
-	case h != nil:
-		rwh := &responseWriterHijacker{
-			responseWriter: rw,
-			Hijacker:       h,
-		}
-		return rwh, &rwh.resp
-	case p != nil:
-		rwp := &responseWriterPusher{
-			responseWriter: rw,
-			Pusher:         p,
-		}
-		return rwp, &rwp.resp
-	}
-	return &rw, &rw.resp
-}
-
-// Response records details of the HTTP response.
-type Response struct {
-	// StatusCode records the HTTP status code set via WriteHeader.
-	StatusCode int
-
-	// Headers holds the headers set in the ResponseWriter.
-	Headers http.Header
-}
-
-type responseWriter struct {
-	http.ResponseWriter
-	resp Response
+	ctx.SetHTTPResponse(resp.StatusCode, resp.Headers)
 }
 
-// WriteHeader sets w.resp.StatusCode and calls through to the embedded
-// ResponseWriter.
-func (w *responseWriter) WriteHeader(statusCode int) {
-	w.ResponseWriter.WriteHeader(statusCode)
-	w.resp.StatusCode = statusCode
-}
-
-// Write calls through to the embedded ResponseWriter, setting
-// w.resp.StatusCode to http.StatusOK if WriteHeader has not already
-// been called.
-func (w *responseWriter) Write(data []byte) (int, error) {
-	n, err := w.ResponseWriter.Write(data)
-	if w.resp.StatusCode == 0 {
-		w.resp.StatusCode = http.StatusOK
-	}
-	return n, err
-}
-
-// CloseNotify returns w.closeNotify() if w.closeNotify is non-nil,
-// otherwise it returns nil.
-func (w *responseWriter) CloseNotify() <-chan bool {
-	if closeNotifier, ok := w.ResponseWriter.(http.CloseNotifier); ok {
-		return closeNotifier.CloseNotify()
+// reportResponseWriteSpan reports a span within tx for the time spent
+// writing the response body, as recorded in resp. The span starts at the
+// time of the first write, and its duration is the total time spent in
+// calls to Write.
+func reportResponseWriteSpan(tx *apm.Transaction, resp *Response) {
+	span := tx.StartSpanOptions("write response", "app.http.response", apm.SpanOptions{
+		Start: resp.firstWrite,
+	})
+	span.Duration = resp.writeDuration
+	if !span.Dropped() {
+		span.Context.SetLabel("bytes", resp.bytesWritten)
 	}
-	return nil
-}
-
-// Flush calls w.flush() if w.flush is non-nil, otherwise
-// it does nothing.
-func (w *responseWriter) Flush() {
-	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
-		flusher.Flush()
-	}
-}
-
-type responseWriterHijacker struct {
-	responseWriter
-	http.Hijacker
-}
-
-type responseWriterPusher struct {
-	responseWriter
-	http.Pusher
-}
-
-type responseWriterHijackerPusher struct {
-	responseWriter
-	http.Hijacker
-	http.Pusher
+	span.End()
 }
 
 // ServerOption sets options for tracing server requests.
@@ -318,6 +271,45 @@ func WithServerRequestIgnorer(r RequestIgnorerFunc) ServerOption {
 	}
 }
 
+// WithServerForceSampleHeader returns a ServerOption which causes requests
+// with a true value for the named header, such as "X-Debug-Trace: 1", to
+// always be sampled. The header value is parsed with strconv.ParseBool, so
+// "1", "t", and "true" force sampling, while "0", "false", and invalid
+// values do not. Forcing sampling overrides the tracer's sampler and any
+// sampling decision in the request's trace context, and the sampling
+// decision is propagated to downstream services.
+//
+// Anyone able to set the header can force sampling, so the header should
+// be stripped from untrusted requests before they reach the handler.
+func WithServerForceSampleHeader(name string) ServerOption {
+	return func(h *handler) {
+		h.forceSampleHeader = name
+	}
+}
+
+// WithServerBaggageLabels returns a ServerOption which causes the values of
+// the named members of the request's baggage, if present, to be recorded as
+// transaction labels.
+func WithServerBaggageLabels(keys ...string) ServerOption {
+	return func(h *handler) {
+		h.baggageLabels = append(h.baggageLabels, keys...)
+	}
+}
+
+// WithServerResponseWriteSpan returns a ServerOption which causes the time
+// spent writing the response body to be recorded as a span, "write response",
+// with the number of bytes written recorded in the label "bytes". This can be
+// used to distinguish the time spent computing a response from the time spent
+// writing it, e.g. when encoding large JSON responses.
+//
+// The span starts when the response body is first written, and its duration
+// is the total time spent in calls to the ResponseWriter's Write method.
+func WithServerResponseWriteSpan() ServerOption {
+	return func(h *handler) {
+		h.responseWriteSpan = true
+	}
+}
+
 // RequestWithContext is equivalent to req.WithContext, except that the URL
 // pointer is copied, rather than the contents.
 func RequestWithContext(ctx context.Context, req *http.Request) *http.Request {
@@ -69,6 +69,7 @@ func TestHandlerHTTP2(t *testing.T) {
 	payloads := transport.Payloads()
 	transaction := payloads.Transactions[0]
 
+	finished := true
 	assert.Equal(t, &model.Context{
 		Request: &model.Request{
 			Socket: &model.RequestSocket{
@@ -95,6 +96,7 @@ func TestHandlerHTTP2(t *testing.T) {
 		},
 		Response: &model.Response{
 			StatusCode: 418,
+			Finished:   &finished,
 		},
 	}, transaction.Context)
 }
@@ -83,6 +83,7 @@ func TestHandler(t *testing.T) {
 	assert.Equal(t, "request", transaction.Type)
 	assert.Equal(t, "HTTP 4xx", transaction.Result)
 
+	finished := true
 	assert.Equal(t, &model.Context{
 		Request: &model.Request{
 			Socket: &model.RequestSocket{
@@ -103,6 +104,7 @@ func TestHandler(t *testing.T) {
 		},
 		Response: &model.Response{
 			StatusCode: 418,
+			Finished:   &finished,
 		},
 	}, transaction.Context)
 }
@@ -292,9 +294,15 @@ func TestHandlerRecovery(t *testing.T) {
 
 	assert.Equal(t, "panicHandler", error0.Culprit)
 	assert.Equal(t, "foo", error0.Exception.Message)
+	require.NotNil(t, error0.Context)
+	require.NotNil(t, error0.Context.Request)
+	assert.Equal(t, "GET", error0.Context.Request.Method)
+	assert.Equal(t, transaction.Context.Request.URL, error0.Context.Request.URL)
 
+	finished := true
 	assert.Equal(t, &model.Response{
 		StatusCode: 418,
+		Finished:   &finished,
 	}, transaction.Context.Response)
 }
 
@@ -324,8 +332,9 @@ func TestHandlerRecoveryNoHeaders(t *testing.T) {
 	error0 := payloads.Errors[0]
 	transaction := payloads.Transactions[0]
 
-	assert.Equal(t, &model.Response{StatusCode: resp.StatusCode}, transaction.Context.Response)
-	assert.Equal(t, &model.Response{StatusCode: resp.StatusCode}, error0.Context.Response)
+	finished := true
+	assert.Equal(t, &model.Response{StatusCode: resp.StatusCode, Finished: &finished}, transaction.Context.Response)
+	assert.Equal(t, &model.Response{StatusCode: resp.StatusCode, Finished: &finished}, error0.Context.Response)
 }
 
 func TestHandlerWithPanicPropagation(t *testing.T) {
@@ -357,8 +366,9 @@ func TestHandlerWithPanicPropagation(t *testing.T) {
 	error0 := payloads.Errors[0]
 	transaction := payloads.Transactions[0]
 
-	assert.Equal(t, &model.Response{StatusCode: http.StatusInternalServerError}, transaction.Context.Response)
-	assert.Equal(t, &model.Response{StatusCode: http.StatusInternalServerError}, error0.Context.Response)
+	finished := true
+	assert.Equal(t, &model.Response{StatusCode: http.StatusInternalServerError, Finished: &finished}, transaction.Context.Response)
+	assert.Equal(t, &model.Response{StatusCode: http.StatusInternalServerError, Finished: &finished}, error0.Context.Response)
 }
 
 func TestHandlerWithPanicPropagationResponseCodeForwarding(t *testing.T) {
@@ -388,8 +398,9 @@ func TestHandlerWithPanicPropagationResponseCodeForwarding(t *testing.T) {
 	error0 := payloads.Errors[0]
 	transaction := payloads.Transactions[0]
 
-	assert.Equal(t, &model.Response{StatusCode: resp.StatusCode}, transaction.Context.Response)
-	assert.Equal(t, &model.Response{StatusCode: resp.StatusCode}, error0.Context.Response)
+	finished := true
+	assert.Equal(t, &model.Response{StatusCode: resp.StatusCode, Finished: &finished}, transaction.Context.Response)
+	assert.Equal(t, &model.Response{StatusCode: resp.StatusCode, Finished: &finished}, error0.Context.Response)
 }
 
 func TestHandlerRequestIgnorer(t *testing.T) {
@@ -411,6 +422,90 @@ func TestHandlerRequestIgnorer(t *testing.T) {
 	assert.Empty(t, transport.Payloads())
 }
 
+func TestHandlerForceSampleHeader(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetSampler(apm.NewRatioSampler(0))
+
+	var outgoingTraceparent []string
+	h := apmhttp.Wrap(
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			tx := apm.TransactionFromContext(req.Context())
+			outgoingTraceparent = append(outgoingTraceparent, apmhttp.FormatTraceparentHeader(tx.TraceContext()))
+		}),
+		apmhttp.WithTracer(tracer),
+		apmhttp.WithServerForceSampleHeader("X-Debug-Trace"),
+	)
+
+	const unsampledTraceparent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00"
+	for _, traceparent := range []string{"", unsampledTraceparent} {
+		req, _ := http.NewRequest("GET", "http://server.testing/foo", nil)
+		req.Header.Set("X-Debug-Trace", "1")
+		if traceparent != "" {
+			req.Header.Set("Traceparent", traceparent)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	req, _ := http.NewRequest("GET", "http://server.testing/foo", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	for _, value := range []string{"0", "false", "yes"} {
+		req, _ := http.NewRequest("GET", "http://server.testing/foo", nil)
+		req.Header.Set("X-Debug-Trace", value)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 6)
+	for i, transaction := range payloads.Transactions[:2] {
+		assert.Nil(t, transaction.Sampled)
+		require.NotNil(t, transaction.Context)
+		assert.Equal(t, "/foo", transaction.Context.Request.URL.Path)
+		assert.True(t, strings.HasSuffix(outgoingTraceparent[i], "-01"))
+	}
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", apm.TraceID(payloads.Transactions[1].TraceID).String())
+	for i, transaction := range payloads.Transactions[2:] {
+		// Requests without the header, or with a false
+		// or invalid value, are not forcibly sampled.
+		assert.False(t, *transaction.Sampled)
+		assert.True(t, strings.HasSuffix(outgoingTraceparent[i+2], "-00"))
+	}
+}
+
+func TestHandlerSamplingHeader(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetSampler(apm.NewRatioSampler(0))
+	tracer.SetSamplingHeader("X-B3-Sampled")
+
+	h := apmhttp.Wrap(http.NotFoundHandler(), apmhttp.WithTracer(tracer))
+	makeReq := func(headers ...string) *http.Request {
+		req, _ := http.NewRequest("GET", "http://server.testing/foo", nil)
+		for i := 0; i < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		return req
+	}
+
+	const unsampledTraceparent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00"
+	h.ServeHTTP(httptest.NewRecorder(), makeReq("X-B3-Sampled", "1"))
+	h.ServeHTTP(httptest.NewRecorder(), makeReq("X-B3-Sampled", "nonsense"))
+	h.ServeHTTP(httptest.NewRecorder(), makeReq("X-B3-Sampled", "1", "Traceparent", unsampledTraceparent))
+
+	tracer.SetSampler(nil)
+	h.ServeHTTP(httptest.NewRecorder(), makeReq("X-B3-Sampled", "0"))
+	h.ServeHTTP(httptest.NewRecorder(), makeReq())
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 5)
+	var sampled []bool
+	for _, transaction := range payloads.Transactions {
+		sampled = append(sampled, transaction.Sampled == nil || *transaction.Sampled)
+	}
+	assert.Equal(t, []bool{true, false, false, false, true}, sampled)
+}
+
 func TestHandlerTraceparentHeader(t *testing.T) {
 	tracer, transport := transporttest.NewRecorderTracer()
 	defer tracer.Close()
@@ -477,6 +572,193 @@ func TestHandlerTracestateHeader(t *testing.T) {
 	assert.Equal(t, "", w.Body.String())
 }
 
+func TestHandlerBaggageHeader(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	var baggage []string
+	h := apmhttp.Wrap(
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			tx := apm.TransactionFromContext(req.Context())
+			baggage = append(baggage, tx.TraceContext().Baggage.String())
+		}),
+		apmhttp.WithTracer(tracer),
+		apmhttp.WithServerBaggageLabels("tenant", "missing"),
+	)
+
+	for _, value := range []string{"tenant=acme,locale=en-AU", "tenant"} {
+		req, _ := http.NewRequest("GET", "http://server.testing/foo", nil)
+		req.Header.Set("Baggage", value)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	tracer.Flush(nil)
+
+	// Baggage is propagated with or without a traceparent header,
+	// and invalid baggage is ignored.
+	assert.Equal(t, []string{"tenant=acme,locale=en-AU", ""}, baggage)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 2)
+	assert.Equal(t, model.IfaceMap{{Key: "tenant", Value: "acme"}}, payloads.Transactions[0].Context.Tags)
+	assert.Empty(t, payloads.Transactions[1].Context.Tags)
+}
+
+func TestHandlerResponseWriteSpan(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	h := apmhttp.Wrap(
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.URL.Path == "/empty" {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			w.Write([]byte("hello, "))
+			w.(http.Flusher).Flush()
+			w.Write([]byte("world"))
+		}),
+		apmhttp.WithTracer(tracer),
+		apmhttp.WithServerResponseWriteSpan(),
+	)
+	for _, path := range []string{"/hello", "/empty"} {
+		req, _ := http.NewRequest("GET", "http://server.testing"+path, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if path == "/hello" {
+			assert.Equal(t, "hello, world", w.Body.String())
+			assert.True(t, w.Flushed)
+		}
+	}
+	tracer.Flush(nil)
+
+	// No span is reported for responses without a body.
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 2)
+	require.Len(t, payloads.Spans, 1)
+	span := payloads.Spans[0]
+	assert.Equal(t, "write response", span.Name)
+	assert.Equal(t, "app", span.Type)
+	assert.Equal(t, "http", span.Subtype)
+	assert.Equal(t, "response", span.Action)
+	assert.Equal(t, payloads.Transactions[0].ID, span.ParentID)
+	assert.Equal(t, model.IfaceMap{{Key: "bytes", Value: float64(12)}}, span.Context.Tags)
+	assert.True(t, span.Duration <= payloads.Transactions[0].Duration)
+}
+
+func TestWrapResponseWriterInterfaces(t *testing.T) {
+	type interfaces struct {
+		http.ResponseWriter
+		http.Flusher
+		http.Hijacker
+		http.CloseNotifier
+		io.ReaderFrom
+		http.Pusher
+	}
+	implements := func(w http.ResponseWriter) []bool {
+		_, flusher := w.(http.Flusher)
+		_, hijacker := w.(http.Hijacker)
+		_, closeNotifier := w.(http.CloseNotifier)
+		_, readerFrom := w.(io.ReaderFrom)
+		_, pusher := w.(http.Pusher)
+		return []bool{flusher, hijacker, closeNotifier, readerFrom, pusher}
+	}
+
+	for _, in := range []http.ResponseWriter{
+		struct{ http.ResponseWriter }{httptest.NewRecorder()},
+		httptest.NewRecorder(),
+		struct {
+			http.ResponseWriter
+			http.Hijacker
+		}{ResponseWriter: httptest.NewRecorder()},
+		struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+			http.CloseNotifier
+			io.ReaderFrom
+		}{ResponseWriter: httptest.NewRecorder()},
+		struct {
+			http.ResponseWriter
+			http.CloseNotifier
+			http.Pusher
+		}{ResponseWriter: httptest.NewRecorder()},
+		interfaces{ResponseWriter: httptest.NewRecorder()},
+	} {
+		out, _ := apmhttp.WrapResponseWriter(in)
+		assert.Equal(t, implements(in), implements(out), "%T", in)
+	}
+}
+
+func TestWrapResponseWriterReaderFrom(t *testing.T) {
+	server := httptest.NewServer(apmhttp.Wrap(
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			n, err := w.(io.ReaderFrom).ReadFrom(strings.NewReader("hello"))
+			assert.NoError(t, err)
+			assert.Equal(t, int64(5), n)
+		}),
+		apmhttp.WithTracer(apmtest.DiscardTracer),
+	))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "hello", string(body))
+}
+
+func TestHandlerHijack(t *testing.T) {
+	server := httptest.NewServer(apmhttp.Wrap(
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			conn, rw, err := w.(http.Hijacker).Hijack()
+			require.NoError(t, err)
+			defer conn.Close()
+			rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhijacked")
+			rw.Flush()
+		}),
+		apmhttp.WithTracer(apmtest.DiscardTracer),
+	))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hijacked", string(body))
+}
+
+func TestHandlerFlush(t *testing.T) {
+	proceed := make(chan struct{})
+	server := httptest.NewServer(apmhttp.Wrap(
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte("data: first\n\n"))
+			w.(http.Flusher).Flush()
+			<-proceed
+			w.Write([]byte("data: second\n\n"))
+		}),
+		apmhttp.WithTracer(apmtest.DiscardTracer),
+	))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	// The first event must be received before the handler returns.
+	buf := make([]byte, len("data: first\n\n"))
+	_, err = io.ReadFull(resp.Body, buf)
+	close(proceed)
+	require.NoError(t, err)
+	assert.Equal(t, "data: first\n\n", string(buf))
+
+	rest, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "data: second\n\n", string(rest))
+}
+
 func panicHandler(w http.ResponseWriter, req *http.Request) {
 	w.WriteHeader(http.StatusTeapot)
 	panic("foo")
@@ -497,3 +779,52 @@ func recoveryMiddleware(code int) func(next http.Handler) http.Handler {
 		})
 	}
 }
+
+func TestHandlerResponseContext(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	h := apmhttp.Wrap(
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+			switch req.URL.Path {
+			case "/notfound":
+				w.WriteHeader(http.StatusNotFound)
+			case "/error":
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}),
+		apmhttp.WithTracer(tracer),
+	)
+	for _, path := range []string{"/ok", "/notfound", "/error"} {
+		req, _ := http.NewRequest("GET", "http://server.testing"+path, nil)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 3)
+	for i, expect := range []struct {
+		statusCode int
+		result     string
+		outcome    string
+	}{
+		{http.StatusOK, "HTTP 2xx", "success"},
+		{http.StatusNotFound, "HTTP 4xx", "success"},
+		{http.StatusInternalServerError, "HTTP 5xx", "failure"},
+	} {
+		transaction := payloads.Transactions[i]
+		assert.Equal(t, expect.result, transaction.Result)
+		assert.Equal(t, expect.outcome, transaction.Outcome)
+
+		finished := true
+		assert.Equal(t, &model.Response{
+			StatusCode: expect.statusCode,
+			Headers: model.Headers{{
+				Key:    "Set-Cookie",
+				Values: []string{"[REDACTED]"},
+			}},
+			Finished: &finished,
+		}, transaction.Context.Response)
+	}
+}
@@ -0,0 +1,366 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmhttp
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// The optional interfaces of http.ResponseWriter which are
+// exposed by the wrapper returned by WrapResponseWriter.
+const (
+	flusher = 1 << iota
+	hijacker
+	closeNotifier
+	readerFrom
+	pusher
+)
+
+// WrapResponseWriter wraps an http.ResponseWriter and returns the wrapped
+// value along with a *Response which will be filled in when the handler
+// is called. The *Response value must not be inspected until after the
+// request has been handled, to avoid data races. If neither of the
+// ResponseWriter's Write or WriteHeader methods are called, then the
+// response's StatusCode field will be zero.
+//
+// The returned http.ResponseWriter implements http.Flusher, http.Hijacker,
+// http.CloseNotifier, io.ReaderFrom, and http.Pusher if and only if the
+// provided http.ResponseWriter does, so that wrapping does not break
+// streaming or websocket handlers. The provided http.ResponseWriter can
+// be obtained from the returned one through an "Unwrap" method, as used
+// by http.ResponseController.
+func WrapResponseWriter(w http.ResponseWriter) (http.ResponseWriter, *Response) {
+	rw := &responseWriter{
+		ResponseWriter: w,
+		resp: Response{
+			Headers: w.Header(),
+		},
+	}
+
+	var interfaces int
+	f, ok := w.(http.Flusher)
+	if ok {
+		interfaces |= flusher
+	}
+	h, ok := w.(http.Hijacker)
+	if ok {
+		interfaces |= hijacker
+	}
+	c, ok := w.(http.CloseNotifier)
+	if ok {
+		interfaces |= closeNotifier
+	}
+	if _, ok := w.(io.ReaderFrom); ok {
+		interfaces |= readerFrom
+	}
+	p, ok := w.(http.Pusher)
+	if ok {
+		interfaces |= pusher
+	}
+
+	switch interfaces {
+	case flusher:
+		return struct {
+			*responseWriter
+			http.Flusher
+		}{rw, f}, &rw.resp
+	case hijacker:
+		return struct {
+			*responseWriter
+			http.Hijacker
+		}{rw, h}, &rw.resp
+	case flusher | hijacker:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Hijacker
+		}{rw, f, h}, &rw.resp
+	case closeNotifier:
+		return struct {
+			*responseWriter
+			http.CloseNotifier
+		}{rw, c}, &rw.resp
+	case flusher | closeNotifier:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.CloseNotifier
+		}{rw, f, c}, &rw.resp
+	case hijacker | closeNotifier:
+		return struct {
+			*responseWriter
+			http.Hijacker
+			http.CloseNotifier
+		}{rw, h, c}, &rw.resp
+	case flusher | hijacker | closeNotifier:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Hijacker
+			http.CloseNotifier
+		}{rw, f, h, c}, &rw.resp
+	case readerFrom:
+		return struct {
+			*responseWriter
+			io.ReaderFrom
+		}{rw, responseReaderFrom{rw}}, &rw.resp
+	case flusher | readerFrom:
+		return struct {
+			*responseWriter
+			http.Flusher
+			io.ReaderFrom
+		}{rw, f, responseReaderFrom{rw}}, &rw.resp
+	case hijacker | readerFrom:
+		return struct {
+			*responseWriter
+			http.Hijacker
+			io.ReaderFrom
+		}{rw, h, responseReaderFrom{rw}}, &rw.resp
+	case flusher | hijacker | readerFrom:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{rw, f, h, responseReaderFrom{rw}}, &rw.resp
+	case closeNotifier | readerFrom:
+		return struct {
+			*responseWriter
+			http.CloseNotifier
+			io.ReaderFrom
+		}{rw, c, responseReaderFrom{rw}}, &rw.resp
+	case flusher | closeNotifier | readerFrom:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.CloseNotifier
+			io.ReaderFrom
+		}{rw, f, c, responseReaderFrom{rw}}, &rw.resp
+	case hijacker | closeNotifier | readerFrom:
+		return struct {
+			*responseWriter
+			http.Hijacker
+			http.CloseNotifier
+			io.ReaderFrom
+		}{rw, h, c, responseReaderFrom{rw}}, &rw.resp
+	case flusher | hijacker | closeNotifier | readerFrom:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Hijacker
+			http.CloseNotifier
+			io.ReaderFrom
+		}{rw, f, h, c, responseReaderFrom{rw}}, &rw.resp
+	case pusher:
+		return struct {
+			*responseWriter
+			http.Pusher
+		}{rw, p}, &rw.resp
+	case flusher | pusher:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Pusher
+		}{rw, f, p}, &rw.resp
+	case hijacker | pusher:
+		return struct {
+			*responseWriter
+			http.Hijacker
+			http.Pusher
+		}{rw, h, p}, &rw.resp
+	case flusher | hijacker | pusher:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{rw, f, h, p}, &rw.resp
+	case closeNotifier | pusher:
+		return struct {
+			*responseWriter
+			http.CloseNotifier
+			http.Pusher
+		}{rw, c, p}, &rw.resp
+	case flusher | closeNotifier | pusher:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.CloseNotifier
+			http.Pusher
+		}{rw, f, c, p}, &rw.resp
+	case hijacker | closeNotifier | pusher:
+		return struct {
+			*responseWriter
+			http.Hijacker
+			http.CloseNotifier
+			http.Pusher
+		}{rw, h, c, p}, &rw.resp
+	case flusher | hijacker | closeNotifier | pusher:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Hijacker
+			http.CloseNotifier
+			http.Pusher
+		}{rw, f, h, c, p}, &rw.resp
+	case readerFrom | pusher:
+		return struct {
+			*responseWriter
+			io.ReaderFrom
+			http.Pusher
+		}{rw, responseReaderFrom{rw}, p}, &rw.resp
+	case flusher | readerFrom | pusher:
+		return struct {
+			*responseWriter
+			http.Flusher
+			io.ReaderFrom
+			http.Pusher
+		}{rw, f, responseReaderFrom{rw}, p}, &rw.resp
+	case hijacker | readerFrom | pusher:
+		return struct {
+			*responseWriter
+			http.Hijacker
+			io.ReaderFrom
+			http.Pusher
+		}{rw, h, responseReaderFrom{rw}, p}, &rw.resp
+	case flusher | hijacker | readerFrom | pusher:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+			http.Pusher
+		}{rw, f, h, responseReaderFrom{rw}, p}, &rw.resp
+	case closeNotifier | readerFrom | pusher:
+		return struct {
+			*responseWriter
+			http.CloseNotifier
+			io.ReaderFrom
+			http.Pusher
+		}{rw, c, responseReaderFrom{rw}, p}, &rw.resp
+	case flusher | closeNotifier | readerFrom | pusher:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.CloseNotifier
+			io.ReaderFrom
+			http.Pusher
+		}{rw, f, c, responseReaderFrom{rw}, p}, &rw.resp
+	case hijacker | closeNotifier | readerFrom | pusher:
+		return struct {
+			*responseWriter
+			http.Hijacker
+			http.CloseNotifier
+			io.ReaderFrom
+			http.Pusher
+		}{rw, h, c, responseReaderFrom{rw}, p}, &rw.resp
+	case flusher | hijacker | closeNotifier | readerFrom | pusher:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Hijacker
+			http.CloseNotifier
+			io.ReaderFrom
+			http.Pusher
+		}{rw, f, h, c, responseReaderFrom{rw}, p}, &rw.resp
+	}
+	return rw, &rw.resp
+}
+
+// Response records details of the HTTP response.
+type Response struct {
+	// StatusCode records the HTTP status code set via WriteHeader.
+	StatusCode int
+
+	// Headers holds the headers set in the ResponseWriter.
+	Headers http.Header
+
+	// firstWrite records the time at which the response body was first
+	// written, and writeDuration the total time spent writing it. These
+	// are recorded only if timeWrites is true.
+	timeWrites    bool
+	firstWrite    time.Time
+	writeDuration time.Duration
+	bytesWritten  int64
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	resp Response
+}
+
+// Unwrap returns the wrapped http.ResponseWriter.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// WriteHeader sets w.resp.StatusCode and calls through to the embedded
+// ResponseWriter.
+func (w *responseWriter) WriteHeader(statusCode int) {
+	w.ResponseWriter.WriteHeader(statusCode)
+	w.resp.StatusCode = statusCode
+}
+
+// Write calls through to the embedded ResponseWriter, setting
+// w.resp.StatusCode to http.StatusOK if WriteHeader has not already
+// been called, and recording the time spent writing if enabled.
+func (w *responseWriter) Write(data []byte) (int, error) {
+	start := w.startWrite()
+	n, err := w.ResponseWriter.Write(data)
+	w.wrote(start, int64(n))
+	return n, err
+}
+
+// startWrite returns the current time if writes are being timed,
+// and the zero time otherwise.
+func (w *responseWriter) startWrite() time.Time {
+	if !w.resp.timeWrites {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+func (w *responseWriter) wrote(start time.Time, n int64) {
+	if !start.IsZero() {
+		if w.resp.firstWrite.IsZero() {
+			w.resp.firstWrite = start
+		}
+		w.resp.writeDuration += time.Since(start)
+	}
+	w.resp.bytesWritten += n
+	if w.resp.StatusCode == 0 {
+		w.resp.StatusCode = http.StatusOK
+	}
+}
+
+// responseReaderFrom implements io.ReaderFrom for a responseWriter whose
+// embedded ResponseWriter implements io.ReaderFrom.
+type responseReaderFrom struct {
+	w *responseWriter
+}
+
+// ReadFrom calls through to the embedded ResponseWriter's ReadFrom
+// method, recording the response like responseWriter.Write.
+func (r responseReaderFrom) ReadFrom(src io.Reader) (int64, error) {
+	start := r.w.startWrite()
+	n, err := r.w.ResponseWriter.(io.ReaderFrom).ReadFrom(src)
+	r.w.wrote(start, n)
+	return n, err
+}
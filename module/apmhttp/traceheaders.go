@@ -20,6 +20,8 @@ package apmhttp
 import (
 	"encoding/hex"
 	"fmt"
+	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -47,6 +49,10 @@ const (
 	// TracestateHeader is the standard W3C Trace-Context HTTP header
 	// for vendor-specific trace propagation.
 	TracestateHeader = "Tracestate"
+
+	// BaggageHeader is the standard W3C Baggage HTTP header for
+	// propagating application-defined key-value pairs.
+	BaggageHeader = "Baggage"
 )
 
 // FormatTraceparentHeader formats the given trace context as a
@@ -166,3 +172,67 @@ func ParseTracestateHeader(h ...string) (apm.TraceState, error) {
 	}
 	return apm.NewTraceState(entries...), nil
 }
+
+// ParseBaggageHeader parses the given header, which is expected to be in the
+// W3C Baggage format:
+//    https://w3c.github.io/baggage/#baggage-http-header-format
+//
+// Member values are percent-decoded. Note that the returned Baggage is not
+// necessarily valid; the caller must validate it as required using its
+// Validate method.
+//
+// Multiple header values may be presented, in which case they will be treated as
+// if they are concatenated together with commas.
+func ParseBaggageHeader(h ...string) (apm.Baggage, error) {
+	var members []apm.BaggageMember
+	for _, h := range h {
+		for _, member := range strings.Split(h, ",") {
+			member = strings.TrimSpace(member)
+			if member == "" {
+				continue
+			}
+			var properties string
+			if semicolon := strings.IndexRune(member, ';'); semicolon != -1 {
+				properties = strings.TrimSpace(member[semicolon+1:])
+				member = member[:semicolon]
+			}
+			equal := strings.IndexRune(member, '=')
+			if equal == -1 {
+				return apm.Baggage{}, errors.New("missing '=' in baggage member")
+			}
+			value, err := url.PathUnescape(strings.TrimSpace(member[equal+1:]))
+			if err != nil {
+				return apm.Baggage{}, errors.Wrap(err, "error decoding baggage value")
+			}
+			members = append(members, apm.BaggageMember{
+				Key:        strings.TrimSpace(member[:equal]),
+				Value:      value,
+				Properties: properties,
+			})
+		}
+	}
+	return apm.NewBaggage(members...), nil
+}
+
+// ParseSamplingHeader parses the given header as a sampling decision, as
+// conveyed by headers such as "X-B3-Sampled" or vendor-specific sampling
+// priority headers; see apm.Tracer.SetSamplingHeader.
+//
+// The values "1" and "true" indicate that the trace is sampled, and "0" and
+// "false" that it is not. Any other integer is treated as a sampling priority,
+// and indicates that the trace is sampled if positive. The B3 debug flag "d"
+// is valid only in the single "b3" header, not in "X-B3-Sampled", and is
+// rejected.
+func ParseSamplingHeader(h string) (bool, error) {
+	switch h = strings.TrimSpace(h); strings.ToLower(h) {
+	case "1", "true":
+		return true, nil
+	case "0", "false":
+		return false, nil
+	}
+	priority, err := strconv.Atoi(h)
+	if err != nil {
+		return false, errors.Errorf("invalid sampling header %q", h)
+	}
+	return priority > 0, nil
+}
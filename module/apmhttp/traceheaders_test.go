@@ -89,3 +89,54 @@ func TestParseTracestateHeader(t *testing.T) {
 	tracestate, _ = assertParse("vendorname1=opaqueValue1", "vendorname2=opaqueValue2")
 	assert.Equal(t, "vendorname1=opaqueValue1,vendorname2=opaqueValue2", tracestate.String())
 }
+
+func TestParseSamplingHeader(t *testing.T) {
+	for h, expect := range map[string]bool{
+		"1":     true,
+		"true":  true,
+		" 2 ":   true,
+		"0":     false,
+		"False": false,
+		"-1":    false,
+	} {
+		sampled, err := apmhttp.ParseSamplingHeader(h)
+		assert.NoError(t, err)
+		assert.Equal(t, expect, sampled, h)
+	}
+
+	_, err := apmhttp.ParseSamplingHeader("yes")
+	assert.EqualError(t, err, `invalid sampling header "yes"`)
+	_, err = apmhttp.ParseSamplingHeader("d")
+	assert.EqualError(t, err, `invalid sampling header "d"`)
+}
+
+func TestParseBaggageHeader(t *testing.T) {
+	assertParseError := func(h, expect string) {
+		_, err := apmhttp.ParseBaggageHeader(h)
+		if assert.Error(t, err) {
+			assert.Regexp(t, expect, err.Error())
+		}
+	}
+
+	assertParseError("a", `missing '=' in baggage member`)
+	assertParseError("a=b, c ", `missing '=' in baggage member`)
+	assertParseError("a=%zz", `error decoding baggage value`)
+
+	assertParse := func(h ...string) (apm.Baggage, bool) {
+		out, err := apmhttp.ParseBaggageHeader(h...)
+		return out, assert.NoError(t, err)
+	}
+
+	baggage, _ := assertParse("tenant=acme, locale = en-AU;p1;p2=v")
+	assert.Equal(t, []apm.BaggageMember{
+		{Key: "tenant", Value: "acme"},
+		{Key: "locale", Value: "en-AU", Properties: "p1;p2=v"},
+	}, baggage.Members())
+	assert.Equal(t, "tenant=acme,locale=en-AU;p1;p2=v", baggage.String())
+
+	baggage, _ = assertParse("tenant=acme", "greeting=hello%2C%20world")
+	value, ok := baggage.Get("greeting")
+	assert.True(t, ok)
+	assert.Equal(t, "hello, world", value)
+	assert.Equal(t, "tenant=acme,greeting=hello%2C%20world", baggage.String())
+}
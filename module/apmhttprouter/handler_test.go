@@ -61,6 +61,7 @@ func TestWrap(t *testing.T) {
 	assert.Equal(t, "request", transaction.Type)
 	assert.Equal(t, "HTTP 4xx", transaction.Result)
 
+	finished := true
 	assert.Equal(t, &model.Context{
 		Request: &model.Request{
 			Socket: &model.RequestSocket{
@@ -81,6 +82,7 @@ func TestWrap(t *testing.T) {
 		},
 		Response: &model.Response{
 			StatusCode: 418,
+			Finished:   &finished,
 		},
 	}, transaction.Context)
 }
@@ -110,8 +112,10 @@ func TestRecovery(t *testing.T) {
 	assert.Equal(t, "panicHandler", error0.Culprit)
 	assert.Equal(t, "foo", error0.Exception.Message)
 
+	finished := true
 	assert.Equal(t, &model.Response{
 		StatusCode: 418,
+		Finished:   &finished,
 	}, transaction.Context.Response)
 }
 
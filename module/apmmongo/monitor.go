@@ -34,6 +34,8 @@ import (
 	"go.elastic.co/apm"
 )
 
+var instrumentation = apm.RegisterInstrumentation("mongodb")
+
 var (
 	extjPool = bsonrw.NewExtJSONValueWriterPool()
 	swPool   = sync.Pool{
@@ -92,7 +94,7 @@ func (c *commandMonitor) started(ctx context.Context, event *event.CommandStarte
 	if collectionName, ok := collectionName(event.CommandName, event.Command); ok {
 		spanName = collectionName + "." + spanName
 	}
-	span, _ := apm.StartSpan(ctx, spanName, "db.mongodb.query")
+	span, _ := instrumentation.StartSpan(ctx, spanName, "db.mongodb.query")
 	if span.Dropped() {
 		return
 	}
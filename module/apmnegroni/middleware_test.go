@@ -84,6 +84,7 @@ func TestMiddleware(t *testing.T) {
 	assert.Equal(t, "request", transaction.Type)
 	assert.Equal(t, "HTTP 4xx", transaction.Result)
 
+	finished := true
 	assert.Equal(t, &model.Context{
 		Request: &model.Request{
 			Socket: &model.RequestSocket{
@@ -104,6 +105,7 @@ func TestMiddleware(t *testing.T) {
 		},
 		Response: &model.Response{
 			StatusCode: 418,
+			Finished:   &finished,
 		},
 	}, transaction.Context)
 }
@@ -128,8 +130,10 @@ func TestMiddlewareRecovery(t *testing.T) {
 	assert.Equal(t, "panicHandler", error0.Culprit)
 	assert.Equal(t, "foo", error0.Exception.Message)
 
+	finished := true
 	assert.Equal(t, &model.Response{
 		StatusCode: 418,
+		Finished:   &finished,
 	}, transaction.Context.Response)
 }
 
@@ -158,8 +162,9 @@ func TestMiddlewareRecoveryNoHeaders(t *testing.T) {
 	error0 := payloads.Errors[0]
 	transaction := payloads.Transactions[0]
 
-	assert.Equal(t, &model.Response{StatusCode: resp.StatusCode}, transaction.Context.Response)
-	assert.Equal(t, &model.Response{StatusCode: resp.StatusCode}, error0.Context.Response)
+	finished := true
+	assert.Equal(t, &model.Response{StatusCode: resp.StatusCode, Finished: &finished}, transaction.Context.Response)
+	assert.Equal(t, &model.Response{StatusCode: resp.StatusCode, Finished: &finished}, error0.Context.Response)
 }
 
 func TestMiddlewareWithPanicPropagation(t *testing.T) {
@@ -189,8 +194,9 @@ func TestMiddlewareWithPanicPropagation(t *testing.T) {
 	error0 := payloads.Errors[0]
 	transaction := payloads.Transactions[0]
 
-	assert.Equal(t, &model.Response{StatusCode: http.StatusInternalServerError}, transaction.Context.Response)
-	assert.Equal(t, &model.Response{StatusCode: http.StatusInternalServerError}, error0.Context.Response)
+	finished := true
+	assert.Equal(t, &model.Response{StatusCode: http.StatusInternalServerError, Finished: &finished}, transaction.Context.Response)
+	assert.Equal(t, &model.Response{StatusCode: http.StatusInternalServerError, Finished: &finished}, error0.Context.Response)
 }
 
 func TestMiddlewareWithPanicPropagationResponseCodeForwarding(t *testing.T) {
@@ -217,8 +223,9 @@ func TestMiddlewareWithPanicPropagationResponseCodeForwarding(t *testing.T) {
 	error0 := payloads.Errors[0]
 	transaction := payloads.Transactions[0]
 
-	assert.Equal(t, &model.Response{StatusCode: resp.StatusCode}, transaction.Context.Response)
-	assert.Equal(t, &model.Response{StatusCode: resp.StatusCode}, error0.Context.Response)
+	finished := true
+	assert.Equal(t, &model.Response{StatusCode: resp.StatusCode, Finished: &finished}, transaction.Context.Response)
+	assert.Equal(t, &model.Response{StatusCode: resp.StatusCode, Finished: &finished}, error0.Context.Response)
 }
 
 func TestMiddlewareRequestIgnorer(t *testing.T) {
@@ -142,12 +142,42 @@ func stringify(v interface{}) string {
 	return fmt.Sprint(v)
 }
 
+// intValue returns v as an int if it is a value of one of
+// Go's integer types, which is how OpenTracing tags such as
+// "http.status_code" are commonly recorded.
+func intValue(v interface{}) (int, bool) {
+	switch v := v.(type) {
+	case int:
+		return v, true
+	case int8:
+		return int(v), true
+	case int16:
+		return int(v), true
+	case int32:
+		return int(v), true
+	case int64:
+		return int(v), true
+	case uint:
+		return int(v), true
+	case uint8:
+		return int(v), true
+	case uint16:
+		return int(v), true
+	case uint32:
+		return int(v), true
+	case uint64:
+		return int(v), true
+	}
+	return 0, false
+}
+
 func (s *otSpan) setSpanContext() {
 	var (
 		dbContext       apm.DatabaseSpanContext
 		component       string
 		httpURL         string
 		httpMethod      string
+		httpStatusCode  = -1
 		haveDBContext   bool
 		haveHTTPContext bool
 	)
@@ -173,6 +203,12 @@ func (s *otSpan) setSpanContext() {
 		case "http.method":
 			haveHTTPContext = true
 			httpMethod = stringify(v)
+		case "http.status_code":
+			if code, ok := intValue(v); ok {
+				httpStatusCode = code
+			} else {
+				s.span.Context.SetLabel(k, stringify(v))
+			}
 
 		// Elastic APM-specific tags:
 		case "type":
@@ -197,6 +233,9 @@ func (s *otSpan) setSpanContext() {
 			req.URL = url
 			s.span.Context.SetHTTPRequest(&req)
 		}
+		if httpStatusCode != -1 {
+			s.span.Context.SetHTTPStatusCode(httpStatusCode)
+		}
 	case haveDBContext:
 		if s.span.Type == "" {
 			s.span.Type = "db"
@@ -204,6 +243,9 @@ func (s *otSpan) setSpanContext() {
 		}
 		s.span.Context.SetDatabase(dbContext)
 	}
+	if httpStatusCode != -1 && !haveHTTPContext {
+		s.span.Context.SetLabel("http.status_code", httpStatusCode)
+	}
 	if s.span.Type == "" {
 		s.span.Type = "custom"
 		s.span.Subtype = component
@@ -225,8 +267,8 @@ func (s *otSpan) setTransactionContext() {
 		case "http.method":
 			httpMethod = stringify(v)
 		case "http.status_code":
-			if code, ok := v.(uint16); ok {
-				httpStatusCode = int(code)
+			if code, ok := intValue(v); ok {
+				httpStatusCode = code
 			}
 		case "http.url":
 			httpURL = stringify(v)
@@ -169,6 +169,7 @@ func TestHTTPSpan(t *testing.T) {
 	span := tracer.StartSpan("child", opentracing.ChildOf(txSpan.Context()))
 	ext.HTTPMethod.Set(span, "GET")
 	ext.HTTPUrl.Set(span, clientURL)
+	span.SetTag("http.status_code", 503) // int, rather than uint16
 	span.Finish()
 	txSpan.Finish()
 
@@ -179,7 +180,7 @@ func TestHTTPSpan(t *testing.T) {
 	assert.Equal(t, "external", modelSpan.Type)
 	assert.Equal(t, "http", modelSpan.Subtype)
 	assert.Equal(t, &model.SpanContext{
-		HTTP: &model.HTTPSpanContext{URL: url},
+		HTTP: &model.HTTPSpanContext{URL: url, StatusCode: 503},
 		Destination: &model.DestinationSpanContext{
 			Address: "testing.invalid",
 			Port:    8443,
@@ -189,6 +190,12 @@ func TestHTTPSpan(t *testing.T) {
 				Resource: "testing.invalid:8443",
 			},
 		},
+		Service: &model.ServiceSpanContext{
+			Target: &model.ServiceTargetSpanContext{
+				Type: "http",
+				Name: "testing.invalid:8443",
+			},
+		},
 	}, modelSpan.Context)
 }
 
@@ -27,6 +27,8 @@ import (
 	"go.elastic.co/apm"
 )
 
+var instrumentation = apm.RegisterInstrumentation("redis")
+
 // Conn is the interface returned by ContextConn.
 //
 // Conn's Do method reports spans using the bound context.
@@ -93,8 +95,9 @@ func Do(ctx context.Context, conn redis.Conn, commandName string, args ...interf
 	if spanName == "" {
 		spanName = "(flush pipeline)"
 	}
-	span, _ := apm.StartSpan(ctx, spanName, "db.redis")
+	span, _ := instrumentation.StartSpan(ctx, spanName, "db.redis")
 	defer span.End()
+	setDestination(span)
 	return conn.Do(commandName, args...)
 }
 
@@ -104,7 +107,16 @@ func DoWithTimeout(ctx context.Context, conn redis.Conn, timeout time.Duration,
 	if spanName == "" {
 		spanName = "(flush pipeline)"
 	}
-	span, _ := apm.StartSpan(ctx, spanName, "db.redis")
+	span, _ := instrumentation.StartSpan(ctx, spanName, "db.redis")
 	defer span.End()
+	setDestination(span)
 	return redis.DoWithTimeout(conn, timeout, commandName, args...)
 }
+
+// setDestination sets the span's destination service, so that Redis
+// appears as a dependency in service maps.
+func setDestination(span *apm.Span) {
+	if !span.Dropped() {
+		span.Context.SetDestination("", 0, "redis")
+	}
+}
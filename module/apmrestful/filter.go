@@ -39,6 +39,7 @@ func Filter(o ...Option) restful.FilterFunction {
 	for _, o := range o {
 		o(&opts)
 	}
+	opts.tracer.SetFramework("go-restful", "")
 	return (&filter{
 		tracer:         opts.tracer,
 		requestIgnorer: opts.requestIgnorer,
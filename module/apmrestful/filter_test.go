@@ -103,6 +103,7 @@ func TestContainerFilter(t *testing.T) {
 	assert.Equal(t, "request", transaction.Type)
 	assert.Equal(t, "HTTP 4xx", transaction.Result)
 
+	finished := true
 	assert.Equal(t, &model.Context{
 		Service: &model.Service{
 			Framework: &model.Framework{
@@ -133,6 +134,7 @@ func TestContainerFilter(t *testing.T) {
 		},
 		Response: &model.Response{
 			StatusCode: 418,
+			Finished:   &finished,
 			Headers: model.Headers{{
 				Key:    "Content-Type",
 				Values: []string{"application/json"},
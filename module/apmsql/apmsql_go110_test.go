@@ -21,8 +21,11 @@ package apmsql_test
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
 	"testing"
 
+	sqlite3 "github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -47,3 +50,47 @@ func TestConnect(t *testing.T) {
 	assert.Equal(t, "ping", spans[1].Name)
 	assert.Equal(t, "ping", spans[1].Action)
 }
+
+func TestWrapConnector(t *testing.T) {
+	driver := &sqlite3.SQLiteDriver{}
+	connector := &testConnector{driver: driver, name: ":memory:"}
+	wrapped := apmsql.WrapConnector(connector, apmsql.WithDSNParser(func(string) apmsql.DSNInfo {
+		return apmsql.DSNInfo{Database: "main"}
+	}))
+	db := sql.OpenDB(wrapped)
+
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		_, err := db.ExecContext(ctx, "CREATE TABLE foo (bar INT)")
+		assert.NoError(t, err)
+	})
+	require.Len(t, spans, 2)
+	assert.Equal(t, "connect", spans[0].Name)
+	assert.Equal(t, "connect", spans[0].Action)
+	assert.Equal(t, "CREATE", spans[1].Name)
+	assert.Equal(t, "sqlite3", spans[1].Subtype)
+	assert.Equal(t, "exec", spans[1].Action)
+	assert.Equal(t, "main", spans[1].Context.Database.Instance)
+
+	assert.Equal(t, wrapped.Driver(), db.Driver())
+	assert.NoError(t, db.Close())
+	assert.True(t, connector.closed)
+}
+
+type testConnector struct {
+	driver driver.Driver
+	name   string
+	closed bool
+}
+
+func (c *testConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.name)
+}
+
+func (c *testConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+func (c *testConnector) Close() error {
+	c.closed = true
+	return nil
+}
@@ -0,0 +1,104 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+//go:build go1.15
+// +build go1.15
+
+package apmsql_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/module/apmsql"
+)
+
+var (
+	testResetSession func(ctx context.Context) error
+	testIsValid      func() bool
+)
+
+func (d sqlite3TestConn) ResetSession(ctx context.Context) error {
+	if testResetSession != nil {
+		return testResetSession(ctx)
+	}
+	return nil
+}
+
+func (d sqlite3TestConn) IsValid() bool {
+	if testIsValid != nil {
+		return testIsValid()
+	}
+	return true
+}
+
+func TestResetSession(t *testing.T) {
+	db, err := apmsql.Open("sqlite3_test", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	var resets int
+	defer func() { testResetSession = nil }()
+	testResetSession = func(ctx context.Context) error {
+		resets++
+		return nil
+	}
+
+	require.NoError(t, db.Ping()) // connect
+	require.NoError(t, db.Ping()) // reuse connection, resetting session
+	assert.Equal(t, 1, resets)
+
+	// Returning driver.ErrBadConn from ResetSession
+	// causes the connection to be discarded.
+	testResetSession = func(ctx context.Context) error {
+		return driver.ErrBadConn
+	}
+	opens := atomic.LoadInt32(&testOpenCount)
+	require.NoError(t, db.Ping())
+	assert.Equal(t, opens+1, atomic.LoadInt32(&testOpenCount))
+}
+
+func TestValidator(t *testing.T) {
+	db, err := apmsql.Open("sqlite3_test", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	valid := true
+	defer func() { testIsValid = nil }()
+	testIsValid = func() bool { return valid }
+
+	opens := atomic.LoadInt32(&testOpenCount)
+	require.NoError(t, db.Ping()) // connect
+	require.NoError(t, db.Ping()) // reuse connection
+	assert.Equal(t, opens+1, atomic.LoadInt32(&testOpenCount))
+
+	// Invalid connections are discarded by the pool
+	// when they are released, so the following Ping
+	// must open a new connection.
+	valid = false
+	require.NoError(t, db.Ping())
+	valid = true
+	require.NoError(t, db.Ping())
+	assert.Equal(t, opens+2, atomic.LoadInt32(&testOpenCount))
+}
@@ -21,12 +21,16 @@ import (
 	"context"
 	"database/sql"
 	"database/sql/driver"
+	"errors"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	sqlite3 "github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"go.elastic.co/apm"
 	"go.elastic.co/apm/apmtest"
 	"go.elastic.co/apm/model"
 	"go.elastic.co/apm/module/apmsql"
@@ -35,6 +39,10 @@ import (
 
 func init() {
 	apmsql.Register("sqlite3_test", &sqlite3TestDriver{})
+	apmsql.Register("sqlite3_preview", &sqlite3.SQLiteDriver{},
+		apmsql.WithDriverName("sqlite3"),
+		apmsql.WithStatementPreviewLength(8),
+	)
 }
 
 func TestPingContext(t *testing.T) {
@@ -92,7 +100,15 @@ func TestQueryContext(t *testing.T) {
 	assert.Equal(t, "db", spans[0].Type)
 	assert.Equal(t, "sqlite3", spans[0].Subtype)
 	assert.Equal(t, "query", spans[0].Action)
+	assert.Equal(t, "success", spans[0].Outcome)
 	assert.Equal(t, &model.SpanContext{
+		Destination: &model.DestinationSpanContext{
+			Service: &model.DestinationServiceSpanContext{
+				Type:     "db",
+				Name:     "sqlite3",
+				Resource: "sqlite3",
+			},
+		},
 		Database: &model.DatabaseSpanContext{
 			Instance:  ":memory:",
 			Statement: "SELECT * FROM foo",
@@ -101,6 +117,64 @@ func TestQueryContext(t *testing.T) {
 	}, spans[0].Context)
 }
 
+func TestStatementPreviewLength(t *testing.T) {
+	db, err := apmsql.Open("sqlite3_preview", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.Ping() // connect
+
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	const stmt = "SELECT sqlite_version()"
+	query := func() {
+		tx := tracer.StartTransaction("name", "type")
+		ctx := apm.ContextWithTransaction(context.Background(), tx)
+		rows, err := db.QueryContext(ctx, stmt)
+		require.NoError(t, err)
+		rows.Close()
+		tx.End()
+	}
+
+	// Fast queries record only a preview of the statement.
+	tracer.SetSpanFramesMinDuration(time.Hour)
+	query()
+
+	// Queries taking at least the span frames minimum
+	// duration record the full statement.
+	tracer.SetSpanFramesMinDuration(0)
+	query()
+
+	tracer.Flush(nil)
+	spans := tracer.Payloads().Spans
+	require.Len(t, spans, 2)
+	assert.Equal(t, "SELECT s", spans[0].Context.Database.Statement)
+	assert.Equal(t, stmt, spans[1].Context.Database.Statement)
+}
+
+func TestDisabledInstrumentation(t *testing.T) {
+	db, err := apmsql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	tracer.SetDisabledInstrumentations("sql")
+
+	tx := tracer.StartTransaction("name", "type")
+	ctx := apm.ContextWithTransaction(context.Background(), tx)
+	require.NoError(t, db.PingContext(ctx))
+	rows, err := db.QueryContext(ctx, "SELECT sqlite_version()")
+	require.NoError(t, err)
+	rows.Close()
+	tx.End()
+
+	tracer.Flush(nil)
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	assert.Empty(t, payloads.Spans)
+}
+
 func TestPrepareContext(t *testing.T) {
 	db, err := apmsql.Open("sqlite3", ":memory:")
 	require.NoError(t, err)
@@ -190,11 +264,44 @@ func TestTxStmtQueryContext(t *testing.T) {
 		require.NoError(t, err)
 		rows.Close()
 	})
-	require.Len(t, spans, 1)
-	assert.Equal(t, "SELECT FROM foo", spans[0].Name)
-	assert.Equal(t, "db", spans[0].Type)
-	assert.Equal(t, "sqlite3", spans[0].Subtype)
-	assert.Equal(t, "query", spans[0].Action)
+	require.Len(t, spans, 3) // begin, query, rollback
+	assert.Equal(t, "SELECT FROM foo", spans[1].Name)
+	assert.Equal(t, "db", spans[1].Type)
+	assert.Equal(t, "sqlite3", spans[1].Subtype)
+	assert.Equal(t, "query", spans[1].Action)
+}
+
+func TestTxCommitRollback(t *testing.T) {
+	db, err := apmsql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec("CREATE TABLE foo (bar INT)")
+	require.NoError(t, err)
+
+	tx, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		tx, err := db.BeginTx(ctx, nil)
+		require.NoError(t, err)
+		_, err = tx.ExecContext(ctx, "INSERT INTO foo VALUES (1)")
+		require.NoError(t, err)
+		require.NoError(t, tx.Commit())
+
+		tx, err = db.BeginTx(ctx, nil)
+		require.NoError(t, err)
+		require.NoError(t, tx.Rollback())
+	})
+	require.Len(t, spans, 5)
+
+	var actions []string
+	for _, span := range spans {
+		actions = append(actions, span.Action)
+		assert.Equal(t, "db", span.Type)
+		assert.Equal(t, "sqlite3", span.Subtype)
+		assert.Equal(t, "success", span.Outcome)
+		assert.Equal(t, tx.ID, span.ParentID)
+	}
+	assert.Equal(t, []string{"begin", "exec", "commit", "begin", "rollback"}, actions)
+	assert.Equal(t, "commit", spans[2].Name)
 }
 
 func TestCaptureErrors(t *testing.T) {
@@ -213,7 +320,9 @@ func TestCaptureErrors(t *testing.T) {
 	assert.Equal(t, "db", spans[0].Type)
 	assert.Equal(t, "sqlite3", spans[0].Subtype)
 	assert.Equal(t, "query", spans[0].Action)
+	assert.Equal(t, "failure", spans[0].Outcome)
 	assert.Equal(t, "no such table: thin_air", errors[0].Exception.Message)
+	assert.Equal(t, spans[0].ID, errors[0].ParentID)
 }
 
 func TestBadConn(t *testing.T) {
@@ -262,11 +371,146 @@ func TestContextCanceled(t *testing.T) {
 	assert.Len(t, errors, 0) // no "context canceled" errors reported
 }
 
+func TestContextCanceledSlowQuery(t *testing.T) {
+	db, err := apmsql.Open("sqlite3_test", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+
+	queryStarted := make(chan struct{})
+	defer func() { testQueryContext = nil }()
+	testQueryContext = func(ctx context.Context, conn *sqlite3.SQLiteConn, query string, args []driver.NamedValue) (driver.Rows, error) {
+		close(queryStarted)
+		<-ctx.Done()
+		// Return a driver-specific error, rather than ctx.Err().
+		return nil, errors.New("canceling statement due to user request")
+	}
+
+	db.Ping() // connect
+	_, spans, errors := apmtest.WithTransaction(func(ctx context.Context) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			<-queryStarted
+			cancel()
+		}()
+		_, err := db.QueryContext(ctx, "SELECT * FROM foo")
+		require.Error(t, err)
+	})
+	assert.Len(t, errors, 0) // no "context canceled" errors reported
+	require.Len(t, spans, 1)
+	assert.Equal(t, "failure", spans[0].Outcome)
+	assert.Equal(t, model.IfaceMap{{Key: "context_error", Value: "context canceled"}}, spans[0].Context.Tags)
+}
+
+func TestContextDeadlineExceeded(t *testing.T) {
+	db, err := apmsql.Open("sqlite3_test", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+
+	defer func() { testQueryContext = nil }()
+	testQueryContext = func(ctx context.Context, conn *sqlite3.SQLiteConn, query string, args []driver.NamedValue) (driver.Rows, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	db.Ping() // connect
+	_, spans, errors := apmtest.WithTransaction(func(ctx context.Context) {
+		ctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+		defer cancel()
+		_, err := db.QueryContext(ctx, "SELECT * FROM foo")
+		require.Error(t, err)
+	})
+	require.Len(t, spans, 1)
+	require.Len(t, errors, 1)
+	assert.Equal(t, "failure", spans[0].Outcome)
+	assert.Equal(t, model.IfaceMap{{Key: "context_error", Value: "context deadline exceeded"}}, spans[0].Context.Tags)
+}
+
+func TestBindTransaction(t *testing.T) {
+	db, err := apmsql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.Ping() // connect
+
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	tx := tracer.StartTransaction("name", "type")
+	boundDB := apmsql.BindTransaction(db, tx)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := boundDB.Exec("CREATE TABLE foo (bar INT)")
+		assert.NoError(t, err)
+	}()
+	<-done
+
+	// Queries whose context carries a span are
+	// reported as children of that span.
+	span, ctx := apm.StartSpan(apm.ContextWithTransaction(context.Background(), tx), "parent", "custom")
+	_, err = boundDB.ExecContext(ctx, "INSERT INTO foo VALUES (1)")
+	assert.NoError(t, err)
+	span.End()
+
+	boundDB.Unbind()
+	tx.End()
+
+	// The transaction reference is released by Unbind, so
+	// queries made after the transaction has ended are not
+	// reported.
+	_, err = boundDB.Exec("DELETE FROM foo")
+	assert.NoError(t, err)
+
+	tracer.Flush(nil)
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	require.Len(t, payloads.Spans, 3)
+	assert.Equal(t, "CREATE", payloads.Spans[0].Name)
+	assert.Equal(t, payloads.Transactions[0].ID, payloads.Spans[0].ParentID)
+	assert.Equal(t, "INSERT INTO foo", payloads.Spans[1].Name)
+	assert.Equal(t, payloads.Spans[2].ID, payloads.Spans[1].ParentID)
+	assert.Equal(t, "parent", payloads.Spans[2].Name)
+}
+
+func TestBindTransactionEndedWithoutUnbind(t *testing.T) {
+	db, err := apmsql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.Ping() // connect
+
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	tx := tracer.StartTransaction("bound", "type")
+	boundDB := apmsql.BindTransaction(db, tx)
+	tx.End()
+
+	// Unbind was not called, but the bound transaction has ended,
+	// so queries are not reported as its spans.
+	_, err = boundDB.Exec("CREATE TABLE foo (bar INT)")
+	assert.NoError(t, err)
+
+	// Queries fall back to the transaction in the query's context.
+	tx2 := tracer.StartTransaction("context", "type")
+	_, err = boundDB.ExecContext(apm.ContextWithTransaction(context.Background(), tx2), "INSERT INTO foo VALUES (1)")
+	assert.NoError(t, err)
+	tx2.End()
+
+	tracer.Flush(nil)
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Transactions, 2)
+	require.Len(t, payloads.Spans, 1)
+	assert.Equal(t, "INSERT INTO foo", payloads.Spans[0].Name)
+	assert.Equal(t, payloads.Transactions[1].ID, payloads.Spans[0].TransactionID)
+}
+
 type sqlite3TestDriver struct {
 	sqlite3.SQLiteDriver
 }
 
+var testOpenCount int32
+
 func (d *sqlite3TestDriver) Open(name string) (driver.Conn, error) {
+	atomic.AddInt32(&testOpenCount, 1)
 	conn, err := d.SQLiteDriver.Open(name)
 	if err != nil {
 		return conn, err
@@ -0,0 +1,155 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmsql
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"go.elastic.co/apm"
+)
+
+// BoundDB wraps a *sql.DB opened with this package, binding a transaction
+// to it. Queries performed through a BoundDB will be reported as spans of
+// the bound transaction, unless the query's context already carries a
+// transaction or span. This is useful for code that performs queries in
+// goroutines which do not have access to the request context.
+//
+// The transaction reference is released by Unbind, which should be called
+// before the transaction is ended:
+//
+//	tx := tracer.StartTransaction("name", "type")
+//	defer tx.End()
+//	boundDB := apmsql.BindTransaction(db, tx)
+//	defer boundDB.Unbind()
+//
+// Queries performed after Unbind has been called, or after the bound
+// transaction has been ended or discarded, are not associated with any
+// transaction, other than one in the query's context.
+//
+// Statements and transactions created through a BoundDB carry the bound
+// transaction for the duration of the context passed to PrepareContext
+// or BeginTx only; the methods of sql.Stmt and sql.Tx that do not take a
+// context will not be associated with the bound transaction.
+type BoundDB struct {
+	*sql.DB
+
+	mu sync.RWMutex
+	tx *apm.Transaction
+}
+
+// BindTransaction returns a BoundDB which associates queries made through
+// db with tx. See BoundDB for more details.
+func BindTransaction(db *sql.DB, tx *apm.Transaction) *BoundDB {
+	return &BoundDB{DB: db, tx: tx}
+}
+
+// Unbind releases the bound transaction. Unbind should be called before
+// the transaction is ended, and it is safe to call Unbind multiple times.
+func (db *BoundDB) Unbind() {
+	db.mu.Lock()
+	db.tx = nil
+	db.mu.Unlock()
+}
+
+// context returns ctx, with the bound transaction added if ctx
+// does not already carry a transaction or span.
+func (db *BoundDB) context(ctx context.Context) context.Context {
+	if apm.TransactionFromContext(ctx) != nil || apm.SpanFromContext(ctx) != nil {
+		return ctx
+	}
+	db.mu.RLock()
+	tx := db.tx
+	db.mu.RUnlock()
+	if tx == nil {
+		return ctx
+	}
+	if tx.Ended() {
+		// The transaction was ended or discarded without calling
+		// Unbind; release it so that queries are not reported as
+		// spans of an ended transaction.
+		db.mu.Lock()
+		if db.tx == tx {
+			db.tx = nil
+		}
+		db.mu.Unlock()
+		return ctx
+	}
+	return apm.ContextWithTransaction(ctx, tx)
+}
+
+// ExecContext calls db.DB.ExecContext with the bound transaction.
+func (db *BoundDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.ExecContext(db.context(ctx), query, args...)
+}
+
+// Exec calls db.DB.ExecContext with the bound transaction.
+func (db *BoundDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.ExecContext(context.Background(), query, args...)
+}
+
+// QueryContext calls db.DB.QueryContext with the bound transaction.
+func (db *BoundDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.QueryContext(db.context(ctx), query, args...)
+}
+
+// Query calls db.DB.QueryContext with the bound transaction.
+func (db *BoundDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.QueryContext(context.Background(), query, args...)
+}
+
+// QueryRowContext calls db.DB.QueryRowContext with the bound transaction.
+func (db *BoundDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRowContext(db.context(ctx), query, args...)
+}
+
+// QueryRow calls db.DB.QueryRowContext with the bound transaction.
+func (db *BoundDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.QueryRowContext(context.Background(), query, args...)
+}
+
+// PrepareContext calls db.DB.PrepareContext with the bound transaction.
+func (db *BoundDB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return db.DB.PrepareContext(db.context(ctx), query)
+}
+
+// Prepare calls db.DB.PrepareContext with the bound transaction.
+func (db *BoundDB) Prepare(query string) (*sql.Stmt, error) {
+	return db.PrepareContext(context.Background(), query)
+}
+
+// BeginTx calls db.DB.BeginTx with the bound transaction.
+func (db *BoundDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return db.DB.BeginTx(db.context(ctx), opts)
+}
+
+// Begin calls db.DB.BeginTx with the bound transaction.
+func (db *BoundDB) Begin() (*sql.Tx, error) {
+	return db.BeginTx(context.Background(), nil)
+}
+
+// PingContext calls db.DB.PingContext with the bound transaction.
+func (db *BoundDB) PingContext(ctx context.Context) error {
+	return db.DB.PingContext(db.context(ctx))
+}
+
+// Ping calls db.DB.PingContext with the bound transaction.
+func (db *BoundDB) Ping() error {
+	return db.PingContext(context.Background())
+}
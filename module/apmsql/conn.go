@@ -25,6 +25,8 @@ import (
 	"go.elastic.co/apm"
 )
 
+var instrumentation = apm.RegisterInstrumentation("sql")
+
 func newConn(in driver.Conn, d *tracingDriver, dsnInfo DSNInfo) driver.Conn {
 	conn := &conn{Conn: in, driver: d}
 	conn.dsnInfo = dsnInfo
@@ -37,6 +39,7 @@ func newConn(in driver.Conn, d *tracingDriver, dsnInfo DSNInfo) driver.Conn {
 	conn.execerContext, _ = in.(driver.ExecerContext)
 	conn.connBeginTx, _ = in.(driver.ConnBeginTx)
 	conn.connGo110.init(in)
+	conn.connGo115.init(in)
 	if in, ok := in.(driver.ConnBeginTx); ok {
 		return &connBeginTx{conn, in}
 	}
@@ -46,6 +49,7 @@ func newConn(in driver.Conn, d *tracingDriver, dsnInfo DSNInfo) driver.Conn {
 type conn struct {
 	driver.Conn
 	connGo110
+	connGo115
 	driver  *tracingDriver
 	dsnInfo DSNInfo
 
@@ -64,26 +68,30 @@ func (c *conn) startStmtSpan(ctx context.Context, stmt, spanType string) (*apm.S
 }
 
 func (c *conn) startSpan(ctx context.Context, name, spanType, stmt string) (*apm.Span, context.Context) {
-	span, ctx := apm.StartSpan(ctx, name, spanType)
+	span, ctx := instrumentation.StartSpan(ctx, name, spanType)
 	if !span.Dropped() {
-		if c.dsnInfo.Address != "" {
-			span.Context.SetDestinationAddress(c.dsnInfo.Address, c.dsnInfo.Port)
-			span.Context.SetDestinationService(apm.DestinationServiceSpanContext{
-				Name:     c.driver.driverName,
-				Resource: c.driver.driverName,
-			})
-		}
+		// The destination service is identified by the driver name,
+		// even for databases without an address, such as SQLite.
+		span.Context.SetDestination(c.dsnInfo.Address, c.dsnInfo.Port, c.driver.driverName)
 		span.Context.SetDatabase(apm.DatabaseSpanContext{
 			Instance:  c.dsnInfo.Database,
 			Statement: stmt,
 			Type:      "sql",
 			User:      c.dsnInfo.User,
 		})
+		span.Context.SetDatabaseStatementPreviewLength(c.driver.statementPreviewLength)
 	}
 	return span, ctx
 }
 
-func (c *conn) finishSpan(ctx context.Context, span *apm.Span, resultError *error) {
+// finishSpan ends span, reporting *resultError as an error if it is
+// unexpected. The span's outcome is set to outcome if non-empty, and
+// is otherwise derived from *resultError: "failure" if non-nil, or
+// "success" otherwise.
+//
+// finishSpan is called deferred, so resultError must point to the
+// operation's named result error.
+func (c *conn) finishSpan(ctx context.Context, span *apm.Span, outcome string, resultError *error) {
 	if *resultError == driver.ErrSkip {
 		// TODO(axw) mark span as abandoned,
 		// so it's not sent and not counted
@@ -92,8 +100,24 @@ func (c *conn) finishSpan(ctx context.Context, span *apm.Span, resultError *erro
 		// in check.
 		return
 	}
-	switch *resultError {
-	case nil, driver.ErrBadConn, context.Canceled:
+	if outcome == "" {
+		outcome = "success"
+		if *resultError != nil {
+			outcome = "failure"
+		}
+	}
+	span.Outcome = outcome
+	// Drivers may return their own errors when the context
+	// is canceled mid-query, so we check the context too.
+	contextError := queryContextError(ctx, *resultError)
+	if contextError != nil {
+		// Mark the span so that client-side cancellations
+		// and timeouts can be distinguished from database
+		// failures.
+		span.Context.SetLabel("context_error", contextError.Error())
+	}
+	switch {
+	case *resultError == nil, *resultError == driver.ErrBadConn, contextError == context.Canceled:
 		// ErrBadConn is used by the connection pooling
 		// logic in database/sql, and so is expected and
 		// should not be reported.
@@ -108,12 +132,24 @@ func (c *conn) finishSpan(ctx context.Context, span *apm.Span, resultError *erro
 	span.End()
 }
 
+// queryContextError returns the context error responsible for a failed
+// query, if any: either context.Canceled or context.DeadlineExceeded.
+func queryContextError(ctx context.Context, err error) error {
+	switch err {
+	case nil:
+		return nil
+	case context.Canceled, context.DeadlineExceeded:
+		return err
+	}
+	return ctx.Err()
+}
+
 func (c *conn) Ping(ctx context.Context) (resultError error) {
 	if c.pinger == nil {
 		return nil
 	}
 	span, ctx := c.startSpan(ctx, "ping", c.driver.pingSpanType, "")
-	defer c.finishSpan(ctx, span, &resultError)
+	defer c.finishSpan(ctx, span, "", &resultError)
 	return c.pinger.Ping(ctx)
 }
 
@@ -122,7 +158,7 @@ func (c *conn) QueryContext(ctx context.Context, query string, args []driver.Nam
 		return nil, driver.ErrSkip
 	}
 	span, ctx := c.startStmtSpan(ctx, query, c.driver.querySpanType)
-	defer c.finishSpan(ctx, span, &resultError)
+	defer c.finishSpan(ctx, span, "", &resultError)
 
 	if c.queryerContext != nil {
 		return c.queryerContext.QueryContext(ctx, query, args)
@@ -145,7 +181,7 @@ func (*conn) Query(query string, args []driver.Value) (driver.Rows, error) {
 
 func (c *conn) PrepareContext(ctx context.Context, query string) (_ driver.Stmt, resultError error) {
 	span, ctx := c.startStmtSpan(ctx, query, c.driver.prepareSpanType)
-	defer c.finishSpan(ctx, span, &resultError)
+	defer c.finishSpan(ctx, span, "", &resultError)
 	var stmt driver.Stmt
 	var err error
 	if c.connPrepareContext != nil {
@@ -172,7 +208,7 @@ func (c *conn) ExecContext(ctx context.Context, query string, args []driver.Name
 		return nil, driver.ErrSkip
 	}
 	span, ctx := c.startStmtSpan(ctx, query, c.driver.execSpanType)
-	defer c.finishSpan(ctx, span, &resultError)
+	defer c.finishSpan(ctx, span, "", &resultError)
 
 	if c.execerContext != nil {
 		return c.execerContext.ExecContext(ctx, query, args)
@@ -202,7 +238,12 @@ type connBeginTx struct {
 	connBeginTx driver.ConnBeginTx
 }
 
-func (c *connBeginTx) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
-	// TODO(axw) instrument commit/rollback?
-	return c.connBeginTx.BeginTx(ctx, opts)
+func (c *connBeginTx) BeginTx(ctx context.Context, opts driver.TxOptions) (_ driver.Tx, resultError error) {
+	span, spanCtx := c.startSpan(ctx, "begin", c.driver.beginSpanType, "")
+	defer c.finishSpan(spanCtx, span, "", &resultError)
+	tx, err := c.connBeginTx.BeginTx(spanCtx, opts)
+	if tx != nil {
+		tx = newTx(ctx, tx, c.conn)
+	}
+	return tx, err
 }
@@ -83,6 +83,9 @@ func newTracingDriver(driver driver.Driver, opts ...WrapOption) *tracingDriver {
 	d.prepareSpanType = d.formatSpanType("prepare")
 	d.querySpanType = d.formatSpanType("query")
 	d.execSpanType = d.formatSpanType("exec")
+	d.beginSpanType = d.formatSpanType("begin")
+	d.commitSpanType = d.formatSpanType("commit")
+	d.rollbackSpanType = d.formatSpanType("rollback")
 	return d
 }
 
@@ -123,16 +126,34 @@ func WithDSNParser(f DSNParserFunc) WrapOption {
 	}
 }
 
+// WithStatementPreviewLength returns a WrapOption which limits the
+// statement recorded for fast queries to a preview of at most n
+// characters. The full statement is recorded for queries taking at
+// least the tracer's span frames minimum duration; see
+// apm.SpanContext.SetDatabaseStatementPreviewLength.
+//
+// If WithStatementPreviewLength is not supplied to Wrap, or n is zero
+// or negative, the full statement is always recorded.
+func WithStatementPreviewLength(n int) WrapOption {
+	return func(d *tracingDriver) {
+		d.statementPreviewLength = n
+	}
+}
+
 type tracingDriver struct {
 	driver.Driver
-	driverName string
-	dsnParser  DSNParserFunc
-
-	connectSpanType string
-	execSpanType    string
-	pingSpanType    string
-	prepareSpanType string
-	querySpanType   string
+	driverName             string
+	dsnParser              DSNParserFunc
+	statementPreviewLength int
+
+	connectSpanType  string
+	execSpanType     string
+	pingSpanType     string
+	prepareSpanType  string
+	querySpanType    string
+	beginSpanType    string
+	commitSpanType   string
+	rollbackSpanType string
 }
 
 func (d *tracingDriver) formatSpanType(suffix string) string {
@@ -22,6 +22,7 @@ package apmsql
 import (
 	"context"
 	"database/sql/driver"
+	"io"
 
 	"go.elastic.co/apm"
 )
@@ -32,22 +33,43 @@ func (d *tracingDriver) OpenConnector(name string) (driver.Connector, error) {
 		if err != nil {
 			return nil, err
 		}
-		return &driverConnector{oc.Connect, d, name}, nil
+		return &driverConnector{connect: oc.Connect, driver: d, name: name}, nil
 	}
 	connect := func(context.Context) (driver.Conn, error) {
 		return d.Driver.Open(name)
 	}
-	return &driverConnector{connect, d, name}, nil
+	return &driverConnector{connect: connect, driver: d, name: name}, nil
+}
+
+// WrapConnector wraps a database/sql/driver.Connector such that
+// the connections it creates are traced, for use with sql.OpenDB.
+// Connect will be reported as a span, if the context supplied to
+// it contains a transaction.
+//
+// The connector's driver will be wrapped as with Wrap, and returned
+// by the wrapped connector's Driver method.
+//
+// Connectors do not expose a data source name, so the DSN parser
+// will be called with an empty string. Use WithDSNParser to supply
+// a function returning the DSNInfo for the connector.
+func WrapConnector(connector driver.Connector, opts ...WrapOption) driver.Connector {
+	closer, _ := connector.(io.Closer)
+	return &driverConnector{
+		connect: connector.Connect,
+		driver:  newTracingDriver(connector.Driver(), opts...),
+		closer:  closer,
+	}
 }
 
 type driverConnector struct {
 	connect func(context.Context) (driver.Conn, error)
 	driver  *tracingDriver
 	name    string
+	closer  io.Closer
 }
 
 func (d *driverConnector) Connect(ctx context.Context) (driver.Conn, error) {
-	span, ctx := apm.StartSpan(ctx, "connect", d.driver.connectSpanType)
+	span, ctx := instrumentation.StartSpan(ctx, "connect", d.driver.connectSpanType)
 	defer span.End()
 	dsnInfo := d.driver.dsnParser(d.name)
 	if !span.Dropped() {
@@ -67,3 +89,12 @@ func (d *driverConnector) Connect(ctx context.Context) (driver.Conn, error) {
 func (d *driverConnector) Driver() driver.Driver {
 	return d.driver
 }
+
+// Close closes the underlying connector, if it implements io.Closer.
+// sql.DB.Close calls this method in Go 1.17 and later.
+func (d *driverConnector) Close() error {
+	if d.closer != nil {
+		return d.closer.Close()
+	}
+	return nil
+}
@@ -32,6 +32,10 @@ import (
 // an application. For SELECT, INSERT, and UPDATE, and DELETE,
 // we attempt to extract the first table name. If we are unable
 // to identify the table name, we simply omit it.
+//
+// Literal values, placeholders, and IN-lists are never included
+// in the signature, so queries that differ only in their values
+// have the same signature, keeping span name cardinality low.
 func QuerySignature(query string) string {
 	s := sqlscanner.NewScanner(query)
 	for s.Scan() {
@@ -58,6 +58,36 @@ func TestQuerySignature(t *testing.T) {
 	}
 }
 
+func TestQuerySignatureLiterals(t *testing.T) {
+	for expected, queries := range map[string][]string{
+		"SELECT FROM t": {
+			"SELECT * FROM t WHERE id IN (1,2,3)",
+			"SELECT * FROM t WHERE id IN (4, 5)",
+			"SELECT * FROM t WHERE id IN (?, ?, ?)",
+			"SELECT * FROM t WHERE id IN ($1, $2)",
+			"SELECT * FROM t WHERE name = 'foo' AND age > 42",
+			"SELECT * FROM t WHERE name = :name AND age > :age",
+			"SELECT 'from x', 1.5e3 FROM t",
+		},
+		"INSERT INTO t": {
+			"INSERT INTO t (a, b) VALUES (1, 'one'), (2, 'two')",
+			"INSERT INTO t (a, b) VALUES (?, ?)",
+		},
+		"UPDATE t": {
+			"UPDATE t SET a = 1 WHERE b = 'two'",
+			"UPDATE t SET a = $1 WHERE b = $2",
+		},
+		"DELETE FROM t": {
+			"DELETE FROM t WHERE id IN (1, 2, 3)",
+			"DELETE FROM t WHERE id = ?",
+		},
+	} {
+		for _, query := range queries {
+			assert.Equal(t, expected, apmsql.QuerySignature(query), query)
+		}
+	}
+}
+
 func BenchmarkQuerySignature(b *testing.B) {
 	sql := "SELECT *,(SELECT COUNT(*) FROM table2 WHERE table2.field1 = table1.id) AS count FROM table1 WHERE table1.field1 = 'value'"
 	for i := 0; i < b.N; i++ {
@@ -0,0 +1,49 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmsql
+
+import (
+	"context"
+	"database/sql/driver"
+)
+
+// newTx returns a driver.Tx wrapping in, reporting spans for Commit and
+// Rollback. The spans are children of the transaction or span in ctx,
+// the context passed to BeginTx, as driver.Tx methods do not take a
+// context.
+func newTx(ctx context.Context, in driver.Tx, conn *conn) driver.Tx {
+	return &tx{Tx: in, conn: conn, ctx: ctx}
+}
+
+type tx struct {
+	driver.Tx
+	conn *conn
+	ctx  context.Context
+}
+
+func (t *tx) Commit() (resultError error) {
+	span, ctx := t.conn.startSpan(t.ctx, "commit", t.conn.driver.commitSpanType, "")
+	defer t.conn.finishSpan(ctx, span, "", &resultError)
+	return t.Tx.Commit()
+}
+
+func (t *tx) Rollback() (resultError error) {
+	span, ctx := t.conn.startSpan(t.ctx, "rollback", t.conn.driver.rollbackSpanType, "")
+	defer t.conn.finishSpan(ctx, span, "", &resultError)
+	return t.Tx.Rollback()
+}
@@ -0,0 +1,71 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// pool wraps a sync.Pool, counting the operations on it
+// for diagnostic purposes. See Tracer.PoolStats.
+type pool struct {
+	// gets, puts, and news must be accessed atomically, and are
+	// first in the struct to ensure 64-bit alignment. Each counter
+	// occupies its own cache line, so that updating one does not
+	// contend with updates to the others.
+	gets paddedCounter
+	puts paddedCounter
+	news paddedCounter
+
+	pool sync.Pool
+}
+
+// cacheLineSize is the assumed size of a CPU cache line.
+const cacheLineSize = 64
+
+// paddedCounter is a counter padded to fill a cache line.
+type paddedCounter struct {
+	n uint64
+	_ [cacheLineSize - 8]byte
+}
+
+// Get returns an object from the pool, or nil if the pool is empty,
+// in which case the caller is expected to allocate a new object.
+func (p *pool) Get() interface{} {
+	atomic.AddUint64(&p.gets.n, 1)
+	v := p.pool.Get()
+	if v == nil {
+		atomic.AddUint64(&p.news.n, 1)
+	}
+	return v
+}
+
+// Put adds v to the pool.
+func (p *pool) Put(v interface{}) {
+	atomic.AddUint64(&p.puts.n, 1)
+	p.pool.Put(v)
+}
+
+func (p *pool) stats() PoolStats {
+	return PoolStats{
+		Gets: atomic.LoadUint64(&p.gets.n),
+		Puts: atomic.LoadUint64(&p.puts.n),
+		News: atomic.LoadUint64(&p.news.n),
+	}
+}
@@ -18,7 +18,6 @@
 package apm
 
 import (
-	"encoding/binary"
 	"math"
 	"math/big"
 
@@ -26,6 +25,12 @@ import (
 )
 
 // Sampler provides a means of sampling transactions.
+//
+// Samplers are consulted only for the root transaction of a trace.
+// Transactions continuing a trace, i.e. those started with a valid
+// TransactionOptions.TraceContext, inherit the sampling decision
+// propagated in the trace context, so that traces are not broken by
+// services sampling differently.
 type Sampler interface {
 	// Sample indicates whether or not a transaction
 	// should be sampled. This method will be invoked
@@ -35,23 +40,55 @@ type Sampler interface {
 	Sample(TraceContext) bool
 }
 
+// ErrorSampler provides a means of sampling errors.
+//
+// Errors associated with sampled transactions are always sent,
+// so that they may be correlated; only errors which are not
+// associated with a sampled transaction are subject to sampling.
+type ErrorSampler interface {
+	// SampleError indicates whether or not an error with the
+	// given ID should be sent. This method will be invoked by
+	// calls to Error.Send, so it must be goroutine-safe, and
+	// should avoid synchronization as far as possible.
+	SampleError(ErrorID) bool
+}
+
 // NewRatioSampler returns a new Sampler with the given ratio
 //
 // A ratio of 1.0 samples 100% of transactions, a ratio of 0.5
 // samples ~50%, and so on. If the ratio provided does not lie
 // within the range [0,1.0], NewRatioSampler will panic.
 //
-// The returned Sampler bases its decision on the value of the
-// transaction ID, so there is no synchronization involved.
+// The returned Sampler bases its decision on a hash of the trace
+// ID, so there is no synchronization involved, and the decision
+// is the same for all transactions in a trace that are sampled
+// with the same ratio.
 func NewRatioSampler(r float64) Sampler {
 	if r < 0 || r > 1.0 {
 		panic(errors.Errorf("ratio %v out of range [0,1.0]", r))
 	}
+	return ratioSampler{ratioCeil(r)}
+}
+
+// ratioCeil returns the upper bound of trace ID hashes
+// sampled with the ratio r, which must lie within [0,1.0].
+func ratioCeil(r float64) uint64 {
 	var x big.Float
 	x.SetUint64(math.MaxUint64)
 	x.Mul(&x, big.NewFloat(r))
 	ceil, _ := x.Uint64()
-	return ratioSampler{ceil}
+	return ceil
+}
+
+// NewRatioErrorSampler returns a new ErrorSampler with the given ratio.
+//
+// A ratio of 1.0 samples 100% of errors, a ratio of 0.5 samples ~50%,
+// and so on. If the ratio provided does not lie within the range [0,1.0],
+// NewRatioErrorSampler will panic.
+//
+// The returned ErrorSampler bases its decision on a hash of the error ID.
+func NewRatioErrorSampler(r float64) ErrorSampler {
+	return NewRatioSampler(r).(ratioSampler)
 }
 
 type ratioSampler struct {
@@ -59,8 +96,90 @@ type ratioSampler struct {
 }
 
 // Sample samples the transaction according to the configured
-// ratio and pseudo-random source.
+// ratio and a hash of the trace ID.
 func (s ratioSampler) Sample(c TraceContext) bool {
-	v := binary.BigEndian.Uint64(c.Span[:])
-	return v > 0 && v-1 < s.ceil
+	if c.Trace.isZero() {
+		// Invalid trace ID.
+		return false
+	}
+	h := newFnv1a()
+	h.addBytes(c.Trace[:])
+	return uint64(h) < s.ceil || s.ceil == math.MaxUint64
+}
+
+// SampleError samples the error according to the configured
+// ratio and a hash of the error ID.
+func (s ratioSampler) SampleError(id ErrorID) bool {
+	return s.Sample(TraceContext{Trace: TraceID(id)})
+}
+
+// loadSheddingFloorFraction is the fraction of the base ratio
+// which load shedding samplers sample at full load, so that some
+// traces remain available for diagnosing the overload.
+const loadSheddingFloorFraction = 0.1
+
+// NewLoadSheddingSampler returns a new Sampler which reduces the
+// sampling ratio as load increases, protecting the application
+// from the overhead of tracing while it is overloaded.
+//
+// The load function should return the current load as a value
+// in the range [0,1.0]; values outside this range are clamped.
+// The effective sampling ratio decreases linearly from baseRatio
+// at a load of 0, to a floor of one tenth of baseRatio at a load
+// of 1.0, and recovers as the load falls. The load function is
+// called for each sampling decision, so it must be goroutine-safe
+// and cheap to call, e.g. reading a value periodically computed
+// by another goroutine.
+//
+// If baseRatio does not lie within the range [0,1.0], or load is
+// nil, NewLoadSheddingSampler will panic.
+//
+// Like the Sampler returned by NewRatioSampler, the returned
+// Sampler bases its decision on a hash of the trace ID.
+func NewLoadSheddingSampler(baseRatio float64, load func() float64) Sampler {
+	if baseRatio < 0 || baseRatio > 1.0 {
+		panic(errors.Errorf("base ratio %v out of range [0,1.0]", baseRatio))
+	}
+	if load == nil {
+		panic(errors.New("load function must not be nil"))
+	}
+	return loadSheddingSampler{
+		base:  baseRatio,
+		floor: baseRatio * loadSheddingFloorFraction,
+		load:  load,
+	}
+}
+
+type loadSheddingSampler struct {
+	base  float64
+	floor float64
+	load  func() float64
+}
+
+// Sample samples the transaction according to the ratio
+// for the current load, and a hash of the trace ID.
+func (s loadSheddingSampler) Sample(c TraceContext) bool {
+	return ratioSampler{s.ceil(s.load())}.Sample(c)
+}
+
+// ceil returns the upper bound of trace ID hashes sampled at the
+// given load. Unlike ratioCeil, ceil uses float64 arithmetic, so
+// that sampling decisions do not allocate.
+func (s loadSheddingSampler) ceil(load float64) uint64 {
+	r := s.ratio(load)
+	if r >= 1 {
+		return math.MaxUint64
+	}
+	return uint64(r * (1 << 64))
+}
+
+// ratio returns the effective sampling ratio for the given load.
+func (s loadSheddingSampler) ratio(load float64) float64 {
+	switch {
+	case !(load > 0): // also handles NaN
+		return s.base
+	case load >= 1:
+		return s.floor
+	}
+	return s.base - (s.base-s.floor)*load
 }
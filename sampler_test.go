@@ -18,14 +18,16 @@
 package apm_test
 
 import (
-	"encoding/binary"
+	"errors"
 	"math/rand"
 	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"go.elastic.co/apm"
+	"go.elastic.co/apm/apmtest"
 )
 
 func TestRatioSampler(t *testing.T) {
@@ -48,7 +50,7 @@ func TestRatioSampler(t *testing.T) {
 			rng := rand.New(rand.NewSource(int64(i)))
 			for j := 0; j < numIterations; j++ {
 				var traceContext apm.TraceContext
-				binary.LittleEndian.PutUint64(traceContext.Span[:], rng.Uint64())
+				rng.Read(traceContext.Trace[:])
 				if s.Sample(traceContext) {
 					sampled[i]++
 				}
@@ -67,22 +69,203 @@ func TestRatioSampler(t *testing.T) {
 
 func TestRatioSamplerAlways(t *testing.T) {
 	s := apm.NewRatioSampler(1.0)
-	assert.False(t, s.Sample(apm.TraceContext{})) // invalid span ID
+	assert.False(t, s.Sample(apm.TraceContext{})) // invalid trace ID
 	assert.True(t, s.Sample(apm.TraceContext{
-		Span: apm.SpanID{0, 0, 0, 0, 0, 0, 0, 1},
+		Trace: apm.TraceID{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1},
 	}))
 	assert.True(t, s.Sample(apm.TraceContext{
-		Span: apm.SpanID{255, 255, 255, 255, 255, 255, 255, 255},
+		Trace: apm.TraceID{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
 	}))
 }
 
 func TestRatioSamplerNever(t *testing.T) {
 	s := apm.NewRatioSampler(0)
-	assert.False(t, s.Sample(apm.TraceContext{})) // invalid span ID
+	assert.False(t, s.Sample(apm.TraceContext{})) // invalid trace ID
 	assert.False(t, s.Sample(apm.TraceContext{
-		Span: apm.SpanID{0, 0, 0, 0, 0, 0, 0, 1},
+		Trace: apm.TraceID{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1},
 	}))
 	assert.False(t, s.Sample(apm.TraceContext{
-		Span: apm.SpanID{255, 255, 255, 255, 255, 255, 255, 255},
+		Trace: apm.TraceID{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
 	}))
 }
+
+func TestRatioSamplerTraceID(t *testing.T) {
+	s := apm.NewRatioSampler(0.5)
+	rng := rand.New(rand.NewSource(0))
+
+	var sampled, unsampled int
+	for i := 0; i < 100; i++ {
+		var traceContext apm.TraceContext
+		rng.Read(traceContext.Trace[:])
+		decision := s.Sample(traceContext)
+		if decision {
+			sampled++
+		} else {
+			unsampled++
+		}
+
+		// The decision must be the same for all transactions
+		// in the trace, irrespective of the span ID, and for
+		// other samplers with the same ratio.
+		for j := 0; j < 10; j++ {
+			rng.Read(traceContext.Span[:])
+			assert.Equal(t, decision, s.Sample(traceContext))
+			assert.Equal(t, decision, apm.NewRatioSampler(0.5).Sample(traceContext))
+		}
+	}
+	assert.NotZero(t, sampled)
+	assert.NotZero(t, unsampled)
+}
+
+func TestSampleDecisionObserver(t *testing.T) {
+	tracer := apmtest.NewDiscardTracer()
+	defer tracer.Close()
+
+	type decision struct {
+		name    string
+		sampled bool
+	}
+	var decisions []decision
+	tracer.SetSampleDecisionObserver(func(name string, sampled bool) {
+		decisions = append(decisions, decision{name, sampled})
+	})
+
+	tracer.SetSampler(apm.NewRatioSampler(0))
+	tracer.StartTransaction("unsampled", "type").End()
+	tracer.SetSampler(nil)
+	tracer.StartTransaction("sampled", "type").End()
+
+	// The decision for non-root transactions is propagated from the parent.
+	traceContext := apm.TraceContext{
+		Trace: apm.TraceID{1},
+		Span:  apm.SpanID{1},
+	}
+	tracer.StartTransactionOptions("propagated", "type", apm.TransactionOptions{
+		TraceContext: traceContext,
+	}).End()
+
+	tracer.SetSampleDecisionObserver(nil)
+	tracer.StartTransaction("unobserved", "type").End()
+
+	assert.Equal(t, []decision{
+		{"unsampled", false},
+		{"sampled", true},
+		{"propagated", false},
+	}, decisions)
+}
+
+func TestRatioErrorSampler(t *testing.T) {
+	s := apm.NewRatioErrorSampler(0.5)
+	assert.False(t, s.SampleError(apm.ErrorID{})) // invalid error ID
+	assert.True(t, apm.NewRatioErrorSampler(1).SampleError(apm.ErrorID{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}))
+	assert.False(t, apm.NewRatioErrorSampler(0).SampleError(apm.ErrorID{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}))
+	assert.Equal(t,
+		s.SampleError(apm.ErrorID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}),
+		s.SampleError(apm.ErrorID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}),
+	)
+}
+
+func TestErrorSampler(t *testing.T) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	tracer.SetErrorSampler(apm.NewRatioErrorSampler(0))
+
+	tracer.NewError(errors.New("orphan")).Send()
+	tx := tracer.StartTransaction("name", "type")
+	e := tracer.NewError(errors.New("sampled"))
+	e.SetTransaction(tx)
+	e.Send()
+	tx.End()
+
+	tracer.SetSampler(apm.NewRatioSampler(0))
+	tx = tracer.StartTransaction("name", "type")
+	e = tracer.NewError(errors.New("unsampled"))
+	e.SetTransaction(tx)
+	e.Send()
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Errors, 1)
+	assert.Equal(t, "sampled", payloads.Errors[0].Exception.Message)
+}
+
+func TestLoadSheddingSampler(t *testing.T) {
+	var load float64
+	s := apm.NewLoadSheddingSampler(0.8, func() float64 { return load })
+
+	sampleRatio := func() float64 {
+		rng := rand.New(rand.NewSource(0))
+		var sampled int
+		const n = 10000
+		for i := 0; i < n; i++ {
+			var traceContext apm.TraceContext
+			rng.Read(traceContext.Trace[:])
+			if s.Sample(traceContext) {
+				sampled++
+			}
+		}
+		return float64(sampled) / n
+	}
+
+	for _, test := range []struct {
+		load  float64
+		ratio float64
+	}{
+		{-1, 0.8},
+		{0, 0.8},
+		{0.5, 0.44},
+		{1, 0.08},
+		{2, 0.08},
+		{0, 0.8}, // recovers as load falls
+	} {
+		load = test.load
+		assert.InDelta(t, test.ratio, sampleRatio(), 0.02, "load=%v", test.load)
+	}
+}
+
+func TestLoadSheddingSamplerInvalid(t *testing.T) {
+	load := func() float64 { return 0 }
+	assert.Panics(t, func() { apm.NewLoadSheddingSampler(1.5, load) })
+	assert.Panics(t, func() { apm.NewLoadSheddingSampler(-0.1, load) })
+	assert.Panics(t, func() { apm.NewLoadSheddingSampler(0.5, nil) })
+}
+
+func TestLoadSheddingSamplerAllocs(t *testing.T) {
+	s := apm.NewLoadSheddingSampler(0.5, func() float64 { return 0.5 })
+	traceContext := apm.TraceContext{Trace: apm.TraceID{1}}
+	allocs := testing.AllocsPerRun(100, func() {
+		s.Sample(traceContext)
+	})
+	assert.Zero(t, allocs)
+}
+
+func TestRatioSamplerRootOnly(t *testing.T) {
+	tracer := apmtest.NewDiscardTracer()
+	defer tracer.Close()
+
+	traceContext := func(sampled bool) apm.TraceContext {
+		return apm.TraceContext{
+			Trace:   apm.TraceID{0x0a, 0xf7, 0x65, 0x19, 0x16, 0xcd, 0x43, 0xdd, 0x84, 0x48, 0xeb, 0x21, 0x1c, 0x80, 0x31, 0x9c},
+			Span:    apm.SpanID{0xb7, 0xad, 0x6b, 0x71, 0x69, 0x20, 0x33, 0x31},
+			Options: apm.TraceOptions(0).WithRecorded(sampled),
+		}
+	}
+	for _, ratio := range []float64{0, 1} {
+		tracer.SetSampler(apm.NewRatioSampler(ratio))
+
+		// The local ratio applies only to root transactions.
+		tx := tracer.StartTransaction("root", "type")
+		assert.Equal(t, ratio == 1, tx.Sampled())
+		tx.Discard()
+
+		// Transactions continuing a trace inherit its sampling decision.
+		for _, sampled := range []bool{false, true} {
+			tx := tracer.StartTransactionOptions("continued", "type", apm.TransactionOptions{
+				TraceContext: traceContext(sampled),
+			})
+			assert.Equal(t, sampled, tx.Sampled())
+			tx.Discard()
+		}
+	}
+}
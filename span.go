@@ -27,11 +27,12 @@ import (
 	"go.elastic.co/apm/stacktrace"
 )
 
-// droppedSpanDataPool holds *SpanData which are used when the span is created
-// for a nil or non-sampled trace context, without a transaction reference.
+// droppedSpanDataPool holds *SpanData which are used for dropped spans: those
+// created for a nil or non-sampled trace context, and those started after a
+// transaction's max spans limit has been reached.
 //
-// Spans started with a non-nil transaction, even if it is non-sampled, are
-// always created with the transaction's tracer span pool.
+// Dropped span data is never sent, so it is kept separate from the tracer
+// span pools, whose span data may hold large context and stacktrace buffers.
 var droppedSpanDataPool sync.Pool
 
 // StartSpan starts and returns a new Span within the transaction,
@@ -64,8 +65,31 @@ func (tx *Transaction) StartSpan(name, spanType string, parent *Span) *Span {
 // span type, subtype, and action; a single dot separates span type and
 // subtype, and the action will not be set.
 func (tx *Transaction) StartSpanOptions(name, spanType string, opts SpanOptions) *Span {
-	if tx == nil {
-		return newDroppedSpan()
+	span := &Span{}
+	tx.startSpan(span, name, spanType, opts)
+	return span
+}
+
+// StartSpanInto starts a new span within the transaction in span, with
+// the specified name, type, and optional parent span, and with the start
+// time set to the current time. StartSpanInto is otherwise equivalent to
+// StartSpan, but is intended for hot code paths in which the allocation
+// of a new Span for each operation is undesirable.
+//
+// span must be either a zero Span, or a Span which has been ended and
+// which is no longer referenced elsewhere, such as by a context.Context
+// or by the spans started with it as their parent. A span's End method
+// must be called before it is reused. Starting and ending a span with
+// StartSpanInto does not allocate, unless the tracer needs to grow its
+// pool of span data.
+func (tx *Transaction) StartSpanInto(span *Span, name, spanType string, parent *Span) {
+	tx.startSpan(span, name, spanType, SpanOptions{parent: parent})
+}
+
+func (tx *Transaction) startSpan(span *Span, name, spanType string, opts SpanOptions) {
+	if tx == nil || tx.dropSpans {
+		span.initDropped()
+		return
 	}
 
 	if opts.Parent == (TraceContext{}) {
@@ -81,7 +105,8 @@ func (tx *Transaction) StartSpanOptions(name, spanType string, opts SpanOptions)
 	tx.mu.RLock()
 	defer tx.mu.RUnlock()
 	if tx.ended() {
-		return tx.tracer.StartSpan(name, spanType, transactionID, opts)
+		tx.tracer.startSpan(span, name, spanType, transactionID, opts)
+		return
 	}
 
 	// Calculate the span time relative to the transaction timestamp so
@@ -92,19 +117,23 @@ func (tx *Transaction) StartSpanOptions(name, spanType string, opts SpanOptions)
 	} else {
 		opts.Start = tx.timestamp.Add(opts.Start.Sub(tx.timestamp))
 	}
-	span := tx.tracer.startSpan(name, spanType, transactionID, opts)
-	span.tx = tx
-	span.parent = opts.parent
 
 	// Guard access to spansCreated, spansDropped, rand, and childrenTimer.
 	tx.TransactionData.mu.Lock()
 	defer tx.TransactionData.mu.Unlock()
-	if !span.traceContext.Options.Recorded() {
-		span.tracer = nil // span is dropped
-	} else if tx.maxSpans >= 0 && tx.spansCreated >= tx.maxSpans {
-		span.tracer = nil // span is dropped
-		tx.spansDropped++
+
+	if recorded := opts.Parent.Options.Recorded(); !recorded || tx.maxSpans >= 0 && tx.spansCreated >= tx.maxSpans {
+		// Dropped spans are never sent, so they are taken from a separate
+		// pool of lightweight span data; transactions may start very many
+		// spans beyond the limit. Dropped spans are still included in
+		// breakdown metrics, which requires their type and timing.
+		span.initDropped()
+		span.init(name, spanType, transactionID, opts)
+		if recorded {
+			tx.spansDropped++
+		}
 	} else {
+		tx.tracer.initSpan(span, name, spanType, transactionID, opts)
 		if opts.SpanID.Validate() == nil {
 			span.traceContext.Span = opts.SpanID
 		} else {
@@ -112,21 +141,29 @@ func (tx *Transaction) StartSpanOptions(name, spanType string, opts SpanOptions)
 		}
 		span.stackFramesMinDuration = tx.spanFramesMinDuration
 		span.stackTraceLimit = tx.stackTraceLimit
+		span.compressionEnabled = tx.spanCompressionEnabled
+		span.compressionMaxDuration = tx.spanCompressionMaxDuration
 		tx.spansCreated++
 	}
+	span.tx = tx
+	span.parent = opts.parent
 
-	if tx.breakdownMetricsEnabled {
-		if span.parent != nil {
+	if span.parent != nil {
+		if tx.breakdownMetricsEnabled || tx.spanCompressionEnabled {
 			span.parent.mu.Lock()
 			defer span.parent.mu.Unlock()
 			if !span.parent.ended() {
-				span.parent.childrenTimer.childStarted(span.timestamp)
+				if tx.breakdownMetricsEnabled {
+					span.parent.childrenTimer.childStarted(span.timestamp)
+				}
+				// Spans with children are never compressed, as the
+				// children would otherwise refer to a missing parent.
+				span.parent.hasChildren = true
 			}
-		} else {
-			tx.childrenTimer.childStarted(span.timestamp)
 		}
+	} else if tx.breakdownMetricsEnabled {
+		tx.childrenTimer.childStarted(span.timestamp)
 	}
-	return span
 }
 
 // StartSpan returns a new Span with the specified name, type, transaction ID,
@@ -139,31 +176,38 @@ func (tx *Transaction) StartSpanOptions(name, spanType string, opts SpanOptions)
 // way will not have the "max spans" configuration applied, nor will they be
 // considered in any transaction's span count.
 func (t *Tracer) StartSpan(name, spanType string, transactionID SpanID, opts SpanOptions) *Span {
+	span := &Span{}
+	t.startSpan(span, name, spanType, transactionID, opts)
+	return span
+}
+
+func (t *Tracer) startSpan(span *Span, name, spanType string, transactionID SpanID, opts SpanOptions) {
 	if opts.Parent.Trace.Validate() != nil || opts.Parent.Span.Validate() != nil || transactionID.Validate() != nil {
-		return newDroppedSpan()
+		span.initDropped()
+		return
 	}
 	if !opts.Parent.Options.Recorded() {
-		return newDroppedSpan()
+		span.initDropped()
+		return
 	}
 	var spanID SpanID
 	if opts.SpanID.Validate() == nil {
 		spanID = opts.SpanID
 	} else {
 		if _, err := cryptorand.Read(spanID[:]); err != nil {
-			return newDroppedSpan()
+			span.initDropped()
+			return
 		}
 	}
 	if opts.Start.IsZero() {
 		opts.Start = time.Now()
 	}
-	span := t.startSpan(name, spanType, transactionID, opts)
+	t.initSpan(span, name, spanType, transactionID, opts)
 	span.traceContext.Span = spanID
 
 	instrumentationConfig := t.instrumentationConfig()
 	span.stackFramesMinDuration = instrumentationConfig.spanFramesMinDuration
 	span.stackTraceLimit = instrumentationConfig.stackTraceLimit
-
-	return span
 }
 
 // SpanOptions holds options for Transaction.StartSpanOptions and Tracer.StartSpan.
@@ -196,37 +240,51 @@ type SpanOptions struct {
 	Start time.Time
 }
 
-func (t *Tracer) startSpan(name, spanType string, transactionID SpanID, opts SpanOptions) *Span {
+// initSpan initializes span with span data taken from the tracer's pool.
+func (t *Tracer) initSpan(span *Span, name, spanType string, transactionID SpanID, opts SpanOptions) {
 	sd, _ := t.spanDataPool.Get().(*SpanData)
 	if sd == nil {
 		sd = &SpanData{Duration: -1}
 	}
-	span := &Span{tracer: t, SpanData: sd}
-	span.Name = name
-	span.traceContext = opts.Parent
-	span.parentID = opts.Parent.Span
-	span.transactionID = transactionID
-	span.timestamp = opts.Start
-	span.Type = spanType
+	*span = Span{tracer: t, SpanData: sd}
+	span.init(name, spanType, transactionID, opts)
+}
+
+// init sets the span's name, type, trace context, and timestamp.
+func (s *Span) init(name, spanType string, transactionID SpanID, opts SpanOptions) {
+	s.Name = name
+	s.traceContext = opts.Parent
+	s.parentID = opts.Parent.Span
+	s.transactionID = transactionID
+	s.timestamp = opts.Start
+	s.Type = spanType
 	if dot := strings.IndexRune(spanType, '.'); dot != -1 {
-		span.Type = spanType[:dot]
-		span.Subtype = spanType[dot+1:]
-		if dot := strings.IndexRune(span.Subtype, '.'); dot != -1 {
-			span.Subtype, span.Action = span.Subtype[:dot], span.Subtype[dot+1:]
+		s.Type = spanType[:dot]
+		s.Subtype = spanType[dot+1:]
+		if dot := strings.IndexRune(s.Subtype, '.'); dot != -1 {
+			s.Subtype, s.Action = s.Subtype[:dot], s.Subtype[dot+1:]
 		}
 	}
-	return span
 }
 
-// newDropped returns a new Span with a non-nil SpanData.
+// newDroppedSpan returns a new dropped Span with a non-nil SpanData,
+// taken from droppedSpanDataPool.
 func newDroppedSpan() *Span {
-	span, _ := droppedSpanDataPool.Get().(*Span)
-	if span == nil {
-		span = &Span{SpanData: &SpanData{}}
-	}
+	span := &Span{}
+	span.initDropped()
 	return span
 }
 
+// initDropped initializes s as a dropped span, with a non-nil SpanData
+// taken from droppedSpanDataPool.
+func (s *Span) initDropped() {
+	sd, _ := droppedSpanDataPool.Get().(*SpanData)
+	if sd == nil {
+		sd = &SpanData{Duration: -1}
+	}
+	*s = Span{SpanData: sd}
+}
+
 // Span describes an operation within a transaction.
 type Span struct {
 	tracer        *Tracer // nil if span is dropped
@@ -293,23 +351,32 @@ func (s *Span) End() {
 	if s.Duration < 0 {
 		s.Duration = time.Since(s.timestamp)
 	}
+	// Any buffered child span must be enqueued before s is.
+	s.compressedSpan.flush()
 	if s.dropped() {
-		if s.tx == nil {
-			droppedSpanDataPool.Put(s.SpanData)
-		} else {
+		if s.tx != nil {
 			s.reportSelfTime()
-			s.reset(s.tx.tracer)
 		}
+		*s.SpanData = SpanData{Duration: -1}
+		droppedSpanDataPool.Put(s.SpanData)
 		s.SpanData = nil
 		return
 	}
 	if len(s.stacktrace) == 0 && s.Duration >= s.stackFramesMinDuration {
 		s.setStacktrace(1)
 	}
+	// The span data is encoded after End returns, by which time
+	// s may have been reused; record the identifiers alongside.
+	s.SpanData.traceContext = s.traceContext
+	s.SpanData.transactionID = s.transactionID
 	if s.tx != nil {
 		s.reportSelfTime()
+		if s.compress() {
+			s.SpanData = nil
+			return
+		}
 	}
-	s.enqueue()
+	s.tracer.enqueueSpan(s.SpanData)
 	s.SpanData = nil
 }
 
@@ -344,18 +411,46 @@ func (s *Span) reportSelfTime() {
 	s.tx.spanTimings.add(s.Type, s.Subtype, s.Duration-s.childrenTimer.finalDuration(endTime))
 }
 
-func (s *Span) enqueue() {
-	event := tracerEvent{eventType: spanEvent}
-	event.span.Span = s
-	event.span.SpanData = s.SpanData
+// compress attempts to buffer s in its parent span or transaction, for
+// compression with subsequently ended sibling spans. If compress returns
+// false, then s must be enqueued by the caller.
+//
+// This must only be called from Span.End, with s.mu.Lock held for writing
+// and s.Duration set.
+func (s *Span) compress() bool {
+	if !s.compressionEnabled {
+		return false
+	}
+	compressible := !s.hasChildren && s.Duration <= s.compressionMaxDuration
+	if s.parent != nil {
+		s.parent.mu.Lock()
+		defer s.parent.mu.Unlock()
+		if s.parent.ended() {
+			return false
+		}
+		return s.parent.compressedSpan.add(s, compressible)
+	}
+
+	s.tx.mu.RLock()
+	defer s.tx.mu.RUnlock()
+	if s.tx.ended() {
+		return false
+	}
+	s.tx.TransactionData.mu.Lock()
+	defer s.tx.TransactionData.mu.Unlock()
+	return s.tx.compressedSpan.add(s, compressible)
+}
+
+func (t *Tracer) enqueueSpan(sd *SpanData) {
+	event := tracerEvent{eventType: spanEvent, span: sd}
 	select {
-	case s.tracer.events <- event:
+	case t.events <- event:
 	default:
 		// Enqueuing a span should never block.
-		s.tracer.statsMu.Lock()
-		s.tracer.stats.SpansDropped++
-		s.tracer.statsMu.Unlock()
-		s.reset(s.tracer)
+		t.statsMu.Lock()
+		t.stats.SpansDropped++
+		t.statsMu.Unlock()
+		sd.reset(t)
 	}
 }
 
@@ -363,15 +458,37 @@ func (s *Span) ended() bool {
 	return s.SpanData == nil
 }
 
+// ErrorCount returns the number of errors that have been associated with
+// the span, using Error.SetSpan, up until the span is ended.
+func (s *Span) ErrorCount() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.ended() {
+		return 0
+	}
+	return s.errorCount
+}
+
 // SpanData holds the details for a span, and is embedded inside Span.
 // When a span is ended or discarded, its SpanData field will be set
 // to nil.
 type SpanData struct {
+	traceContext           TraceContext
+	transactionID          SpanID
 	parentID               SpanID
 	stackFramesMinDuration time.Duration
 	stackTraceLimit        int
 	timestamp              time.Time
 	childrenTimer          childrenTimer
+	compressionEnabled     bool
+	compressionMaxDuration time.Duration
+	hasChildren            bool
+	errorCount             int
+	composite              compositeSpan
+	compressedSpan         compressedSpan
 
 	// Name holds the span name, initialized with the value passed to StartSpan.
 	Name string
@@ -394,12 +511,29 @@ type SpanData struct {
 	// duration based on the elapsed time since the span's start time.
 	Duration time.Duration
 
+	// Outcome holds the span outcome: "success" or "failure". This will
+	// initially be empty, and can be set before ending the span.
+	//
+	// If Outcome is empty when the span is ended, and one or more errors
+	// have been associated with the span (see Error.SetSpan), then the
+	// outcome will be reported as "failure".
+	Outcome string
+
 	// Context describes the context in which span occurs.
 	Context SpanContext
 
 	stacktrace []stacktrace.Frame
 }
 
+// outcome returns the span's reported outcome: s.Outcome if set, or
+// "failure" if errors have been associated with the span.
+func (s *SpanData) outcome() string {
+	if s.Outcome == "" && s.errorCount > 0 {
+		return "failure"
+	}
+	return s.Outcome
+}
+
 func (s *SpanData) setStacktrace(skip int) {
 	s.stacktrace = stacktrace.AppendStacktrace(s.stacktrace[:0], skip+1, s.stackTraceLimit)
 }
@@ -19,6 +19,7 @@ package apm_test
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -146,3 +147,223 @@ func TestTracerStartSpanIDSpecified(t *testing.T) {
 	require.Len(t, spans, 1)
 	assert.Equal(t, model.SpanID(spanID), spans[0].ID)
 }
+
+func TestSpanCompression(t *testing.T) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	tracer.SetSpanCompression(true, 10*time.Millisecond)
+
+	tx, spans, _ := tracer.WithTransaction(func(ctx context.Context) {
+		for i := 0; i < 3; i++ {
+			span, _ := apm.StartSpan(ctx, "SELECT FROM foo", "db.mysql.query")
+			span.Duration = time.Millisecond
+			span.End()
+		}
+		// A span with a different name breaks the run.
+		span, _ := apm.StartSpan(ctx, "SELECT FROM bar", "db.mysql.query")
+		span.Duration = time.Millisecond
+		span.End()
+
+		// A span exceeding the max duration is not compressed.
+		span, _ = apm.StartSpan(ctx, "SELECT FROM bar", "db.mysql.query")
+		span.Duration = time.Second
+		span.End()
+
+		for i := 0; i < 2; i++ {
+			span, _ := apm.StartSpan(ctx, "SELECT FROM foo", "db.mysql.query")
+			span.Duration = time.Millisecond
+			span.End()
+		}
+	})
+	require.Len(t, spans, 4)
+	assert.Equal(t, 7, tx.SpanCount.Started)
+
+	assert.Equal(t, "SELECT FROM foo", spans[0].Name)
+	assert.Equal(t, &model.CompositeSpan{
+		Count:               3,
+		Sum:                 3,
+		CompressionStrategy: "exact_match",
+	}, spans[0].Composite)
+
+	assert.Equal(t, "SELECT FROM bar", spans[1].Name)
+	assert.Nil(t, spans[1].Composite)
+	assert.Equal(t, "SELECT FROM bar", spans[2].Name)
+	assert.Nil(t, spans[2].Composite)
+	assert.Equal(t, float64(1000), spans[2].Duration)
+
+	assert.Equal(t, "SELECT FROM foo", spans[3].Name)
+	assert.Equal(t, &model.CompositeSpan{
+		Count:               2,
+		Sum:                 2,
+		CompressionStrategy: "exact_match",
+	}, spans[3].Composite)
+	for _, span := range spans {
+		assert.Equal(t, tx.ID, span.ParentID)
+	}
+}
+
+func TestSpanCompressionParentSpan(t *testing.T) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	tracer.SetSpanCompression(true, 10*time.Millisecond)
+
+	_, spans, _ := tracer.WithTransaction(func(ctx context.Context) {
+		parent, ctx := apm.StartSpan(ctx, "parent", "type")
+		for i := 0; i < 2; i++ {
+			span, _ := apm.StartSpan(ctx, "child", "type")
+			span.Duration = time.Millisecond
+			span.End()
+		}
+		// The parent has children, so it must not be compressed.
+		parent.Duration = time.Millisecond
+		parent.End()
+	})
+	require.Len(t, spans, 2)
+	assert.Equal(t, "child", spans[0].Name)
+	assert.Equal(t, spans[1].ID, spans[0].ParentID)
+	require.NotNil(t, spans[0].Composite)
+	assert.Equal(t, 2, spans[0].Composite.Count)
+	assert.Equal(t, "parent", spans[1].Name)
+	assert.Nil(t, spans[1].Composite)
+}
+
+func TestSpanCompressionTransactionDiscarded(t *testing.T) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	tracer.SetSpanCompression(true, 10*time.Millisecond)
+
+	tx := tracer.StartTransaction("name", "type")
+	ctx := apm.ContextWithTransaction(context.Background(), tx)
+	for i := 0; i < 2; i++ {
+		span, _ := apm.StartSpan(ctx, "SELECT FROM foo", "db.mysql.query")
+		span.Duration = time.Millisecond
+		span.End()
+	}
+	tx.Discard()
+	tracer.Flush(nil)
+
+	// The buffered compressed span belongs to the discarded
+	// transaction, so it must not be sent.
+	payloads := tracer.Payloads()
+	assert.Empty(t, payloads.Transactions)
+	assert.Empty(t, payloads.Spans)
+}
+
+func TestSpanOutcome(t *testing.T) {
+	_, spans, errors := apmtest.WithTransaction(func(ctx context.Context) {
+		span0, _ := apm.StartSpan(ctx, "name", "type")
+		span0.End()
+
+		span1, _ := apm.StartSpan(ctx, "name", "type")
+		span1.Outcome = "success"
+		span1.End()
+
+		span2, ctx := apm.StartSpan(ctx, "name", "type")
+		apm.CaptureError(ctx, errors.New("boom")).Send()
+		assert.Equal(t, 1, span2.ErrorCount())
+		span2.End()
+
+		span3, ctx := apm.StartSpan(ctx, "name", "type")
+		apm.CaptureError(ctx, errors.New("boom")).Send()
+		span3.Outcome = "success"
+		span3.End()
+	})
+	require.Len(t, spans, 4)
+	require.Len(t, errors, 2)
+	assert.Equal(t, "", spans[0].Outcome)
+	assert.Equal(t, "success", spans[1].Outcome)
+	assert.Equal(t, "failure", spans[2].Outcome)
+	assert.Equal(t, "success", spans[3].Outcome)
+
+	// The error count is reported regardless of the outcome.
+	assert.Equal(t, 0, spans[0].ErrorCount)
+	assert.Equal(t, 0, spans[1].ErrorCount)
+	assert.Equal(t, 1, spans[2].ErrorCount)
+	assert.Equal(t, 1, spans[3].ErrorCount)
+}
+
+func TestTransactionStartSpanInto(t *testing.T) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	tracer.SetSpanCompression(true, 10*time.Millisecond)
+
+	tx := tracer.StartTransaction("name", "type")
+	var parent, child apm.Span
+	tx.StartSpanInto(&parent, "parent", "type", nil)
+	for i := 0; i < 3; i++ {
+		// The ended child is buffered for compression,
+		// and must be unaffected by reuse of the Span.
+		tx.StartSpanInto(&child, "child", "db.mysql.query", &parent)
+		child.Duration = time.Millisecond
+		child.End()
+	}
+	parent.End()
+
+	tx.StartSpanInto(&parent, "reused", "type", nil)
+	parent.End()
+	tx.End()
+
+	tracer.Flush(nil)
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	require.Len(t, payloads.Spans, 3)
+	transactionID := payloads.Transactions[0].ID
+	assert.Equal(t, 5, payloads.Transactions[0].SpanCount.Started)
+
+	assert.Equal(t, "child", payloads.Spans[0].Name)
+	require.NotNil(t, payloads.Spans[0].Composite)
+	assert.Equal(t, 3, payloads.Spans[0].Composite.Count)
+	assert.Equal(t, "parent", payloads.Spans[1].Name)
+	assert.Equal(t, payloads.Spans[1].ID, payloads.Spans[0].ParentID)
+	assert.Equal(t, "reused", payloads.Spans[2].Name)
+	assert.Equal(t, transactionID, payloads.Spans[2].ParentID)
+	assert.NotEqual(t, payloads.Spans[1].ID, payloads.Spans[2].ID)
+	for _, span := range payloads.Spans {
+		assert.Equal(t, transactionID, span.TransactionID)
+	}
+}
+
+func TestTransactionStartSpanIntoAllocs(t *testing.T) {
+	tracer := apmtest.NewDiscardTracer()
+	defer tracer.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	defer tx.End()
+
+	var span apm.Span
+	allocs := testing.AllocsPerRun(1000, func() {
+		tx.StartSpanInto(&span, "name", "type", nil)
+		span.End()
+	})
+	assert.Zero(t, allocs)
+}
+
+func BenchmarkTransactionStartSpan(b *testing.B) {
+	tracer := apmtest.NewDiscardTracer()
+	defer tracer.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	defer tx.End()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tx.StartSpan("name", "type", nil).End()
+	}
+}
+
+func BenchmarkTransactionStartSpanInto(b *testing.B) {
+	tracer := apmtest.NewDiscardTracer()
+	defer tracer.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	defer tx.End()
+
+	var span apm.Span
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tx.StartSpanInto(&span, "name", "type", nil)
+		span.End()
+	}
+}
@@ -0,0 +1,114 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm
+
+import (
+	"time"
+
+	"go.elastic.co/apm/model"
+)
+
+const compressionStrategyExactMatch = "exact_match"
+
+// compositeSpan holds details of the spans that have been compressed
+// into a span. The count is zero for spans that have not been compressed.
+type compositeSpan struct {
+	count int
+	sum   time.Duration
+}
+
+// compressedSpan holds an ended span which may be combined with
+// subsequently ended, compatible sibling spans.
+//
+// compressedSpan is held by the parent span or transaction, and is
+// protected by the parent's mutex.
+type compressedSpan struct {
+	tracer *Tracer
+	data   *SpanData
+}
+
+// add adds the ended span s to the buffer.
+//
+// If s is compressible and compatible with the buffered span, s will be
+// merged into the buffered span and reset. Otherwise the buffered span is
+// enqueued, and s will take its place if compressible. If add returns false,
+// then s has not been buffered, and must be enqueued by the caller.
+func (c *compressedSpan) add(s *Span, compressible bool) bool {
+	if compressible && c.data != nil && c.data.compressibleWith(s.SpanData) {
+		c.data.compress(s.SpanData)
+		s.SpanData.reset(s.tracer)
+		return true
+	}
+	c.flush()
+	if !compressible {
+		return false
+	}
+	c.tracer, c.data = s.tracer, s.SpanData
+	return true
+}
+
+// flush enqueues the buffered span, if any.
+func (c *compressedSpan) flush() {
+	if c.data == nil {
+		return
+	}
+	c.tracer.enqueueSpan(c.data)
+	*c = compressedSpan{}
+}
+
+// discard drops the buffered span, if any, without enqueuing it.
+func (c *compressedSpan) discard() {
+	if c.data == nil {
+		return
+	}
+	c.data.reset(c.tracer)
+	*c = compressedSpan{}
+}
+
+// compressibleWith reports whether other may be compressed into s.
+func (s *SpanData) compressibleWith(other *SpanData) bool {
+	return s.Type == other.Type && s.Subtype == other.Subtype && s.Name == other.Name &&
+		s.outcome() == other.outcome()
+}
+
+// compress merges other into s, extending s's duration to cover other.
+func (s *SpanData) compress(other *SpanData) {
+	if s.composite.count == 0 {
+		s.composite.count = 1
+		s.composite.sum = s.Duration
+	}
+	s.composite.count++
+	s.composite.sum += other.Duration
+	s.errorCount += other.errorCount
+	if end := other.timestamp.Add(other.Duration); end.After(s.timestamp.Add(s.Duration)) {
+		s.Duration = end.Sub(s.timestamp)
+	}
+}
+
+// build returns a *model.CompositeSpan for c, or nil if the span has not
+// been compressed.
+func (c *compositeSpan) build() *model.CompositeSpan {
+	if c.count == 0 {
+		return nil
+	}
+	return &model.CompositeSpan{
+		Count:               c.count,
+		Sum:                 c.sum.Seconds() * 1000,
+		CompressionStrategy: compressionStrategyExactMatch,
+	}
+}
@@ -34,6 +34,13 @@ type SpanContext struct {
 	destinationService model.DestinationServiceSpanContext
 	database           model.DatabaseSpanContext
 	http               model.HTTPSpanContext
+	httpResponse       model.HTTPSpanContextResponse
+	service            model.ServiceSpanContext
+	serviceTarget      model.ServiceTargetSpanContext
+
+	// databaseStatementPreviewLength, if positive, holds the maximum
+	// length of the database statement sent for fast spans.
+	databaseStatementPreviewLength int
 }
 
 // DatabaseSpanContext holds database span context.
@@ -52,6 +59,20 @@ type DatabaseSpanContext struct {
 	User string
 }
 
+// CacheSpanContext holds cache span context.
+type CacheSpanContext struct {
+	// Backend holds the name of the cache backend, e.g. "redis".
+	Backend string
+
+	// Hit records whether the cache lookup was a hit.
+	Hit bool
+
+	// Namespace optionally holds the namespace of the key being
+	// looked up, e.g. "users". The key itself should not be recorded,
+	// as its high cardinality would make it unsuitable for aggregation.
+	Namespace string
+}
+
 // DestinationServiceSpanContext holds destination service span span.
 type DestinationServiceSpanContext struct {
 	// Name holds a name for the destination service, which may be used
@@ -63,12 +84,23 @@ type DestinationServiceSpanContext struct {
 	Resource string
 }
 
+// ServiceTargetSpanContext identifies the service targeted by a span.
+type ServiceTargetSpanContext struct {
+	// Type holds the target service type, e.g. "http" or "mysql".
+	Type string
+
+	// Name holds a name identifying the target service, e.g. a
+	// host:port pair, or a logical service name if known.
+	Name string
+}
+
 func (c *SpanContext) build() *model.SpanContext {
 	switch {
 	case len(c.model.Tags) != 0:
 	case c.model.Database != nil:
 	case c.model.HTTP != nil:
 	case c.model.Destination != nil:
+	case c.model.Service != nil:
 	default:
 		return nil
 	}
@@ -80,6 +112,9 @@ func (c *SpanContext) reset() {
 		model: model.SpanContext{
 			Tags: c.model.Tags[:0],
 		},
+		httpResponse: model.HTTPSpanContextResponse{
+			Headers: c.httpResponse.Headers[:0],
+		},
 	}
 }
 
@@ -120,6 +155,31 @@ func (c *SpanContext) SetDatabase(db DatabaseSpanContext) {
 	c.model.Database = &c.database
 }
 
+// SetDatabaseStatementPreviewLength limits the database statement recorded
+// by SetDatabase to a preview of at most n characters, unless the span's
+// duration is at least the tracer's span frames minimum duration (see
+// Tracer.SetSpanFramesMinDuration). This bounds the size of fast, frequently
+// repeated operations, while retaining full statements for slow ones.
+//
+// If n is zero or negative, which is the default, the full statement is
+// always recorded.
+func (c *SpanContext) SetDatabaseStatementPreviewLength(n int) {
+	c.databaseStatementPreviewLength = n
+}
+
+// SetCache sets the span context for cache lookups.
+//
+// The cache details are recorded as the labels "cache_backend",
+// "cache_hit", and "cache_namespace" (if a namespace is specified),
+// giving cache spans a consistent shape for aggregation.
+func (c *SpanContext) SetCache(cache CacheSpanContext) {
+	c.SetLabel("cache_backend", cache.Backend)
+	c.SetLabel("cache_hit", cache.Hit)
+	if cache.Namespace != "" {
+		c.SetLabel("cache_namespace", cache.Namespace)
+	}
+}
+
 // SetHTTPRequest sets the details of the HTTP request in the context.
 //
 // This function relates to client requests. If the request URL contains
@@ -157,6 +217,10 @@ func (c *SpanContext) SetHTTPRequest(req *http.Request) {
 		Name:     destinationServiceURL.String(),
 		Resource: destinationServiceResource,
 	})
+	c.SetServiceTarget(ServiceTargetSpanContext{
+		Type: "http",
+		Name: destinationServiceResource,
+	})
 }
 
 // SetHTTPStatusCode records the HTTP response status code.
@@ -165,6 +229,22 @@ func (c *SpanContext) SetHTTPStatusCode(statusCode int) {
 	c.model.HTTP = &c.http
 }
 
+// SetHTTPResponseHeaders records the HTTP response headers in the context.
+//
+// All headers in h are recorded, so the caller is responsible for excluding
+// any headers that may contain sensitive information.
+func (c *SpanContext) SetHTTPResponseHeaders(h http.Header) {
+	for k, values := range h {
+		c.httpResponse.Headers = append(c.httpResponse.Headers, model.Header{
+			Key: k, Values: values,
+		})
+	}
+	if len(c.httpResponse.Headers) != 0 {
+		c.http.Response = &c.httpResponse
+		c.model.HTTP = &c.http
+	}
+}
+
 // SetDestinationAddress sets the destination address and port in the context.
 //
 // SetDestinationAddress has no effect when called when an empty addr.
@@ -176,6 +256,37 @@ func (c *SpanContext) SetDestinationAddress(addr string, port int) {
 	}
 }
 
+// SetDestination sets the destination address, port, and service resource
+// in the context. The service resource identifies the downstream service in
+// service maps, e.g. "mysql" or "elasticsearch", and is also used as the
+// destination service name.
+//
+// SetDestination calls SetDestinationAddress with address and port, and, if
+// serviceResource is non-empty, SetDestinationService.
+func (c *SpanContext) SetDestination(address string, port int, serviceResource string) {
+	c.SetDestinationAddress(address, port)
+	if serviceResource != "" {
+		c.SetDestinationService(DestinationServiceSpanContext{
+			Name:     serviceResource,
+			Resource: serviceResource,
+		})
+	}
+}
+
+// SetServiceTarget sets the target service info in the context, which
+// identifies the downstream service in service maps, even when that
+// service is not itself instrumented.
+//
+// SetHTTPRequest sets the target service from the request's host and
+// port; integrations that know the logical name of the target service
+// may call SetServiceTarget to override it.
+func (c *SpanContext) SetServiceTarget(target ServiceTargetSpanContext) {
+	c.serviceTarget.Type = truncateString(target.Type)
+	c.serviceTarget.Name = truncateString(target.Name)
+	c.service.Target = &c.serviceTarget
+	c.model.Service = &c.service
+}
+
 // SetDestinationService sets the destination service info in the context.
 func (c *SpanContext) SetDestinationService(service DestinationServiceSpanContext) {
 	c.destinationService.Name = truncateString(service.Name)
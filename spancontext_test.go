@@ -50,6 +50,28 @@ func TestSpanContextSetLabel(t *testing.T) {
 	}, spans[0].Context.Tags)
 }
 
+func TestSpanContextSetCache(t *testing.T) {
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		span, _ := apm.StartSpan(ctx, "name", "cache")
+		span.Context.SetCache(apm.CacheSpanContext{Backend: "redis", Hit: true, Namespace: "users"})
+		span.End()
+
+		span, _ = apm.StartSpan(ctx, "name", "cache")
+		span.Context.SetCache(apm.CacheSpanContext{Backend: "memory"})
+		span.End()
+	})
+	require.Len(t, spans, 2)
+	assert.Equal(t, model.IfaceMap{
+		{Key: "cache_backend", Value: "redis"},
+		{Key: "cache_hit", Value: true},
+		{Key: "cache_namespace", Value: "users"},
+	}, spans[0].Context.Tags)
+	assert.Equal(t, model.IfaceMap{
+		{Key: "cache_backend", Value: "memory"},
+		{Key: "cache_hit", Value: false},
+	}, spans[1].Context.Tags)
+}
+
 func TestSpanContextSetHTTPRequest(t *testing.T) {
 	type testcase struct {
 		url string
@@ -119,6 +141,59 @@ func TestSpanContextSetHTTPRequest(t *testing.T) {
 					Resource: tc.resource,
 				},
 			}, spans[0].Context.Destination)
+			assert.Equal(t, &model.ServiceSpanContext{
+				Target: &model.ServiceTargetSpanContext{
+					Type: "http",
+					Name: tc.resource,
+				},
+			}, spans[0].Context.Service)
 		})
 	}
 }
+
+func TestSpanContextSetServiceTarget(t *testing.T) {
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		span, _ := apm.StartSpan(ctx, "name", "external.http")
+		span.Context.SetHTTPRequest(&http.Request{URL: &url.URL{Scheme: "https", Host: "api.example.com"}})
+		span.Context.SetServiceTarget(apm.ServiceTargetSpanContext{Type: "http", Name: "payments"})
+		span.End()
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, &model.ServiceSpanContext{
+		Target: &model.ServiceTargetSpanContext{Type: "http", Name: "payments"},
+	}, spans[0].Context.Service)
+}
+
+func TestSpanContextSetDestination(t *testing.T) {
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		span, _ := apm.StartSpan(ctx, "name", "db")
+		span.Context.SetDestination("db.invalid", 5432, "postgresql")
+		span.End()
+
+		span, _ = apm.StartSpan(ctx, "name", "db")
+		span.Context.SetDestination("", 0, "sqlite3")
+		span.End()
+
+		span, _ = apm.StartSpan(ctx, "name", "db")
+		span.Context.SetDestination("", 0, "")
+		span.End()
+	})
+	require.Len(t, spans, 3)
+	assert.Equal(t, &model.DestinationSpanContext{
+		Address: "db.invalid",
+		Port:    5432,
+		Service: &model.DestinationServiceSpanContext{
+			Type:     "db",
+			Name:     "postgresql",
+			Resource: "postgresql",
+		},
+	}, spans[0].Context.Destination)
+	assert.Equal(t, &model.DestinationSpanContext{
+		Service: &model.DestinationServiceSpanContext{
+			Type:     "db",
+			Name:     "sqlite3",
+			Resource: "sqlite3",
+		},
+	}, spans[1].Context.Destination)
+	assert.Nil(t, spans[2].Context)
+}
@@ -72,6 +72,10 @@ type TraceContext struct {
 
 	// State holds the trace state.
 	State TraceState
+
+	// Baggage holds application-defined key-value pairs propagated
+	// alongside the trace context.
+	Baggage Baggage
 }
 
 // TraceID identifies a trace forest.
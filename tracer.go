@@ -24,10 +24,14 @@ import (
 	"io"
 	"log"
 	"math/rand"
+	"os"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/pkg/errors"
+
 	"go.elastic.co/apm/apmconfig"
 	"go.elastic.co/apm/internal/apmlog"
 	"go.elastic.co/apm/internal/configutil"
@@ -45,6 +49,16 @@ const (
 	defaultPostContext    = 3
 	gracePeriodJitter     = 0.1 // +/- 10%
 	tracerEventChannelCap = 1000
+
+	// sendStreamTimeoutMargin is the amount of time, in addition to
+	// the configured request duration, that the transport is given
+	// to complete a request before its context is canceled.
+	sendStreamTimeoutMargin = 30 * time.Second
+
+	// maxRetryAfter is the maximum delay honoured from a server's
+	// Retry-After response header, so that an invalid or hostile
+	// value cannot stop the tracer from sending indefinitely.
+	maxRetryAfter = 5 * time.Minute
 )
 
 var (
@@ -70,7 +84,10 @@ type TracerOptions struct {
 	//
 	// If ServiceName is empty, the service name will be defined using the
 	// ELASTIC_APM_SERVICE_NAME environment variable, or if that is not set,
-	// the executable name.
+	// the executable name. Service names defined this way are sanitized by
+	// replacing any characters outside the allowed set (a-zA-Z0-9 _-) with
+	// underscores. A non-empty ServiceName is not sanitized, and will cause
+	// NewTracerOptions to return an error if it contains invalid characters.
 	ServiceName string
 
 	// ServiceVersion holds the service version.
@@ -115,6 +132,12 @@ type TracerOptions struct {
 	cpuProfileInterval    time.Duration
 	cpuProfileDuration    time.Duration
 	heapProfileInterval   time.Duration
+
+	spanCompressionEnabled     bool
+	spanCompressionMaxDuration time.Duration
+	transactionMaxDuration     time.Duration
+	dropUnsampledSpans         bool
+	disabledInstrumentations   wildcard.Matchers
 }
 
 // initDefaults updates opts with default values.
@@ -217,6 +240,26 @@ func (opts *TracerOptions) initDefaults(continueOnError bool) error {
 		heapProfileInterval = 0
 	}
 
+	spanCompressionEnabled, err := initialSpanCompressionEnabled()
+	if failed(err) {
+		spanCompressionEnabled = false
+	}
+
+	spanCompressionMaxDuration, err := initialSpanCompressionMaxDuration()
+	if failed(err) {
+		spanCompressionMaxDuration = defaultSpanCompressionMaxDuration
+	}
+
+	transactionMaxDuration, err := initialTransactionMaxDuration()
+	if failed(err) {
+		transactionMaxDuration = 0
+	}
+
+	dropUnsampledSpans, err := initialDropUnsampledSpans()
+	if failed(err) {
+		dropUnsampledSpans = false
+	}
+
 	if opts.ServiceName != "" {
 		err := validateServiceName(opts.ServiceName)
 		if failed(err) {
@@ -247,6 +290,11 @@ func (opts *TracerOptions) initDefaults(continueOnError bool) error {
 	opts.stackTraceLimit = stackTraceLimit
 	opts.active = active
 	opts.propagateLegacyHeader = propagateLegacyHeader
+	opts.spanCompressionEnabled = spanCompressionEnabled
+	opts.spanCompressionMaxDuration = spanCompressionMaxDuration
+	opts.transactionMaxDuration = transactionMaxDuration
+	opts.dropUnsampledSpans = dropUnsampledSpans
+	opts.disabledInstrumentations = initialDisabledInstrumentations()
 	if opts.Transport == nil {
 		opts.Transport = transport.Default
 	}
@@ -294,6 +342,10 @@ func (opts *TracerOptions) initDefaults(continueOnError bool) error {
 //
 // The exported fields be altered or replaced any time up until
 // any Tracer methods have been invoked.
+//
+// A forked child process does not inherit the tracer's background
+// goroutines; to continue using the tracer in the child process,
+// call Reinitialize immediately after forking.
 type Tracer struct {
 	Transport transport.Transport
 	Service   struct {
@@ -306,11 +358,13 @@ type Tracer struct {
 	system  *model.System
 
 	active            int32
+	loopCallback      int32
 	bufferSize        int
 	metricsBufferSize int
 	closing           chan struct{}
 	closed            chan struct{}
-	forceFlush        chan chan<- struct{}
+	started           chan struct{}
+	forceFlush        chan chan<- flushResult
 	forceSendMetrics  chan chan<- struct{}
 	configCommands    chan tracerConfigCommand
 	configWatcher     chan apmconfig.Watcher
@@ -318,16 +372,21 @@ type Tracer struct {
 	breakdownMetrics  *breakdownMetrics
 	profileSender     profileSender
 
+	// loopState holds a loopState value, recording the configuration
+	// of the tracer loop for restoring in Reinitialize.
+	loopState atomic.Value
+
 	statsMu sync.Mutex
 	stats   TracerStats
+	health  *tracerHealth
 
 	// instrumentationConfig_ must only be accessed and mutated
 	// using Tracer.instrumentationConfig() and Tracer.setInstrumentationConfig().
 	instrumentationConfigInternal *instrumentationConfig
 
-	errorDataPool       sync.Pool
-	spanDataPool        sync.Pool
-	transactionDataPool sync.Pool
+	errorDataPool       *pool
+	spanDataPool        *pool
+	transactionDataPool *pool
 }
 
 // NewTracer returns a new Tracer, using the default transport,
@@ -352,6 +411,33 @@ func NewTracerOptions(opts TracerOptions) (*Tracer, error) {
 	return newTracer(opts), nil
 }
 
+// NewNoopTracer returns a new, inactive Tracer which discards all events.
+//
+// Unlike tracers created by NewTracer or NewTracerOptions, the returned
+// tracer does not read configuration from the environment, and does not
+// start a background goroutine, so it is suitable for injecting into code
+// under test. Transactions started by the tracer are never sampled, and
+// their spans are always dropped, minimizing the cost of instrumentation.
+func NewNoopTracer() *Tracer {
+	t := &Tracer{
+		closing:          make(chan struct{}),
+		closed:           make(chan struct{}),
+		started:          make(chan struct{}),
+		breakdownMetrics: newBreakdownMetrics(),
+		instrumentationConfigInternal: &instrumentationConfig{
+			local: make(map[string]func(*instrumentationConfigValues)),
+		},
+		errorDataPool:       &pool{},
+		spanDataPool:        &pool{},
+		transactionDataPool: &pool{},
+	}
+	t.instrumentationConfigInternal.sampler = NewRatioSampler(0)
+	close(t.closing)
+	close(t.started)
+	close(t.closed)
+	return t
+}
+
 func newTracer(opts TracerOptions) *Tracer {
 	t := &Tracer{
 		Transport:         opts.Transport,
@@ -359,7 +445,8 @@ func newTracer(opts TracerOptions) *Tracer {
 		system:            &localSystem,
 		closing:           make(chan struct{}),
 		closed:            make(chan struct{}),
-		forceFlush:        make(chan chan<- struct{}),
+		started:           make(chan struct{}),
+		forceFlush:        make(chan chan<- flushResult),
 		forceSendMetrics:  make(chan chan<- struct{}),
 		configCommands:    make(chan tracerConfigCommand),
 		configWatcher:     make(chan apmconfig.Watcher),
@@ -369,9 +456,13 @@ func newTracer(opts TracerOptions) *Tracer {
 		bufferSize:        opts.bufferSize,
 		metricsBufferSize: opts.metricsBufferSize,
 		profileSender:     opts.profileSender,
+		health:            &tracerHealth{},
 		instrumentationConfigInternal: &instrumentationConfig{
 			local: make(map[string]func(*instrumentationConfigValues)),
 		},
+		errorDataPool:       &pool{},
+		spanDataPool:        &pool{},
+		transactionDataPool: &pool{},
 	}
 	t.Service.Name = opts.ServiceName
 	t.Service.Version = opts.ServiceVersion
@@ -400,9 +491,23 @@ func newTracer(opts TracerOptions) *Tracer {
 	t.setLocalInstrumentationConfig(envUseElasticTraceparentHeader, func(cfg *instrumentationConfigValues) {
 		cfg.propagateLegacyHeader = opts.propagateLegacyHeader
 	})
+	t.setLocalInstrumentationConfig(envSpanCompressionEnabled, func(cfg *instrumentationConfigValues) {
+		cfg.spanCompressionEnabled = opts.spanCompressionEnabled
+		cfg.spanCompressionMaxDuration = opts.spanCompressionMaxDuration
+	})
+	t.setLocalInstrumentationConfig(envTransactionMaxDuration, func(cfg *instrumentationConfigValues) {
+		cfg.transactionMaxDuration = opts.transactionMaxDuration
+	})
+	t.setLocalInstrumentationConfig(envDropUnsampledSpans, func(cfg *instrumentationConfigValues) {
+		cfg.dropUnsampledSpans = opts.dropUnsampledSpans
+	})
+	t.setLocalInstrumentationConfig(envDisableInstrumentations, func(cfg *instrumentationConfigValues) {
+		cfg.disabledInstrumentations = opts.disabledInstrumentations
+	})
 
 	if !opts.active {
 		t.active = 0
+		close(t.started)
 		close(t.closed)
 		return t
 	}
@@ -417,8 +522,8 @@ func newTracer(opts TracerOptions) *Tracer {
 		cfg.requestSize = opts.requestSize
 		cfg.sanitizedFieldNames = opts.sanitizedFieldNames
 		cfg.disabledMetrics = opts.disabledMetrics
-		cfg.preContext = defaultPreContext
-		cfg.postContext = defaultPostContext
+		cfg.errorContextLines = contextLines{pre: defaultPreContext, post: defaultPostContext}
+		cfg.spanContextLines = contextLines{pre: defaultPreContext, post: defaultPostContext}
 		cfg.metricsGatherers = []MetricsGatherer{newBuiltinMetricsGatherer(t)}
 		if apmlog.DefaultLogger != nil {
 			cfg.logger = apmlog.DefaultLogger
@@ -427,28 +532,67 @@ func newTracer(opts TracerOptions) *Tracer {
 	if opts.configWatcher != nil {
 		t.configWatcher <- opts.configWatcher
 	}
+	t.configCommands <- func(*tracerConfig) {
+		// All preceding commands have been processed by the loop.
+		close(t.started)
+	}
 	return t
 }
 
 // tracerConfig holds the tracer's runtime configuration, which may be modified
 // by sending a tracerConfigCommand to the tracer's configCommands channel.
 type tracerConfig struct {
-	requestSize             int
-	requestDuration         time.Duration
-	metricsInterval         time.Duration
-	logger                  WarningLogger
-	metricsGatherers        []MetricsGatherer
-	contextSetter           stacktrace.ContextSetter
-	preContext, postContext int
-	sanitizedFieldNames     wildcard.Matchers
-	disabledMetrics         wildcard.Matchers
-	cpuProfileDuration      time.Duration
-	cpuProfileInterval      time.Duration
-	heapProfileInterval     time.Duration
+	requestSize         int
+	requestDuration     time.Duration
+	metricsInterval     time.Duration
+	logger              WarningLogger
+	metricsGatherers    []MetricsGatherer
+	contextSetter       stacktrace.ContextSetter
+	contextWorkers      int
+	maxQueueAge         time.Duration
+	errorContextLines   contextLines
+	spanContextLines    contextLines
+	sanitizedFieldNames wildcard.Matchers
+	disabledMetrics     wildcard.Matchers
+	cpuProfileDuration  time.Duration
+	cpuProfileInterval  time.Duration
+	heapProfileInterval time.Duration
+	spanObserver        func(*model.Span)
+	transactionObserver func(*model.Transaction)
+	transactionFilter   func(*model.Transaction) bool
+	spanFilter          func(*model.Span) bool
+	errorFilter         func(*model.Error) bool
+	culpritFunc         func([]model.StacktraceFrame) string
+	recordingDisabled   bool
+	serviceFramework    model.Framework
+	errorFlushInterval  time.Duration
+	errorDeduplication  errorDeduplicationConfig
 }
 
 type tracerConfigCommand func(*tracerConfig)
 
+// sendStreamParams holds parameters for sending a request
+// to the APM Server.
+type sendStreamParams struct {
+	// gracePeriod holds the grace period to wait before sending,
+	// following a failed request, which will be jittered.
+	gracePeriod time.Duration
+
+	// retryAfter holds the delay requested by the server in its
+	// Retry-After response header, if any.
+	retryAfter time.Duration
+
+	// timeout holds the maximum amount of time to wait for the
+	// request to complete, after any delay.
+	timeout time.Duration
+}
+
+// contextLines holds the number of source lines to include before
+// and after the line of each stacktrace frame.
+type contextLines struct {
+	pre, post int
+}
+
 // Close closes the Tracer, preventing transactions from being
 // sent to the APM server.
 func (t *Tracer) Close() {
@@ -464,7 +608,7 @@ func (t *Tracer) Close() {
 // has queued to the APM server, the tracer is stopped, or the abort channel
 // is signaled.
 func (t *Tracer) Flush(abort <-chan struct{}) {
-	flushed := make(chan struct{}, 1)
+	flushed := make(chan flushResult, 1)
 	select {
 	case t.forceFlush <- flushed:
 		select {
@@ -476,12 +620,196 @@ func (t *Tracer) Flush(abort <-chan struct{}) {
 	}
 }
 
+// FlushContext waits for the Tracer to flush any transactions and errors it
+// currently has queued to the APM server, the tracer is stopped, or the context
+// is canceled.
+//
+// FlushContext returns the number of events sent to the APM server in the
+// final request of the flush. If the request fails, FlushContext returns the
+// error returned by the transport. If the context is canceled or the tracer
+// is closed before the flush completes, FlushContext returns an error.
+func (t *Tracer) FlushContext(ctx context.Context) (FlushResult, error) {
+	flushed := make(chan flushResult, 1)
+	select {
+	case t.forceFlush <- flushed:
+		select {
+		case <-ctx.Done():
+			return FlushResult{}, ctx.Err()
+		case result := <-flushed:
+			return result.FlushResult, result.err
+		case <-t.closed:
+		}
+	case <-ctx.Done():
+		return FlushResult{}, ctx.Err()
+	case <-t.closed:
+	}
+	return FlushResult{}, errors.New("tracer closed")
+}
+
+// FlushResult holds the number of events sent to the APM server
+// by Tracer.FlushContext.
+type FlushResult struct {
+	// Transactions holds the number of transactions sent.
+	Transactions uint64
+
+	// Spans holds the number of spans sent.
+	Spans uint64
+
+	// Errors holds the number of errors sent.
+	Errors uint64
+
+	// Metricsets holds the number of metricsets sent.
+	Metricsets uint64
+}
+
+type flushResult struct {
+	FlushResult
+	err error
+}
+
+// Closed returns a channel that is closed when the tracer has been closed,
+// or immediately if the tracer is inactive. Once the tracer is closed, all
+// transactions, spans, and errors are dropped, so applications may use this
+// to avoid building context for events that will never be sent:
+//
+//	select {
+//	case <-tracer.Closed():
+//		// tracer is closed
+//	default:
+//	}
+func (t *Tracer) Closed() <-chan struct{} {
+	return t.closed
+}
+
+// enterLoopCallback marks the tracer loop as invoking a callback,
+// such as a transaction filter or observer, until exitLoopCallback
+// is called. This must only be called by the tracer loop.
+func (t *Tracer) enterLoopCallback() {
+	atomic.StoreInt32(&t.loopCallback, 1)
+}
+
+// exitLoopCallback reverses the effect of enterLoopCallback.
+func (t *Tracer) exitLoopCallback() {
+	atomic.StoreInt32(&t.loopCallback, 0)
+}
+
+// inLoopCallback reports whether the tracer loop is invoking a callback,
+// in which case waiting for the loop, e.g. to flush, would deadlock if
+// called from the callback.
+func (t *Tracer) inLoopCallback() bool {
+	return atomic.LoadInt32(&t.loopCallback) != 0
+}
+
+// Reinitialize restarts the tracer's background processing in a child
+// process created by forking the process in which the tracer was started.
+// A forked child process does not inherit the tracer's goroutines, so
+// without calling Reinitialize, events recorded in the child process
+// would never be sent. Reinitialize also refreshes the process metadata
+// (e.g. process ID) sent to the server.
+//
+// Reinitialize must be called in the child process immediately after
+// forking, before any concurrent use of the tracer or of transactions,
+// spans, and errors created with it. Reinitialize replaces the tracer's
+// internal state without synchronization, so it is not safe to call
+// concurrently with any other method. Events that were buffered in the
+// parent process at the time of forking are not sent by the child. If the process ID has not changed since the tracer was
+// created or last reinitialized, or the tracer is inactive or closed,
+// Reinitialize has no effect.
+func (t *Tracer) Reinitialize() {
+	if os.Getpid() == t.process.Pid || atomic.LoadInt32(&t.active) == 0 {
+		return
+	}
+	select {
+	case <-t.closing:
+		return
+	default:
+	}
+	state, _ := t.loopState.Load().(loopState)
+	process := getCurrentProcess()
+	t.process = &process
+	t.events = make(chan tracerEvent, tracerEventChannelCap)
+	go t.loop()
+	t.configCommands <- func(cfg *tracerConfig) {
+		*cfg = state.cfg
+	}
+	if state.configWatcher != nil {
+		t.configWatcher <- state.configWatcher
+	}
+}
+
+// loopState records the configuration of the tracer loop.
+type loopState struct {
+	cfg           tracerConfig
+	configWatcher apmconfig.Watcher
+}
+
+// Started returns a channel that is closed once the tracer's background
+// goroutine is running and has applied the tracer's initial configuration,
+// including starting any central config watcher. For inactive tracers,
+// the channel is closed immediately.
+func (t *Tracer) Started() <-chan struct{} {
+	return t.started
+}
+
 // Active reports whether the tracer is active. If the tracer is inactive,
 // no transactions or errors will be sent to the Elastic APM server.
 func (t *Tracer) Active() bool {
 	return atomic.LoadInt32(&t.active) == 1
 }
 
+// SetFramework sets the name and version of the framework used by the
+// service, such as Gin or Echo, to be reported in the service metadata
+// sent to the APM server. This takes effect from the next request to the
+// server.
+//
+// If the name is empty, the framework is unset. If version is empty,
+// then it will be set to "unspecified".
+func (t *Tracer) SetFramework(name, version string) {
+	var framework model.Framework
+	if name != "" {
+		if version == "" {
+			// Framework version is required.
+			version = "unspecified"
+		}
+		framework = model.Framework{
+			Name:    truncateString(name),
+			Version: truncateString(version),
+		}
+	}
+	t.sendConfigCommand(func(cfg *tracerConfig) {
+		cfg.serviceFramework = framework
+	})
+}
+
+// SetErrorFlushInterval sets the interval for which errors are coalesced
+// before being transmitted to the APM server.
+//
+// By default, and if interval is zero, each error is transmitted as soon
+// as possible. If interval is greater than zero, an error is transmitted
+// after the interval has passed, along with any other errors sent during
+// the interval. This reduces the number of writes to the APM server during
+// bursts of errors, at the cost of delaying isolated errors by up to the
+// interval.
+func (t *Tracer) SetErrorFlushInterval(interval time.Duration) {
+	t.sendConfigCommand(func(cfg *tracerConfig) {
+		cfg.errorFlushInterval = interval
+	})
+}
+
+// SetRecording sets whether or not the tracer records events.
+//
+// When recording is disabled, instrumentation continues to operate as
+// normal, and transactions and spans are still assigned IDs so that trace
+// context is propagated; however, transactions, spans, errors, metrics,
+// and profiles are discarded rather than sent to the APM server. This
+// differs from an inactive tracer, for which instrumentation is skipped
+// altogether. Recording is enabled by default.
+func (t *Tracer) SetRecording(recording bool) {
+	t.sendConfigCommand(func(cfg *tracerConfig) {
+		cfg.recordingDisabled = !recording
+	})
+}
+
 // SetRequestDuration sets the maximum amount of time to keep a request open
 // to the APM server for streaming data before closing the stream and starting
 // a new request.
@@ -491,6 +819,22 @@ func (t *Tracer) SetRequestDuration(d time.Duration) {
 	})
 }
 
+// SetMaxQueueAge sets the maximum age of transactions, spans, and errors
+// waiting to be sent to the APM server. The age of transactions and spans
+// is based on their start times, so d should be greater than the longest
+// expected transaction duration.
+//
+// If d is greater than zero, events older than d are dropped from the
+// tracer's buffer rather than being sent, and counted in TracerStats as
+// expired. This keeps data timely after a long period during which the
+// APM server is unreachable. If d is zero (the default) or negative,
+// events are sent regardless of their age.
+func (t *Tracer) SetMaxQueueAge(d time.Duration) {
+	t.sendConfigCommand(func(cfg *tracerConfig) {
+		cfg.maxQueueAge = d
+	})
+}
+
 // SetMetricsInterval sets the metrics interval -- the amount of time in
 // between metrics samples being gathered.
 func (t *Tracer) SetMetricsInterval(d time.Duration) {
@@ -508,6 +852,165 @@ func (t *Tracer) SetContextSetter(setter stacktrace.ContextSetter) {
 	})
 }
 
+// SetContextWorkers sets the maximum number of goroutines used for
+// setting the source context of each stacktrace's frames. Setting
+// source context typically involves reading source files, so reading
+// them concurrently can reduce the time taken to send events with long
+// stacktraces. If n is less than 2 (the default is 1), source context
+// is set serially, in the tracer's background goroutine.
+//
+// If n is greater than 1, the stacktrace.ContextSetter configured with
+// SetContextSetter must be safe for concurrent use.
+func (t *Tracer) SetContextWorkers(n int) {
+	t.sendConfigCommand(func(cfg *tracerConfig) {
+		cfg.contextWorkers = n
+	})
+}
+
+// SetContextLines sets the number of source lines to include before
+// and after the line of each stacktrace frame, for both errors and
+// spans. Source context is only set if a stacktrace.ContextSetter has
+// been configured with SetContextSetter. If either pre or post is
+// negative, no source context will be set.
+//
+// This is equivalent to calling both SetErrorContextLines and
+// SetSpanContextLines with the same arguments.
+func (t *Tracer) SetContextLines(pre, post int) {
+	t.sendConfigCommand(func(cfg *tracerConfig) {
+		cfg.errorContextLines = contextLines{pre: pre, post: post}
+		cfg.spanContextLines = contextLines{pre: pre, post: post}
+	})
+}
+
+// SetErrorContextLines sets the number of source lines to include
+// before and after the line of each error stacktrace frame.
+func (t *Tracer) SetErrorContextLines(pre, post int) {
+	t.sendConfigCommand(func(cfg *tracerConfig) {
+		cfg.errorContextLines = contextLines{pre: pre, post: post}
+	})
+}
+
+// SetSpanContextLines sets the number of source lines to include
+// before and after the line of each span stacktrace frame.
+func (t *Tracer) SetSpanContextLines(pre, post int) {
+	t.sendConfigCommand(func(cfg *tracerConfig) {
+		cfg.spanContextLines = contextLines{pre: pre, post: post}
+	})
+}
+
+// SetSpanObserver sets a function to be called with each span's model
+// representation, prior to the span being encoded and sent to the
+// APM server. If observer is nil, any existing observer is removed.
+//
+// The observer is called synchronously by the tracer's background
+// goroutine, so it must return quickly. The observer must not modify
+// the span, nor retain a reference to it or any of its fields after
+// returning.
+func (t *Tracer) SetSpanObserver(observer func(*model.Span)) {
+	t.sendConfigCommand(func(cfg *tracerConfig) {
+		cfg.spanObserver = observer
+	})
+}
+
+// SetTransactionObserver sets a function to be called with each
+// transaction's model representation, prior to the transaction being
+// encoded and sent to the APM server. If observer is nil, any existing
+// observer is removed.
+//
+// The observer is called synchronously by the tracer's background
+// goroutine, so it must return quickly. The observer must not modify
+// the transaction, nor retain a reference to it or any of its fields
+// after returning.
+func (t *Tracer) SetTransactionObserver(observer func(*model.Transaction)) {
+	t.sendConfigCommand(func(cfg *tracerConfig) {
+		cfg.transactionObserver = observer
+	})
+}
+
+// SetTransactionFilter sets a function to be called with each transaction's
+// model representation, prior to the transaction being encoded and sent to
+// the APM server. If filter returns false, the transaction will be dropped
+// and counted in TracerStats.TransactionsFiltered. If filter is nil, any
+// existing filter is removed.
+//
+// Only the transaction itself is dropped. Spans are typically encoded as
+// they end, before their transaction has ended and been filtered, so the
+// spans of a filtered transaction will still be sent to the APM server,
+// where they will have no parent transaction. To avoid recording spans for
+// a transaction, it must instead be unsampled; see Tracer.SetSampler.
+//
+// The filter is called synchronously by the tracer's background goroutine,
+// so it must return quickly. The filter may modify the transaction, but must
+// not retain a reference to it or any of its fields after returning.
+func (t *Tracer) SetTransactionFilter(filter func(*model.Transaction) bool) {
+	t.sendConfigCommand(func(cfg *tracerConfig) {
+		cfg.transactionFilter = filter
+	})
+}
+
+// SetSpanFilter sets a function to be called with each span's model
+// representation, prior to the span being encoded and sent to the APM
+// server. If filter returns false, the span will be dropped and counted
+// in TracerStats.SpansFiltered. If filter is nil, any existing filter is
+// removed.
+//
+// The filter is called synchronously by the tracer's background goroutine,
+// so it must return quickly. The filter may modify the span, but must not
+// retain a reference to it or any of its fields after returning.
+func (t *Tracer) SetSpanFilter(filter func(*model.Span) bool) {
+	t.sendConfigCommand(func(cfg *tracerConfig) {
+		cfg.spanFilter = filter
+	})
+}
+
+// SetErrorFilter sets a function to be called with each error's model
+// representation, prior to the error being encoded and sent to the APM
+// server. If filter returns false, the error will be dropped and counted
+// in TracerStats.ErrorsFiltered. If filter is nil, any existing filter is
+// removed.
+//
+// The filter is called synchronously by the tracer's background goroutine,
+// so it must return quickly. The filter may modify the error, but must not
+// retain a reference to it or any of its fields after returning.
+func (t *Tracer) SetErrorFilter(filter func(*model.Error) bool) {
+	t.sendConfigCommand(func(cfg *tracerConfig) {
+		cfg.errorFilter = filter
+	})
+}
+
+// SetCulpritFunc sets a function to be called to derive the culprit of an
+// error from its stacktrace frames, for errors without an explicitly set
+// Culprit. By default, the culprit is the function of the first non-library
+// frame. If f is nil, the default behaviour is restored.
+//
+// The function is called synchronously by the tracer's background goroutine,
+// so it must return quickly, and must not retain a reference to the frames.
+func (t *Tracer) SetCulpritFunc(f func([]model.StacktraceFrame) string) {
+	t.sendConfigCommand(func(cfg *tracerConfig) {
+		cfg.culpritFunc = f
+	})
+}
+
+// SetErrorDeduplication enables or disables deduplication of errors.
+//
+// If window and maxDistinct are both greater than zero, then errors with
+// the same type, message, and top stack frame that are sent within window
+// of the first such error are collapsed into a single error, which will be
+// labeled with the number of occurrences as "occurrences". At most
+// maxDistinct errors will be held for deduplication at any time; further
+// distinct errors are sent immediately. Errors held for deduplication are
+// sent when the window ends, or when the tracer is flushed.
+//
+// Error deduplication is disabled by default.
+func (t *Tracer) SetErrorDeduplication(window time.Duration, maxDistinct int) {
+	t.sendConfigCommand(func(cfg *tracerConfig) {
+		cfg.errorDeduplication = errorDeduplicationConfig{
+			window:      window,
+			maxDistinct: maxDistinct,
+		}
+	})
+}
+
 // SetLogger sets the Logger to be used for logging the operation of
 // the tracer.
 //
@@ -609,6 +1112,106 @@ func (t *Tracer) SetSampler(s Sampler) {
 	})
 }
 
+// SetSampleDecisionObserver sets a function to be called with the name
+// of each transaction started, and whether or not it was sampled. This
+// includes transactions whose sampling decision was propagated from a
+// parent. If observer is nil, any existing observer is removed.
+//
+// The observer is called synchronously by StartTransaction and
+// StartTransactionOptions, so it must return quickly.
+func (t *Tracer) SetSampleDecisionObserver(observer func(transactionName string, sampled bool)) {
+	t.updateInstrumentationConfig(func(cfg *instrumentationConfig) {
+		cfg.sampleDecisionObserver = observer
+	})
+}
+
+// SetSamplingHeader sets the name of an incoming request header, such as
+// "X-B3-Sampled", which conveys an upstream sampling decision for requests
+// without trace context. This eases migration from other propagation
+// formats, such as B3, to W3C Trace-Context.
+//
+// Instrumentation modules which continue traces, such as apmhttp and
+// apmgrpc, parse the header and use its decision in place of the tracer's
+// sampler. If the header is absent or cannot be parsed, the sampler is
+// used. If name is empty (the default), no sampling header is consulted.
+func (t *Tracer) SetSamplingHeader(name string) {
+	t.updateInstrumentationConfig(func(cfg *instrumentationConfig) {
+		cfg.samplingHeader = name
+	})
+}
+
+// SamplingHeader returns the name of the header set with SetSamplingHeader,
+// or the empty string if none has been set.
+func (t *Tracer) SamplingHeader() string {
+	return t.instrumentationConfig().samplingHeader
+}
+
+// SetErrorSampler sets the sampler the tracer is to use for errors.
+//
+// Errors associated with a sampled transaction are always sent. All
+// other errors are sent only if s.SampleError returns true. If s is
+// nil (the default), all errors are sent.
+func (t *Tracer) SetErrorSampler(s ErrorSampler) {
+	t.updateInstrumentationConfig(func(cfg *instrumentationConfig) {
+		cfg.errorSampler = s
+	})
+}
+
+// SetHandledErrorStacktraces sets whether or not stacktraces are reported
+// for errors with Handled set to true. Stacktraces are reported for handled
+// errors by default.
+//
+// If enabled is false, stacktraces will be omitted from handled errors,
+// unless the stacktrace was set explicitly by calling the error's
+// SetStacktrace method. Errors created by CaptureError are known to be
+// handled when they are created, so no stacktrace is captured for them
+// at all; the stacktraces of other errors marked as handled after they
+// are created, e.g. with NewError, are omitted when the errors are sent.
+// Stacktraces are always reported for errors that are not handled, such
+// as those created for recovered panics.
+func (t *Tracer) SetHandledErrorStacktraces(enabled bool) {
+	t.updateInstrumentationConfig(func(cfg *instrumentationConfig) {
+		cfg.omitHandledErrorStacktraces = !enabled
+	})
+}
+
+// SetAllowedTransactionTypes sets the transaction types that may be
+// reported. If types is non-empty, the type passed to StartTransaction
+// will be trimmed of whitespace and lowercased, and if it is not one of
+// types (after applying any synonyms set with SetTransactionTypeSynonyms),
+// it will be replaced with "custom". If types is empty, any transaction
+// type is allowed.
+func (t *Tracer) SetAllowedTransactionTypes(types []string) {
+	var allowed map[string]struct{}
+	if len(types) != 0 {
+		allowed = make(map[string]struct{}, len(types))
+		for _, transactionType := range types {
+			allowed[strings.ToLower(transactionType)] = struct{}{}
+		}
+	}
+	t.updateInstrumentationConfig(func(cfg *instrumentationConfig) {
+		cfg.transactionTypes.allowed = allowed
+	})
+}
+
+// SetTransactionTypeSynonyms sets a mapping of transaction types to the
+// types that should be reported in their place, e.g. {"reqest": "request"}.
+// If synonyms is non-empty, the type passed to StartTransaction will be
+// trimmed of whitespace and lowercased before looking up its synonym.
+// Synonyms are matched case-insensitively.
+func (t *Tracer) SetTransactionTypeSynonyms(synonyms map[string]string) {
+	var lowerSynonyms map[string]string
+	if len(synonyms) != 0 {
+		lowerSynonyms = make(map[string]string, len(synonyms))
+		for k, v := range synonyms {
+			lowerSynonyms[strings.ToLower(k)] = strings.ToLower(v)
+		}
+	}
+	t.updateInstrumentationConfig(func(cfg *instrumentationConfig) {
+		cfg.transactionTypes.synonyms = lowerSynonyms
+	})
+}
+
 // SetMaxSpans sets the maximum number of spans that will be added
 // to a transaction before dropping spans.
 //
@@ -650,6 +1253,73 @@ func (t *Tracer) SetCaptureBody(mode CaptureBodyMode) {
 	})
 }
 
+// SetSpanCompression enables or disables span compression, and sets the
+// maximum duration of spans eligible for compression.
+//
+// When span compression is enabled, consecutive sibling spans with the same
+// type, subtype, name, and outcome, and with a duration no greater than maxDuration,
+// will be combined into a single composite span. Spans that are not eligible
+// for compression will be reported as usual, and will interrupt any sequence
+// of compressed spans.
+func (t *Tracer) SetSpanCompression(enabled bool, maxDuration time.Duration) {
+	t.setLocalInstrumentationConfig(envSpanCompressionEnabled, func(cfg *instrumentationConfigValues) {
+		cfg.spanCompressionEnabled = enabled
+		cfg.spanCompressionMaxDuration = maxDuration
+	})
+}
+
+// SetDropUnsampledSpans sets whether or not spans started within non-sampled
+// transactions are dropped immediately.
+//
+// Spans of non-sampled transactions are never sent, but by default they are
+// still timed, in order to record breakdown metrics. If dropUnsampled is true,
+// Transaction.StartSpan and related methods instead return a dropped span
+// without any further bookkeeping, and the spans of non-sampled transactions
+// are excluded from breakdown metrics. This reduces the overhead of tracing
+// when the sampling rate is low.
+func (t *Tracer) SetDropUnsampledSpans(dropUnsampled bool) {
+	t.setLocalInstrumentationConfig(envDropUnsampledSpans, func(cfg *instrumentationConfigValues) {
+		cfg.dropUnsampledSpans = dropUnsampled
+	})
+}
+
+// SetDisabledInstrumentations sets the wildcard patterns matching the
+// names of instrumentation modules which should not create spans, as
+// registered with RegisterInstrumentation; e.g. "sql" or "redis". The
+// change takes effect for spans started after SetDisabledInstrumentations
+// returns. By default, all instrumentation modules are enabled.
+func (t *Tracer) SetDisabledInstrumentations(patterns ...string) {
+	var matchers wildcard.Matchers
+	if len(patterns) != 0 {
+		matchers = make(wildcard.Matchers, len(patterns))
+		for i, p := range patterns {
+			matchers[i] = configutil.ParseWildcardPattern(p)
+		}
+	}
+	t.setLocalInstrumentationConfig(envDisableInstrumentations, func(cfg *instrumentationConfigValues) {
+		cfg.disabledInstrumentations = matchers
+	})
+}
+
+// SetTransactionMaxDuration sets the maximum duration of transactions.
+//
+// If maxDuration is greater than zero, transactions that have not been
+// ended within maxDuration of their start time will be ended automatically
+// and sent, labeled with "abandoned". This is intended as a safety net for
+// transactions that are never ended due to bugs, such as panics that are
+// not recovered. Once abandoned, updates to the transaction are ignored.
+//
+// An abandoned transaction's data is read by the tracer when it is sent,
+// so transactions must not be modified concurrently with being abandoned,
+// and maxDuration should be set well beyond the expected transaction
+// duration.
+// If maxDuration is zero (the default), transactions are never abandoned.
+func (t *Tracer) SetTransactionMaxDuration(maxDuration time.Duration) {
+	t.setLocalInstrumentationConfig(envTransactionMaxDuration, func(cfg *instrumentationConfigValues) {
+		cfg.transactionMaxDuration = maxDuration
+	})
+}
+
 // SendMetrics forces the tracer to gather and send metrics immediately,
 // blocking until the metrics have been sent or the abort channel is
 // signalled.
@@ -675,8 +1345,21 @@ func (t *Tracer) Stats() TracerStats {
 	return stats
 }
 
+// PoolStats returns statistics for the pools of transaction, span,
+// and error data maintained by the tracer. This is intended for
+// diagnosing whether pooling is effective for a given workload.
+func (t *Tracer) PoolStats() TracerPoolStats {
+	return TracerPoolStats{
+		Transactions: t.transactionDataPool.stats(),
+		Spans:        t.spanDataPool.stats(),
+		Errors:       t.errorDataPool.stats(),
+	}
+}
+
 func (t *Tracer) loop() {
-	ctx, cancelContext := context.WithCancel(context.Background())
+	// Mark the context as internal, so that instrumentation of
+	// the transport (e.g. HTTP requests) is not itself traced.
+	ctx, cancelContext := context.WithCancel(ContextWithInternalOperation(context.Background()))
 	defer cancelContext()
 	defer close(t.closed)
 	defer atomic.StoreInt32(&t.active, 0)
@@ -685,7 +1368,8 @@ func (t *Tracer) loop() {
 	var requestBuf bytes.Buffer
 	var metadata []byte
 	var gracePeriod time.Duration = -1
-	var flushed chan<- struct{}
+	var retryAfter time.Duration
+	var flushed chan<- flushResult
 	var requestBufTransactions, requestBufSpans, requestBufErrors, requestBufMetricsets uint64
 	zlibWriter, _ := zlib.NewWriterLevel(&requestBuf, zlib.BestSpeed)
 	zlibFlushed := true
@@ -704,21 +1388,46 @@ func (t *Tracer) loop() {
 
 	// Run another goroutine to perform the blocking requests,
 	// communicating with the tracer loop to obtain stream data.
-	sendStreamRequest := make(chan time.Duration)
+	//
+	// Each request is bounded by a timeout, so that a transport
+	// that never returns cannot prevent events from being sent
+	// indefinitely.
+	sendStreamRequest := make(chan sendStreamParams)
 	defer close(sendStreamRequest)
 	go func() {
 		jitterRand := rand.New(rand.NewSource(time.Now().UnixNano()))
-		for gracePeriod := range sendStreamRequest {
-			if gracePeriod > 0 {
+		for params := range sendStreamRequest {
+			d := params.retryAfter
+			if params.gracePeriod > 0 {
+				if jittered := jitterDuration(params.gracePeriod, jitterRand, gracePeriodJitter); jittered > d {
+					d = jittered
+				}
+			}
+			if d > 0 {
 				select {
-				case <-time.After(jitterDuration(gracePeriod, jitterRand, gracePeriodJitter)):
+				case <-time.After(d):
 				case <-ctx.Done():
 				}
 			}
-			requestResult <- t.Transport.SendStream(ctx, iochanReader)
+			sendCtx, cancelSend := context.WithTimeout(ctx, params.timeout)
+			requestResult <- t.Transport.SendStream(sendCtx, iochanReader)
+			cancelSend()
 		}
 	}()
 
+	var errorDeduplicator errorDeduplicator
+	errorDeduplicationTimer := time.NewTimer(0)
+	errorDeduplicationTimerActive := false
+	if !errorDeduplicationTimer.Stop() {
+		<-errorDeduplicationTimer.C
+	}
+
+	errorFlushTimer := time.NewTimer(0)
+	errorFlushTimerActive := false
+	if !errorFlushTimer.Stop() {
+		<-errorFlushTimer.C
+	}
+
 	var breakdownMetricsLimitWarningLogged bool
 	var stats TracerStats
 	var metrics Metrics
@@ -745,8 +1454,17 @@ func (t *Tracer) loop() {
 	heapProfilingState := newHeapProfilingState(t.profileSender)
 
 	var cfg tracerConfig
+	var state loopState
 	buffer := ringbuffer.New(t.bufferSize)
+	modelWriter := modelWriter{
+		tracer:        t,
+		buffer:        buffer,
+		metricsBuffer: metricsBuffer,
+		cfg:           &cfg,
+		stats:         &stats,
+	}
 	buffer.Evicted = func(h ringbuffer.BlockHeader) {
+		modelWriter.bufferTimestamps.pop()
 		switch h.Tag {
 		case errorBlockTag:
 			stats.ErrorsDropped++
@@ -756,12 +1474,6 @@ func (t *Tracer) loop() {
 			stats.TransactionsDropped++
 		}
 	}
-	modelWriter := modelWriter{
-		buffer:        buffer,
-		metricsBuffer: metricsBuffer,
-		cfg:           &cfg,
-		stats:         &stats,
-	}
 
 	for {
 		var gatherMetrics bool
@@ -772,7 +1484,14 @@ func (t *Tracer) loop() {
 			return
 		case cmd := <-t.configCommands:
 			oldMetricsInterval := cfg.metricsInterval
+			oldServiceFramework := cfg.serviceFramework
 			cmd(&cfg)
+			state.cfg = cfg
+			t.loopState.Store(state)
+			if cfg.serviceFramework != oldServiceFramework {
+				// Refresh the metadata for the next request.
+				metadata = nil
+			}
 			cpuProfilingState.updateConfig(cfg.cpuProfileInterval, cfg.cpuProfileDuration)
 			heapProfilingState.updateConfig(cfg.heapProfileInterval, 0)
 			if !gatheringMetrics && cfg.metricsInterval != oldMetricsInterval {
@@ -799,6 +1518,8 @@ func (t *Tracer) loop() {
 			}
 			continue
 		case cw := <-t.configWatcher:
+			state.configWatcher = cw
+			t.loopState.Store(state)
 			if configChanges != nil {
 				stopConfigWatcher()
 				t.updateRemoteConfig(cfg.logger, lastConfigChange, nil)
@@ -838,20 +1559,52 @@ func (t *Tracer) loop() {
 		case event := <-t.events:
 			switch event.eventType {
 			case transactionEvent:
-				if !t.breakdownMetrics.recordTransaction(event.tx.TransactionData) {
-					if !breakdownMetricsLimitWarningLogged && cfg.logger != nil {
-						cfg.logger.Warningf("%s", breakdownMetricsLimitWarning)
-						breakdownMetricsLimitWarningLogged = true
+				// The data of an abandoned transaction may still be referenced
+				// by the application, and its duration is not set, so it is not
+				// checked or recorded in breakdown metrics.
+				if !event.tx.Transaction.abandoned {
+					checkTransaction(cfg.logger, event.tx.TransactionData)
+					if !t.breakdownMetrics.recordTransaction(event.tx.TransactionData) {
+						if !breakdownMetricsLimitWarningLogged && cfg.logger != nil {
+							cfg.logger.Warningf("%s", breakdownMetricsLimitWarning)
+							breakdownMetricsLimitWarningLogged = true
+						}
 					}
 				}
 				modelWriter.writeTransaction(event.tx.Transaction, event.tx.TransactionData)
 			case spanEvent:
-				modelWriter.writeSpan(event.span.Span, event.span.SpanData)
+				checkSpan(cfg.logger, event.span)
+				modelWriter.writeSpan(event.span)
 			case errorEvent:
-				modelWriter.writeError(event.err)
-				// Flush the buffer to transmit the error immediately.
-				flushRequest = true
+				if cfg.errorDeduplication.enabled() && errorDeduplicator.add(event.err, cfg.errorDeduplication.maxDistinct) {
+					// The error will be sent when the deduplication window ends.
+					if !errorDeduplicationTimerActive {
+						errorDeduplicationTimer.Reset(cfg.errorDeduplication.window)
+						errorDeduplicationTimerActive = true
+					}
+				} else {
+					modelWriter.writeError(event.err)
+					if cfg.errorFlushInterval > 0 {
+						// Flush the buffer when the interval ends, to
+						// transmit any errors arriving in the meantime
+						// together with this one.
+						if !errorFlushTimerActive {
+							errorFlushTimer.Reset(cfg.errorFlushInterval)
+							errorFlushTimerActive = true
+						}
+					} else {
+						// Flush the buffer to transmit the error immediately.
+						flushRequest = true
+					}
+				}
 			}
+		case <-errorFlushTimer.C:
+			errorFlushTimerActive = false
+			flushRequest = true
+		case <-errorDeduplicationTimer.C:
+			errorDeduplicationTimerActive = false
+			errorDeduplicator.flush(modelWriter.writeError)
+			flushRequest = true
 		case <-requestTimer.C:
 			requestTimerActive = false
 			closeRequest = true
@@ -867,19 +1620,36 @@ func (t *Tracer) loop() {
 			}
 			gatherMetrics = !gatheringMetrics
 		case <-gatheredMetrics:
-			modelWriter.writeMetrics(&metrics)
 			gatheringMetrics = false
-			flushRequest = true
+			if cfg.recordingDisabled {
+				metrics.reset()
+				if sentMetrics != nil {
+					// Nothing will be sent, so unblock SendMetrics.
+					sentMetrics <- struct{}{}
+					sentMetrics = nil
+				}
+			} else {
+				modelWriter.writeMetrics(&metrics)
+				flushRequest = true
+			}
 			if cfg.metricsInterval > 0 {
 				metricsTimerStart = time.Now()
 				metricsTimer.Reset(cfg.metricsInterval)
 			}
 		case <-cpuProfilingState.timer.C:
-			cpuProfilingState.start(ctx, cfg.logger, t.metadataReader())
+			if cfg.recordingDisabled {
+				cpuProfilingState.resetTimer()
+				continue
+			}
+			cpuProfilingState.start(ctx, cfg.logger, t.metadataReader(cfg.serviceFramework))
 		case <-cpuProfilingState.finished:
 			cpuProfilingState.resetTimer()
 		case <-heapProfilingState.timer.C:
-			heapProfilingState.start(ctx, cfg.logger, t.metadataReader())
+			if cfg.recordingDisabled {
+				heapProfilingState.resetTimer()
+				continue
+			}
+			heapProfilingState.start(ctx, cfg.logger, t.metadataReader(cfg.serviceFramework))
 		case <-heapProfilingState.finished:
 			heapProfilingState.resetTimer()
 		case flushed = <-t.forceFlush:
@@ -888,21 +1658,36 @@ func (t *Tracer) loop() {
 				event := <-t.events
 				switch event.eventType {
 				case transactionEvent:
-					if !t.breakdownMetrics.recordTransaction(event.tx.TransactionData) {
-						if !breakdownMetricsLimitWarningLogged && cfg.logger != nil {
-							cfg.logger.Warningf("%s", breakdownMetricsLimitWarning)
-							breakdownMetricsLimitWarningLogged = true
+					if !event.tx.Transaction.abandoned {
+						checkTransaction(cfg.logger, event.tx.TransactionData)
+						if !t.breakdownMetrics.recordTransaction(event.tx.TransactionData) {
+							if !breakdownMetricsLimitWarningLogged && cfg.logger != nil {
+								cfg.logger.Warningf("%s", breakdownMetricsLimitWarning)
+								breakdownMetricsLimitWarningLogged = true
+							}
 						}
 					}
 					modelWriter.writeTransaction(event.tx.Transaction, event.tx.TransactionData)
 				case spanEvent:
-					modelWriter.writeSpan(event.span.Span, event.span.SpanData)
+					checkSpan(cfg.logger, event.span)
+					modelWriter.writeSpan(event.span)
 				case errorEvent:
-					modelWriter.writeError(event.err)
+					if !cfg.errorDeduplication.enabled() || !errorDeduplicator.add(event.err, cfg.errorDeduplication.maxDistinct) {
+						modelWriter.writeError(event.err)
+					}
+				}
+			}
+			if errorDeduplicator.len() > 0 {
+				errorDeduplicator.flush(modelWriter.writeError)
+				if errorDeduplicationTimerActive {
+					if !errorDeduplicationTimer.Stop() {
+						<-errorDeduplicationTimer.C
+					}
+					errorDeduplicationTimerActive = false
 				}
 			}
 			if !requestActive && buffer.Len() == 0 && metricsBuffer.Len() == 0 {
-				flushed <- struct{}{}
+				flushed <- flushResult{}
 				continue
 			}
 			closeRequest = true
@@ -911,6 +1696,21 @@ func (t *Tracer) loop() {
 			if err != nil {
 				stats.Errors.SendStream++
 				gracePeriod = nextGracePeriod(gracePeriod)
+				retryAfter = 0
+				if err, ok := err.(*transport.HTTPError); ok {
+					// Honour the server's Retry-After response header, if any,
+					// e.g. when the server is overloaded and responds with 429.
+					if d, ok := err.RetryAfter(); ok {
+						retryAfter = d
+						if retryAfter > maxRetryAfter {
+							retryAfter = maxRetryAfter
+						}
+					}
+				}
+				nextRequest := gracePeriod
+				if retryAfter > nextRequest {
+					nextRequest = retryAfter
+				}
 				if cfg.logger != nil {
 					logf := cfg.logger.Debugf
 					if err, ok := err.(*transport.HTTPError); ok && err.Response.StatusCode == 404 {
@@ -918,10 +1718,13 @@ func (t *Tracer) loop() {
 						// the error is due to a misconfigured environment.
 						logf = cfg.logger.Errorf
 					}
-					logf("request failed: %s (next request in ~%s)", err, gracePeriod)
+					logf("request failed: %s (next request in ~%s)", err, nextRequest)
 				}
+				t.health.requestFailed(err, nextRequest)
 			} else {
 				gracePeriod = -1 // Reset grace period after success.
+				retryAfter = 0
+				t.health.requestSucceeded()
 				stats.TransactionsSent += requestBufTransactions
 				stats.SpansSent += requestBufSpans
 				stats.ErrorsSent += requestBufErrors
@@ -952,7 +1755,16 @@ func (t *Tracer) loop() {
 				sentMetrics = nil
 			}
 			if flushed != nil {
-				flushed <- struct{}{}
+				result := flushResult{err: err}
+				if err == nil {
+					result.FlushResult = FlushResult{
+						Transactions: requestBufTransactions,
+						Spans:        requestBufSpans,
+						Errors:       requestBufErrors,
+						Metricsets:   requestBufMetricsets,
+					}
+				}
+				flushed <- result
 				flushed = nil
 			}
 			if req.Buf != nil {
@@ -984,6 +1796,7 @@ func (t *Tracer) loop() {
 			t.statsMu.Unlock()
 			stats = TracerStats{}
 		}
+		t.health.setBufferedBytes(buffer.Len() + metricsBuffer.Len())
 
 		if gatherMetrics {
 			gatheringMetrics = true
@@ -998,9 +1811,13 @@ func (t *Tracer) loop() {
 			if buffer.Len() == 0 && metricsBuffer.Len() == 0 {
 				continue
 			}
-			sendStreamRequest <- gracePeriod
+			sendStreamRequest <- sendStreamParams{
+				gracePeriod: gracePeriod,
+				retryAfter:  retryAfter,
+				timeout:     cfg.requestDuration + sendStreamTimeoutMargin,
+			}
 			if metadata == nil {
-				metadata = t.jsonRequestMetadata()
+				metadata = t.jsonRequestMetadata(cfg.serviceFramework)
 			}
 			zlibWriter.Reset(&requestBuf)
 			zlibWriter.Write(metadata)
@@ -1030,6 +1847,11 @@ func (t *Tracer) loop() {
 				if buffer.Len() == 0 {
 					break
 				}
+				if modelWriter.oldestBlockExpired(cfg.maxQueueAge) {
+					modelWriter.discardOldestBlock()
+					continue
+				}
+				modelWriter.bufferTimestamps.pop()
 				if h, _, err := buffer.WriteBlockTo(zlibWriter); err == nil {
 					switch h.Tag {
 					case transactionBlockTag:
@@ -1076,27 +1898,70 @@ func (t *Tracer) loop() {
 	}
 }
 
+// checkTransaction corrects a negative duration for an ended transaction,
+// logging warnings for it and for any baggage members dropped when the
+// transaction was started.
+func checkTransaction(logger WarningLogger, td *TransactionData) {
+	if td.Duration < 0 {
+		logNegativeDuration(logger, "transaction", td.Name, td.Duration)
+		td.Duration = 0
+	}
+	if td.baggageDropped > 0 && logger != nil {
+		logger.Warningf(
+			"transaction %q baggage exceeds the maximum of %d members or %d bytes, dropped %d members",
+			td.Name, maxBaggageMembers, maxBaggageBytes, td.baggageDropped,
+		)
+	}
+	if td.baggageInvalid > 0 && logger != nil {
+		logger.Warningf(
+			"transaction %q baggage has invalid or duplicate members, dropped %d members",
+			td.Name, td.baggageInvalid,
+		)
+	}
+}
+
+// checkSpan corrects a negative duration for an ended span, logging a warning.
+func checkSpan(logger WarningLogger, sd *SpanData) {
+	if sd.Duration < 0 {
+		logNegativeDuration(logger, "span", sd.Name, sd.Duration)
+		sd.Duration = 0
+	}
+}
+
+// logNegativeDuration logs a warning about an event with a negative
+// duration, which will be reported as zero. Durations are calculated
+// using the monotonic clock, so negative durations arise only from
+// start times in the future.
+func logNegativeDuration(logger WarningLogger, eventType, name string, d time.Duration) {
+	if logger != nil {
+		logger.Warningf("%s %q has negative duration %s, reporting zero duration", eventType, name, d)
+	}
+}
+
 // jsonRequestMetadata returns a JSON-encoded metadata object that features
 // at the head of every request body. This is called exactly once, when the
 // first request is made.
-func (t *Tracer) jsonRequestMetadata() []byte {
+func (t *Tracer) jsonRequestMetadata(framework model.Framework) []byte {
 	var json fastjson.Writer
 	json.RawString(`{"metadata":`)
-	t.encodeRequestMetadata(&json)
+	t.encodeRequestMetadata(&json, framework)
 	json.RawString("}\n")
 	return json.Bytes()
 }
 
 // metadataReader returns an io.Reader that holds the JSON-encoded metadata,
 // suitable for including in a profile request.
-func (t *Tracer) metadataReader() io.Reader {
+func (t *Tracer) metadataReader(framework model.Framework) io.Reader {
 	var metadata fastjson.Writer
-	t.encodeRequestMetadata(&metadata)
+	t.encodeRequestMetadata(&metadata, framework)
 	return bytes.NewReader(metadata.Bytes())
 }
 
-func (t *Tracer) encodeRequestMetadata(json *fastjson.Writer) {
+func (t *Tracer) encodeRequestMetadata(json *fastjson.Writer, framework model.Framework) {
 	service := makeService(t.Service.Name, t.Service.Version, t.Service.Environment)
+	if framework.Name != "" {
+		service.Framework = &framework
+	}
 	json.RawString(`{"system":`)
 	t.system.MarshalFastJSON(json)
 	json.RawString(`,"process":`)
@@ -1160,11 +2025,8 @@ type tracerEvent struct {
 	}
 
 	// span is set only if eventType == spanEvent.
-	span struct {
-		*Span
-		// Span.SpanData is nil at the point tracerEvent
-		// is created (to signify that the span is ended),
-		// so we pass it along side.
-		*SpanData
-	}
+	//
+	// The Span itself is not passed along, as it
+	// may be reused once it has been ended.
+	span *SpanData
 }
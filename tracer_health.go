@@ -0,0 +1,130 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HealthStatus holds a snapshot of the health of a Tracer's
+// communication with the APM server, as returned by Tracer.Health.
+type HealthStatus struct {
+	// Active reports whether the tracer is active, as reported
+	// by Tracer.Active.
+	Active bool
+
+	// LastSuccessfulSend holds the time at which events were last
+	// successfully sent to the APM server. This will be zero if no
+	// events have been successfully sent.
+	LastSuccessfulSend time.Time
+
+	// LastError holds the error returned by the transport for the
+	// most recent request, if that request failed.
+	LastError error
+
+	// ConsecutiveFailures holds the number of consecutive requests
+	// to the APM server that have failed. This is reset to zero
+	// when a request succeeds.
+	ConsecutiveFailures uint64
+
+	// BackoffUntil holds the approximate time until which the tracer
+	// will not make requests to the APM server, due to preceding
+	// failures. This will be zero if the tracer is not backing off.
+	BackoffUntil time.Time
+
+	// QueuedEvents holds the number of transactions, spans, and errors
+	// waiting to be processed by the tracer.
+	QueuedEvents int
+
+	// BufferedBytes holds the number of bytes of encoded events and
+	// metrics buffered by the tracer, waiting to be sent.
+	BufferedBytes int
+}
+
+// Healthy reports whether the tracer is active, and the most recent
+// request to the APM server, if any, succeeded.
+func (s HealthStatus) Healthy() bool {
+	return s.Active && s.ConsecutiveFailures == 0
+}
+
+// Health returns a snapshot of the health of the tracer's communication
+// with the APM server. Health is cheap to call, and does not wait for the
+// tracer; it is intended for use in application health checks.
+func (t *Tracer) Health() HealthStatus {
+	status := HealthStatus{
+		Active:       t.Active(),
+		QueuedEvents: len(t.events),
+	}
+	if t.health == nil {
+		return status
+	}
+	status.BufferedBytes = int(atomic.LoadInt64(&t.health.bufferedBytes))
+	t.health.mu.Lock()
+	status.LastSuccessfulSend = t.health.lastSuccessfulSend
+	status.LastError = t.health.lastError
+	status.ConsecutiveFailures = t.health.consecutiveFailures
+	status.BackoffUntil = t.health.backoffUntil
+	t.health.mu.Unlock()
+	return status
+}
+
+// tracerHealth holds the state reported by Tracer.Health,
+// which is updated by the tracer loop.
+type tracerHealth struct {
+	// bufferedBytes must be accessed atomically, and is
+	// first in the struct to ensure 64-bit alignment.
+	bufferedBytes int64
+
+	mu                  sync.Mutex
+	lastSuccessfulSend  time.Time
+	lastError           error
+	consecutiveFailures uint64
+	backoffUntil        time.Time
+}
+
+// setBufferedBytes records the number of bytes buffered by the tracer.
+func (h *tracerHealth) setBufferedBytes(n int) {
+	atomic.StoreInt64(&h.bufferedBytes, int64(n))
+}
+
+// requestFailed records a failed request, after which the
+// tracer will back off for the given duration.
+func (h *tracerHealth) requestFailed(err error, backoff time.Duration) {
+	now := time.Now()
+	h.mu.Lock()
+	h.lastError = err
+	h.consecutiveFailures++
+	h.backoffUntil = time.Time{}
+	if backoff > 0 {
+		h.backoffUntil = now.Add(backoff)
+	}
+	h.mu.Unlock()
+}
+
+// requestSucceeded records a successful request.
+func (h *tracerHealth) requestSucceeded() {
+	now := time.Now()
+	h.mu.Lock()
+	h.lastSuccessfulSend = now
+	h.lastError = nil
+	h.consecutiveFailures = 0
+	h.backoffUntil = time.Time{}
+	h.mu.Unlock()
+}
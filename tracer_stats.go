@@ -19,13 +19,24 @@ package apm
 
 // TracerStats holds statistics for a Tracer.
 type TracerStats struct {
-	Errors              TracerStatsErrors
-	ErrorsSent          uint64
-	ErrorsDropped       uint64
-	TransactionsSent    uint64
-	TransactionsDropped uint64
-	SpansSent           uint64
-	SpansDropped        uint64
+	Errors               TracerStatsErrors
+	ErrorsSent           uint64
+	ErrorsDropped        uint64
+	ErrorsFiltered       uint64
+	TransactionsSent     uint64
+	TransactionsDropped  uint64
+	TransactionsFiltered uint64
+	SpansSent            uint64
+	SpansDropped         uint64
+	SpansFiltered        uint64
+
+	// TransactionsExpired, SpansExpired, and ErrorsExpired hold the
+	// numbers of events dropped due to exceeding the maximum queue
+	// age set by Tracer.SetMaxQueueAge. The *Dropped fields count
+	// events dropped due to the buffer being full.
+	TransactionsExpired uint64
+	SpansExpired        uint64
+	ErrorsExpired       uint64
 }
 
 // TracerStatsErrors holds error statistics for a Tracer.
@@ -34,6 +45,26 @@ type TracerStatsErrors struct {
 	SendStream uint64
 }
 
+// TracerPoolStats holds statistics for a Tracer's object pools.
+type TracerPoolStats struct {
+	Transactions PoolStats
+	Spans        PoolStats
+	Errors       PoolStats
+}
+
+// PoolStats holds statistics for an object pool.
+type PoolStats struct {
+	// Gets holds the number of objects requested from the pool.
+	Gets uint64
+
+	// Puts holds the number of objects returned to the pool.
+	Puts uint64
+
+	// News holds the number of objects requested from the pool
+	// that had to be allocated, because the pool was empty.
+	News uint64
+}
+
 func (s TracerStats) isZero() bool {
 	return s == TracerStats{}
 }
@@ -45,8 +76,14 @@ func (s *TracerStats) accumulate(rhs TracerStats) {
 	s.Errors.SendStream += rhs.Errors.SendStream
 	s.ErrorsSent += rhs.ErrorsSent
 	s.ErrorsDropped += rhs.ErrorsDropped
+	s.ErrorsFiltered += rhs.ErrorsFiltered
 	s.SpansSent += rhs.SpansSent
 	s.SpansDropped += rhs.SpansDropped
+	s.SpansFiltered += rhs.SpansFiltered
 	s.TransactionsSent += rhs.TransactionsSent
 	s.TransactionsDropped += rhs.TransactionsDropped
+	s.TransactionsFiltered += rhs.TransactionsFiltered
+	s.TransactionsExpired += rhs.TransactionsExpired
+	s.SpansExpired += rhs.SpansExpired
+	s.ErrorsExpired += rhs.ErrorsExpired
 }
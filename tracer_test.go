@@ -18,6 +18,8 @@
 package apm_test
 
 import (
+	"bufio"
+	"compress/zlib"
 	"context"
 	"errors"
 	"fmt"
@@ -28,6 +30,7 @@ import (
 	"os"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -68,6 +71,100 @@ func TestTracerClosedSendNonblocking(t *testing.T) {
 	assert.Equal(t, uint64(1), tracer.Stats().TransactionsDropped)
 }
 
+func TestTracerClosed(t *testing.T) {
+	tracer, err := apm.NewTracer("tracer_testing", "")
+	assert.NoError(t, err)
+
+	select {
+	case <-tracer.Closed():
+		t.Fatal("tracer closed unexpectedly")
+	default:
+	}
+
+	tracer.Close()
+	select {
+	case <-tracer.Closed():
+	default:
+		t.Fatal("expected tracer to be closed")
+	}
+}
+
+func TestTracerStarted(t *testing.T) {
+	tracer := apmtest.NewDiscardTracer()
+	defer tracer.Close()
+	select {
+	case <-tracer.Started():
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for tracer to start")
+	}
+
+	os.Setenv("ELASTIC_APM_ACTIVE", "false")
+	defer os.Unsetenv("ELASTIC_APM_ACTIVE")
+	inactive, err := apm.NewTracer("tracer_testing", "")
+	require.NoError(t, err)
+	defer inactive.Close()
+	select {
+	case <-inactive.Started():
+	default:
+		t.Fatal("expected inactive tracer to be started")
+	}
+}
+
+func TestNoopTracer(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	tracer := apm.NewNoopTracer()
+	defer tracer.Close()
+	assert.True(t, runtime.NumGoroutine() <= goroutines)
+	assert.False(t, tracer.Active())
+
+	for i := 0; i < 2000; i++ {
+		tx := tracer.StartTransaction("name", "type")
+		assert.False(t, tx.Sampled())
+		span := tx.StartSpan("name", "type", nil)
+		assert.True(t, span.Dropped())
+		span.End()
+		e := tracer.NewError(errors.New("boom"))
+		e.SetTransaction(tx)
+		e.Send()
+		tx.End()
+	}
+	tracer.SetMaxSpans(10)
+	tracer.Flush(nil)
+	tracer.SendMetrics(nil)
+	assert.True(t, runtime.NumGoroutine() <= goroutines)
+}
+
+func TestTracerSetRecording(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	sendEvents := func() apm.TraceContext {
+		tx := tracer.StartTransaction("name", "type")
+		tx.StartSpan("name", "type", nil).End()
+		e := tracer.NewError(errors.New("boom"))
+		e.SetTransaction(tx)
+		e.Send()
+		tx.End()
+		tracer.Flush(nil)
+		return tx.TraceContext()
+	}
+
+	tracer.SetRecording(false)
+	traceContext := sendEvents()
+	assert.NoError(t, traceContext.Trace.Validate())
+	assert.NoError(t, traceContext.Span.Validate())
+	assert.True(t, traceContext.Options.Recorded())
+	tracer.SendMetrics(nil)
+	assert.Zero(t, transport.Payloads())
+
+	tracer.SetRecording(true)
+	sendEvents()
+	payloads := transport.Payloads()
+	assert.Len(t, payloads.Transactions, 1)
+	assert.Len(t, payloads.Spans, 1)
+	assert.Len(t, payloads.Errors, 1)
+}
+
 func TestTracerCloseImmediately(t *testing.T) {
 	tracer, err := apm.NewTracer("tracer_testing", "")
 	assert.NoError(t, err)
@@ -81,6 +178,207 @@ func TestTracerFlushEmpty(t *testing.T) {
 	tracer.Flush(nil)
 }
 
+func TestTracerFlushContext(t *testing.T) {
+	tracer, _ := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	result, err := tracer.FlushContext(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, apm.FlushResult{}, result)
+
+	tx := tracer.StartTransaction("name", "type")
+	tx.StartSpan("name", "type", nil).End()
+	tx.StartSpan("name", "type", nil).End()
+	tx.End()
+	tracer.NewError(errors.New("boom")).Send()
+	result, err = tracer.FlushContext(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, apm.FlushResult{Transactions: 1, Spans: 2, Errors: 1}, result)
+}
+
+func TestTracerFlushContextSendError(t *testing.T) {
+	tracer, err := apm.NewTracerOptions(apm.TracerOptions{
+		Transport: transporttest.ErrorTransport{Error: errors.New("nope")},
+	})
+	require.NoError(t, err)
+	defer tracer.Close()
+
+	tracer.StartTransaction("name", "type").End()
+	result, err := tracer.FlushContext(context.Background())
+	assert.EqualError(t, err, "nope")
+	assert.Equal(t, apm.FlushResult{}, result)
+}
+
+func TestTracerFlushContextCanceled(t *testing.T) {
+	tracer, err := apm.NewTracerOptions(apm.TracerOptions{
+		Transport: blockedTransport{},
+	})
+	require.NoError(t, err)
+	defer tracer.Close()
+
+	tracer.StartTransaction("name", "type").End()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = tracer.FlushContext(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestTracerSendStreamTimeout(t *testing.T) {
+	started := make(chan time.Time, 1)
+	tracer, err := apm.NewTracerOptions(apm.TracerOptions{
+		Transport: sendStreamFunc(func(ctx context.Context, r io.Reader) error {
+			deadline, ok := ctx.Deadline()
+			assert.True(t, ok)
+			started <- deadline
+			<-ctx.Done() // hang until the tracer is closed
+			return ctx.Err()
+		}),
+	})
+	require.NoError(t, err)
+	defer tracer.Close()
+
+	tracer.StartTransaction("name", "type").End()
+	var deadline time.Time
+	select {
+	case deadline = <-started:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for request")
+	}
+	assert.WithinDuration(t, time.Now().Add(40*time.Second), deadline, 5*time.Second)
+
+	// The hanging request must not prevent configuration updates.
+	updated := make(chan struct{})
+	go func() {
+		defer close(updated)
+		tracer.SetMetricsInterval(time.Hour)
+		tracer.SetSpanObserver(nil)
+		tracer.SetErrorContextLines(1, 1)
+	}()
+	select {
+	case <-updated:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out updating configuration")
+	}
+}
+
+func TestTracerErrorFlushInterval(t *testing.T) {
+	errorLines := make(chan string, 10)
+	tracer, err := apm.NewTracerOptions(apm.TracerOptions{
+		Transport: sendStreamFunc(func(ctx context.Context, r io.Reader) error {
+			zr, err := zlib.NewReader(r)
+			if err != nil {
+				return err
+			}
+			scanner := bufio.NewScanner(zr)
+			scanner.Buffer(nil, 1024*1024)
+			for scanner.Scan() {
+				if line := scanner.Text(); strings.HasPrefix(line, `{"error":`) {
+					errorLines <- line
+				}
+			}
+			return scanner.Err()
+		}),
+	})
+	require.NoError(t, err)
+	defer tracer.Close()
+
+	// By default, errors are transmitted immediately.
+	tracer.NewError(fmt.Errorf("immediate")).Send()
+	select {
+	case <-errorLines:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for error")
+	}
+
+	// With an interval, errors are held until the interval ends,
+	// and then transmitted together without an explicit flush.
+	tracer.SetErrorFlushInterval(50 * time.Millisecond)
+	tracer.NewError(fmt.Errorf("batched1")).Send()
+	tracer.NewError(fmt.Errorf("batched2")).Send()
+	for i := 0; i < 2; i++ {
+		select {
+		case line := <-errorLines:
+			assert.Contains(t, line, fmt.Sprintf("batched%d", i+1))
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for batched errors")
+		}
+	}
+
+	// Errors are held until the interval ends, or the tracer is flushed.
+	tracer.SetErrorFlushInterval(time.Hour)
+	tracer.NewError(fmt.Errorf("first")).Send()
+	tracer.NewError(fmt.Errorf("second")).Send()
+	select {
+	case <-errorLines:
+		t.Fatal("unexpected error transmitted before the interval ended")
+	case <-time.After(100 * time.Millisecond):
+	}
+	tracer.Flush(nil)
+	assert.Len(t, errorLines, 2)
+}
+
+func TestTracerRetryAfter(t *testing.T) {
+	var requests int32
+	tracer, err := apm.NewTracerOptions(apm.TracerOptions{
+		Transport: sendStreamFunc(func(ctx context.Context, r io.Reader) error {
+			io.Copy(ioutil.Discard, r)
+			if atomic.AddInt32(&requests, 1) > 1 {
+				return nil
+			}
+			header := make(http.Header)
+			header.Set("Retry-After", "3600")
+			return &transport.HTTPError{Response: &http.Response{
+				Status:     "429 Too Many Requests",
+				StatusCode: http.StatusTooManyRequests,
+				Header:     header,
+			}}
+		}),
+	})
+	require.NoError(t, err)
+	defer tracer.Close()
+
+	tracer.StartTransaction("name", "type").End()
+	_, err = tracer.FlushContext(context.Background())
+	assert.Error(t, err)
+
+	// The next request should be delayed by an hour, as requested
+	// by the server, so flushing should time out.
+	tracer.StartTransaction("name", "type").End()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = tracer.FlushContext(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+}
+
+func TestTracerRetryAfterLimit(t *testing.T) {
+	tracer, err := apm.NewTracerOptions(apm.TracerOptions{
+		Transport: sendStreamFunc(func(ctx context.Context, r io.Reader) error {
+			io.Copy(ioutil.Discard, r)
+			header := make(http.Header)
+			header.Set("Retry-After", "999999999")
+			return &transport.HTTPError{Response: &http.Response{
+				Status:     "503 Service Unavailable",
+				StatusCode: http.StatusServiceUnavailable,
+				Header:     header,
+			}}
+		}),
+	})
+	require.NoError(t, err)
+	defer tracer.Close()
+
+	tracer.StartTransaction("name", "type").End()
+	before := time.Now()
+	_, err = tracer.FlushContext(context.Background())
+	assert.Error(t, err)
+
+	// Excessive delays requested by the server are limited,
+	// so that the tracer does not stop sending indefinitely.
+	backoffUntil := tracer.Health().BackoffUntil
+	assert.False(t, backoffUntil.IsZero())
+	assert.True(t, backoffUntil.Before(before.Add(6*time.Minute)), "backoff until %s", backoffUntil)
+}
+
 func TestTracerMaxSpans(t *testing.T) {
 	test := func(n int) {
 		t.Run(fmt.Sprint(n), func(t *testing.T) {
@@ -89,7 +387,6 @@ func TestTracerMaxSpans(t *testing.T) {
 
 			tracer.SetMaxSpans(n)
 			tx := tracer.StartTransaction("name", "type")
-			defer tx.End()
 
 			// SetMaxSpans only affects transactions started
 			// after the call.
@@ -103,15 +400,109 @@ func TestTracerMaxSpans(t *testing.T) {
 			span := tx.StartSpan("name", "type", nil)
 			assert.True(t, span.Dropped())
 			span.End()
+			tx.End()
 
 			tracer.Flush(nil)
-			assert.Len(t, r.Payloads().Spans, n)
+			payloads := r.Payloads()
+			assert.Len(t, payloads.Spans, n)
+			require.Len(t, payloads.Transactions, 1)
+			assert.Equal(t, model.SpanCount{Started: n, Dropped: 1}, payloads.Transactions[0].SpanCount)
 		})
 	}
 	test(0)
 	test(23)
 }
 
+func TestTracerMaxSpansDroppedAllocs(t *testing.T) {
+	tracer := apmtest.NewDiscardTracer()
+	defer tracer.Close()
+	tracer.SetMaxSpans(1)
+
+	tx := tracer.StartTransaction("name", "type")
+	defer tx.End()
+	tx.StartSpan("name", "type", nil).End()
+
+	// Spans beyond the limit are dropped without being
+	// initialised from the tracer's span pool, and their
+	// span data is reused.
+	allocs := testing.AllocsPerRun(100, func() {
+		span := tx.StartSpan("name", "type", nil)
+		if !span.Dropped() {
+			panic("expected span to be dropped")
+		}
+		span.End()
+	})
+	assert.LessOrEqual(t, allocs, 1.0)
+}
+
+func TestTracerDropUnsampledSpans(t *testing.T) {
+	tracer, r := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetDropUnsampledSpans(true)
+
+	// Spans of sampled transactions are unaffected.
+	tx := tracer.StartTransaction("sampled", "type")
+	span, ctx := apm.StartSpan(apm.ContextWithTransaction(context.Background(), tx), "name", "type")
+	assert.False(t, span.Dropped())
+	assert.Equal(t, span, apm.SpanFromContext(ctx))
+	span.End()
+	tx.End()
+
+	tracer.SetSampler(apm.NewRatioSampler(0))
+	tx = tracer.StartTransaction("unsampled", "type")
+	span, ctx = apm.StartSpan(apm.ContextWithTransaction(context.Background(), tx), "name", "type")
+	assert.True(t, span.Dropped())
+	assert.Nil(t, apm.SpanFromContext(ctx))
+	span.Context.SetDatabase(apm.DatabaseSpanContext{Statement: "SELECT 1"})
+	span.End()
+	tx.End()
+
+	tracer.Flush(nil)
+	payloads := r.Payloads()
+	require.Len(t, payloads.Transactions, 2)
+	assert.Equal(t, 1, payloads.Transactions[0].SpanCount.Started)
+	assert.Equal(t, 0, payloads.Transactions[1].SpanCount.Started)
+	assert.Equal(t, 0, payloads.Transactions[1].SpanCount.Dropped)
+	require.Len(t, payloads.Spans, 1)
+}
+
+func TestTracerMaxQueueAge(t *testing.T) {
+	tracer, r := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetMaxQueueAge(time.Minute)
+
+	stale := time.Now().Add(-time.Hour)
+	tx := tracer.StartTransactionOptions("stale", "type", apm.TransactionOptions{Start: stale})
+	tx.StartSpanOptions("stale", "type", apm.SpanOptions{Start: stale}).End()
+	tx.Duration = time.Second
+	tx.End()
+	e := tracer.NewError(errors.New("stale"))
+	e.Timestamp = stale
+	e.Send()
+
+	tx = tracer.StartTransaction("fresh", "type")
+	tx.StartSpan("fresh", "type", nil).End()
+	tx.End()
+	tracer.NewError(errors.New("fresh")).Send()
+	tracer.Flush(nil)
+
+	payloads := r.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	require.Len(t, payloads.Spans, 1)
+	require.Len(t, payloads.Errors, 1)
+	assert.Equal(t, "fresh", payloads.Transactions[0].Name)
+	assert.Equal(t, "fresh", payloads.Spans[0].Name)
+	assert.Equal(t, "fresh", payloads.Errors[0].Exception.Message)
+	assert.Equal(t, apm.TracerStats{
+		TransactionsSent:    1,
+		SpansSent:           1,
+		ErrorsSent:          1,
+		TransactionsExpired: 1,
+		SpansExpired:        1,
+		ErrorsExpired:       1,
+	}, tracer.Stats())
+}
+
 func TestTracerErrors(t *testing.T) {
 	tracer, r := transporttest.NewRecorderTracer()
 	defer tracer.Close()
@@ -201,6 +592,123 @@ func TestTracerRecovered(t *testing.T) {
 	assert.Equal(t, span.ID, error0.ParentID)
 }
 
+func TestTracerRecoverWithContext(t *testing.T) {
+	tracer, r := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	func() {
+		tx := tracer.StartTransaction("name", "type")
+		defer tx.End()
+		req, _ := http.NewRequest("GET", "http://server.testing/foo?bar", nil)
+		tx.Context.SetHTTPRequest(req)
+		tx.Context.SetCustom("k", "v")
+		ctx := apm.ContextWithTransaction(context.Background(), tx)
+		defer tracer.RecoverWithContext(ctx, nil)
+		panic("blam")
+	}()
+	tracer.Flush(nil)
+
+	payloads := r.Payloads()
+	require.Len(t, payloads.Errors, 1)
+	require.Len(t, payloads.Transactions, 1)
+	error0 := payloads.Errors[0]
+	transaction := payloads.Transactions[0]
+	assert.Equal(t, "blam", error0.Exception.Message)
+	assert.Equal(t, transaction.ID, error0.TransactionID)
+	assert.Equal(t, transaction.ID, error0.ParentID)
+	require.NotNil(t, error0.Context)
+	require.NotNil(t, error0.Context.Request)
+	assert.Equal(t, "GET", error0.Context.Request.Method)
+	assert.Equal(t, transaction.Context.Request.URL, error0.Context.Request.URL)
+	assert.Equal(t, model.IfaceMap{{Key: "k", Value: "v"}}, error0.Context.Custom)
+}
+
+func TestTracerRecoveredTypes(t *testing.T) {
+	type customPanic struct{ Code int }
+	var nilMap map[string]int
+	var nilPointer *customPanic
+	var slice []int
+
+	type test struct {
+		name    string
+		f       func()
+		message string
+		module  string
+		typ     string
+	}
+	for _, test := range []test{{
+		name:    "nil_dereference",
+		f:       func() { _ = nilPointer.Code },
+		message: "runtime error: invalid memory address or nil pointer dereference",
+		module:  "runtime",
+		typ:     "errorString",
+	}, {
+		name:    "index_out_of_range",
+		f:       func() { _ = slice[len(slice)+1] },
+		message: "runtime error: index out of range [1] with length 0",
+		module:  "runtime",
+		typ:     "boundsError",
+	}, {
+		name:    "nil_map",
+		f:       func() { nilMap["a"] = 1 },
+		message: "assignment to entry in nil map",
+		module:  "runtime",
+		typ:     "plainError",
+	}, {
+		name:    "error",
+		f:       func() { panic(recoveredError{}) },
+		message: "recovered error",
+		module:  "go.elastic.co/apm_test",
+		typ:     "recoveredError",
+	}, {
+		name:    "string",
+		f:       func() { panic("boom") },
+		message: "boom",
+		typ:     "string",
+	}, {
+		name:    "int",
+		f:       func() { panic(42) },
+		message: "42",
+		typ:     "int",
+	}, {
+		name:    "struct_pointer",
+		f:       func() { panic(&customPanic{Code: 1}) },
+		message: "&{1}",
+		module:  "go.elastic.co/apm_test",
+		typ:     "customPanic",
+	}, {
+		name:    "slice",
+		f:       func() { panic([]int{1, 2}) },
+		message: "[1 2]",
+		typ:     "[]int",
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			tracer, r := transporttest.NewRecorderTracer()
+			defer tracer.Close()
+			func() {
+				defer func() {
+					tracer.Recovered(recover()).Send()
+				}()
+				test.f()
+			}()
+			tracer.Flush(nil)
+
+			payloads := r.Payloads()
+			require.Len(t, payloads.Errors, 1)
+			exception := payloads.Errors[0].Exception
+			assert.Equal(t, test.message, exception.Message)
+			assert.Equal(t, test.module, exception.Module)
+			assert.Equal(t, test.typ, exception.Type)
+		})
+	}
+}
+
+type recoveredError struct{}
+
+func (recoveredError) Error() string {
+	return "recovered error"
+}
+
 func capturePanic(tracer *apm.Tracer, v interface{}) {
 	tx := tracer.StartTransaction("name", "type")
 	defer tx.End()
@@ -256,6 +764,263 @@ func TestSpanStackTrace(t *testing.T) {
 	assert.Equal(t, spans[2].Stacktrace[0].Function, "TestSpanStackTrace")
 }
 
+func TestTracerContextLines(t *testing.T) {
+	tracer, r := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetContextSetter(contextSetterFunc(func(frame *model.StacktraceFrame, pre, post int) error {
+		frame.ContextLine = fmt.Sprintf("%d,%d", pre, post)
+		return nil
+	}))
+	tracer.SetErrorContextLines(5, 6)
+	tracer.SetSpanContextLines(-1, -1)
+
+	tx := tracer.StartTransaction("name", "type")
+	s := tx.StartSpan("name", "type", nil)
+	s.SetStacktrace(0)
+	s.End()
+	tx.End()
+	e := tracer.NewError(errors.New("boom"))
+	e.SetStacktrace(0)
+	e.Send()
+	tracer.Flush(nil)
+
+	payloads := r.Payloads()
+	require.Len(t, payloads.Spans, 1)
+	require.Len(t, payloads.Errors, 1)
+	assert.Equal(t, "", payloads.Spans[0].Stacktrace[0].ContextLine)
+	assert.Equal(t, "5,6", payloads.Errors[0].Exception.Stacktrace[0].ContextLine)
+
+	r.ResetPayloads()
+	tracer.SetContextLines(1, 2)
+	tx = tracer.StartTransaction("name", "type")
+	s = tx.StartSpan("name", "type", nil)
+	s.SetStacktrace(0)
+	s.End()
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads = r.Payloads()
+	require.Len(t, payloads.Spans, 1)
+	assert.Equal(t, "1,2", payloads.Spans[0].Stacktrace[0].ContextLine)
+}
+
+func TestTracerContextWorkers(t *testing.T) {
+	tracer, r := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	const workers = 4
+	var inFlight, maxInFlight int64
+	tracer.SetContextWorkers(workers)
+	tracer.SetContextSetter(contextSetterFunc(func(frame *model.StacktraceFrame, pre, post int) error {
+		n := atomic.AddInt64(&inFlight, 1)
+		defer atomic.AddInt64(&inFlight, -1)
+		for {
+			max := atomic.LoadInt64(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt64(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		frame.ContextLine = "context"
+		return nil
+	}))
+
+	var deepStack func(n int)
+	deepStack = func(n int) {
+		if n > 0 {
+			deepStack(n - 1)
+			return
+		}
+		e := tracer.NewError(errors.New("boom"))
+		e.SetStacktrace(0)
+		e.Send()
+	}
+	deepStack(10)
+	tracer.Flush(nil)
+
+	payloads := r.Payloads()
+	require.Len(t, payloads.Errors, 1)
+	frames := payloads.Errors[0].Exception.Stacktrace
+	require.True(t, len(frames) > workers)
+	for _, frame := range frames {
+		assert.Equal(t, "context", frame.ContextLine)
+	}
+	assert.True(t, maxInFlight > 1)
+	assert.True(t, maxInFlight <= workers)
+	assert.Zero(t, tracer.Stats().Errors.SetContext)
+
+	// Errors from the workers are counted once per stacktrace.
+	tracer.SetContextSetter(contextSetterFunc(func(frame *model.StacktraceFrame, pre, post int) error {
+		return errors.New("failed")
+	}))
+	deepStack(10)
+	tracer.Flush(nil)
+	assert.Equal(t, uint64(1), tracer.Stats().Errors.SetContext)
+}
+
+func TestTracerObservers(t *testing.T) {
+	tracer, r := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	var spanNames, transactionNames []string
+	tracer.SetSpanObserver(func(s *model.Span) {
+		spanNames = append(spanNames, s.Name)
+	})
+	tracer.SetTransactionObserver(func(tx *model.Transaction) {
+		transactionNames = append(transactionNames, tx.Name)
+	})
+
+	tx := tracer.StartTransaction("tx", "type")
+	tx.StartSpan("span", "type", nil).End()
+	tx.End()
+	tracer.Flush(nil)
+	assert.Equal(t, []string{"span"}, spanNames)
+	assert.Equal(t, []string{"tx"}, transactionNames)
+
+	payloads := r.Payloads()
+	assert.Len(t, payloads.Spans, 1)
+	assert.Len(t, payloads.Transactions, 1)
+
+	tracer.SetSpanObserver(nil)
+	tracer.SetTransactionObserver(nil)
+	tx = tracer.StartTransaction("tx2", "type")
+	tx.StartSpan("span2", "type", nil).End()
+	tx.End()
+	tracer.Flush(nil)
+	assert.Equal(t, []string{"span"}, spanNames)
+	assert.Equal(t, []string{"tx"}, transactionNames)
+}
+
+func TestTracerHealth(t *testing.T) {
+	var sendErr error
+	tracer, err := apm.NewTracerOptions(apm.TracerOptions{
+		Transport: sendStreamFunc(func(ctx context.Context, r io.Reader) error {
+			io.Copy(ioutil.Discard, r)
+			return sendErr
+		}),
+	})
+	require.NoError(t, err)
+	defer tracer.Close()
+
+	health := tracer.Health()
+	assert.True(t, health.Healthy())
+	assert.Equal(t, apm.HealthStatus{Active: true}, health)
+
+	sendErr = errors.New("nope")
+	tracer.StartTransaction("name", "type").End()
+	_, err = tracer.FlushContext(context.Background())
+	assert.EqualError(t, err, "nope")
+
+	health = tracer.Health()
+	assert.False(t, health.Healthy())
+	assert.Equal(t, apm.HealthStatus{
+		Active:              true,
+		LastError:           sendErr,
+		ConsecutiveFailures: 1,
+	}, health)
+
+	before := time.Now()
+	sendErr = nil
+	tracer.StartTransaction("name", "type").End()
+	_, err = tracer.FlushContext(context.Background())
+	assert.NoError(t, err)
+
+	health = tracer.Health()
+	assert.True(t, health.Healthy())
+	assert.False(t, health.LastSuccessfulSend.Before(before))
+	health.LastSuccessfulSend = time.Time{}
+	assert.Equal(t, apm.HealthStatus{Active: true}, health)
+
+	tracer.Close()
+	assert.False(t, tracer.Health().Healthy())
+}
+
+func TestTracerPoolStats(t *testing.T) {
+	tracer := apmtest.NewDiscardTracer()
+	defer tracer.Close()
+	assert.Equal(t, apm.TracerPoolStats{}, tracer.PoolStats())
+
+	tx := tracer.StartTransaction("name", "type")
+	tx.StartSpan("name", "type", nil).End()
+	tx.End()
+	tracer.NewError(errors.New("boom")).Send()
+	tracer.Flush(nil)
+
+	stats := tracer.PoolStats()
+	expected := apm.PoolStats{Gets: 1, Puts: 1, News: 1}
+	assert.Equal(t, expected, stats.Transactions)
+	assert.Equal(t, expected, stats.Spans)
+	assert.Equal(t, expected, stats.Errors)
+}
+
+func TestTracerFilters(t *testing.T) {
+	tracer, r := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	tracer.SetTransactionFilter(func(tx *model.Transaction) bool {
+		if tx.Name == "synthetic" {
+			return false
+		}
+		tx.Result = "filtered"
+		return true
+	})
+	tracer.SetErrorFilter(func(e *model.Error) bool {
+		return e.Exception.Message != "synthetic"
+	})
+
+	tracer.StartTransaction("synthetic", "type").End()
+	tracer.StartTransaction("tx", "type").End()
+	tracer.NewError(errors.New("synthetic")).Send()
+	tracer.NewError(errors.New("boom")).Send()
+	tracer.Flush(nil)
+
+	payloads := r.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	assert.Equal(t, "tx", payloads.Transactions[0].Name)
+	assert.Equal(t, "filtered", payloads.Transactions[0].Result)
+	require.Len(t, payloads.Errors, 1)
+	assert.Equal(t, "boom", payloads.Errors[0].Exception.Message)
+	assert.Equal(t, apm.TracerStats{
+		ErrorsSent:           1,
+		ErrorsFiltered:       1,
+		TransactionsSent:     1,
+		TransactionsFiltered: 1,
+	}, tracer.Stats())
+}
+
+func TestTracerSpanFilter(t *testing.T) {
+	tracer, r := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetSpanFilter(apm.SpanFilters(
+		apm.DropSpans(func(span *model.Span) bool { return span.Type == "cache" }),
+		apm.RedactSpanLabels("password"),
+	))
+
+	tx := tracer.StartTransaction("name", "type")
+	tx.StartSpan("GET", "cache", nil).End()
+	span := tx.StartSpan("SELECT", "db", nil)
+	span.Context.SetLabel("password", "hunter2")
+	span.End()
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := r.Payloads()
+	require.Len(t, payloads.Spans, 1)
+	assert.Equal(t, "SELECT", payloads.Spans[0].Name)
+	assert.Equal(t, model.IfaceMap{{Key: "password", Value: "[REDACTED]"}}, payloads.Spans[0].Context.Tags)
+	assert.Equal(t, apm.TracerStats{
+		SpansSent:        1,
+		SpansFiltered:    1,
+		TransactionsSent: 1,
+	}, tracer.Stats())
+}
+
+type contextSetterFunc func(frame *model.StacktraceFrame, pre, post int) error
+
+func (f contextSetterFunc) SetContext(frame *model.StacktraceFrame, pre, post int) error {
+	return f(frame, pre, post)
+}
+
 func TestTracerRequestSize(t *testing.T) {
 	os.Setenv("ELASTIC_APM_API_REQUEST_SIZE", "1KB")
 	defer os.Unsetenv("ELASTIC_APM_API_REQUEST_SIZE")
@@ -393,7 +1158,11 @@ func TestTracerMetadata(t *testing.T) {
 	tracer.Flush(nil)
 
 	// TODO(axw) check other metadata
-	system, _, _, _ := recorder.Metadata()
+	system, _, service, _ := recorder.Metadata()
+	assert.Nil(t, service.Framework)
+	assert.Equal(t, &model.Agent{Name: "go", Version: apm.AgentVersion}, service.Agent)
+	assert.Equal(t, &model.Language{Name: "go", Version: runtime.Version()}, service.Language)
+	assert.Equal(t, &model.Runtime{Name: runtime.Compiler, Version: runtime.Version()}, service.Runtime)
 	container, err := apmhostutil.Container()
 	if err != nil {
 		assert.Nil(t, system.Container)
@@ -403,6 +1172,18 @@ func TestTracerMetadata(t *testing.T) {
 	}
 }
 
+func TestTracerSetFramework(t *testing.T) {
+	tracer, recorder := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	tracer.SetFramework("gin", "v1.6.3")
+	tracer.StartTransaction("name", "type").End()
+	tracer.Flush(nil)
+
+	_, _, service, _ := recorder.Metadata()
+	assert.Equal(t, &model.Framework{Name: "gin", Version: "v1.6.3"}, service.Framework)
+}
+
 func TestTracerKubernetesMetadata(t *testing.T) {
 	t.Run("no-env", func(t *testing.T) {
 		system, _, _, _ := getSubprocessMetadata(t)
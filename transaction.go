@@ -18,6 +18,7 @@
 package apm
 
 import (
+	"context"
 	cryptorand "crypto/rand"
 	"encoding/binary"
 	"math/rand"
@@ -53,8 +54,12 @@ func (t *Tracer) StartTransactionOptions(name, transactionType string, opts Tran
 	}
 	tx := &Transaction{tracer: t, TransactionData: td}
 
+	// Take a snapshot of config that should apply to all spans within the
+	// transaction.
+	instrumentationConfig := t.instrumentationConfig()
+
 	tx.Name = name
-	tx.Type = transactionType
+	tx.Type = instrumentationConfig.transactionTypes.normalize(transactionType)
 
 	var root bool
 	if opts.TraceContext.Trace.Validate() == nil {
@@ -83,20 +88,30 @@ func (t *Tracer) StartTransactionOptions(name, transactionType string, opts Tran
 			copy(tx.traceContext.Span[:], tx.traceContext.Trace[:])
 		}
 	}
+	baggage, invalid := opts.TraceContext.Baggage.valid()
+	tx.traceContext.Baggage, tx.baggageDropped = baggage.limit(maxBaggageMembers, maxBaggageBytes)
+	tx.baggageInvalid = invalid
 
-	// Take a snapshot of config that should apply to all spans within the
-	// transaction.
-	instrumentationConfig := t.instrumentationConfig()
 	tx.maxSpans = instrumentationConfig.maxSpans
 	tx.spanFramesMinDuration = instrumentationConfig.spanFramesMinDuration
 	tx.stackTraceLimit = instrumentationConfig.stackTraceLimit
 	tx.Context.captureHeaders = instrumentationConfig.captureHeaders
 	tx.breakdownMetricsEnabled = t.breakdownMetrics.enabled
 	tx.propagateLegacyHeader = instrumentationConfig.propagateLegacyHeader
-
-	if root {
-		sampler := instrumentationConfig.sampler
-		if sampler == nil || sampler.Sample(tx.traceContext) {
+	tx.spanCompressionEnabled = instrumentationConfig.spanCompressionEnabled
+	tx.spanCompressionMaxDuration = instrumentationConfig.spanCompressionMaxDuration
+
+	if opts.ForceSampled {
+		tx.traceContext.Options = tx.traceContext.Options.WithRecorded(true)
+	} else if root {
+		var sampled bool
+		if opts.Sampled != nil {
+			sampled = *opts.Sampled
+		} else {
+			sampler := instrumentationConfig.sampler
+			sampled = sampler == nil || sampler.Sample(tx.traceContext)
+		}
+		if sampled {
 			o := tx.traceContext.Options.WithRecorded(true)
 			tx.traceContext.Options = o
 		}
@@ -108,9 +123,26 @@ func (t *Tracer) StartTransactionOptions(name, transactionType string, opts Tran
 		// applications may end up being sampled at a very high rate.
 		tx.traceContext.Options = opts.TraceContext.Options
 	}
-	tx.timestamp = opts.Start
-	if tx.timestamp.IsZero() {
+	if observer := instrumentationConfig.sampleDecisionObserver; observer != nil {
+		observer(name, tx.traceContext.Options.Recorded())
+	}
+	tx.dropSpans = instrumentationConfig.dropUnsampledSpans && !tx.traceContext.Options.Recorded()
+	// Record the start time with a monotonic clock reading, so that the
+	// duration calculated by End is unaffected by wall-clock adjustments.
+	// If a start time is specified without a monotonic clock reading of
+	// its own, it is made relative to the current time. Round(0) strips
+	// the monotonic clock reading, if any.
+	switch {
+	case opts.Start.IsZero():
 		tx.timestamp = time.Now()
+	case opts.Start == opts.Start.Round(0):
+		now := time.Now()
+		tx.timestamp = now.Add(opts.Start.Sub(now))
+	default:
+		tx.timestamp = opts.Start
+	}
+	if maxDuration := instrumentationConfig.transactionMaxDuration; maxDuration > 0 {
+		tx.maxDurationTimer = time.AfterFunc(maxDuration, tx.abandon)
 	}
 	return tx
 }
@@ -128,6 +160,17 @@ type TransactionOptions struct {
 	// Start is the start time of the transaction. If this has the
 	// zero value, time.Now() will be used instead.
 	Start time.Time
+
+	// ForceSampled, if true, causes the transaction to be sampled,
+	// overriding both the tracer's sampler and any sampling decision
+	// in TraceContext. The decision is propagated to downstream
+	// services through the transaction's trace context.
+	ForceSampled bool
+
+	// Sampled, if non-nil, holds a sampling decision made upstream, such
+	// as one conveyed by the header set with Tracer.SetSamplingHeader.
+	// If TraceContext is zero, then Sampled overrides the tracer's sampler.
+	Sampled *bool
 }
 
 // Transaction describes an event occurring in the monitored service.
@@ -137,6 +180,18 @@ type Transaction struct {
 
 	mu sync.RWMutex
 
+	// abandoned records whether the transaction has been ended due to
+	// exceeding its maximum duration, and abandonedDuration records the
+	// transaction's duration at that time. They are protected by mu,
+	// and are not modified once abandoned is set.
+	abandoned         bool
+	abandonedDuration time.Duration
+
+	// dropSpans records whether spans started within the transaction
+	// should be dropped without any bookkeeping, due to the transaction
+	// not being sampled. See Tracer.SetDropUnsampledSpans.
+	dropSpans bool
+
 	// TransactionData holds the transaction data. This field is set to
 	// nil when either of the transaction's End or Discard methods are called.
 	*TransactionData
@@ -218,7 +273,10 @@ func (tx *Transaction) Discard() {
 	if tx.ended() {
 		return
 	}
+	tx.stopMaxDurationTimer()
+	tx.compressedSpan.discard()
 	tx.reset(tx.tracer)
+	tx.TransactionData = nil
 }
 
 // End enqueues tx for sending to the Elastic APM server.
@@ -234,13 +292,76 @@ func (tx *Transaction) End() {
 	if tx.ended() {
 		return
 	}
+	tx.stopMaxDurationTimer()
 	if tx.Duration < 0 {
 		tx.Duration = time.Since(tx.timestamp)
 	}
+	tx.compressedSpan.flush()
 	tx.enqueue()
 	tx.TransactionData = nil
 }
 
+// EndAndFlush ends tx, and then waits for the tracer to flush it to the
+// APM server, as described for Tracer.FlushContext. This is intended for
+// short-lived programs, which perform a single operation and then exit.
+// The flush is not specific to tx: any other events queued by the tracer
+// will also be sent.
+//
+// If ctx is marked as being part of an internal operation of the agent
+// (see ContextWithInternalOperation), such as when EndAndFlush is called
+// by instrumentation of the tracer's transport, or if EndAndFlush is
+// called while the tracer is invoking a callback such as a transaction
+// filter or observer, then tx is ended without waiting for the flush, as
+// it would otherwise never complete.
+func (tx *Transaction) EndAndFlush(ctx context.Context) (FlushResult, error) {
+	tracer := tx.tracer
+	tx.End()
+	if IsInternalOperation(ctx) || tracer.inLoopCallback() {
+		return FlushResult{}, nil
+	}
+	return tracer.FlushContext(ctx)
+}
+
+// abandon ends tx if it has not already been ended, marking it as
+// abandoned. This is called when the transaction has exceeded the
+// maximum duration configured by Tracer.SetTransactionMaxDuration.
+//
+// The application may still hold a reference to tx, so abandon does
+// not modify tx.TransactionData. The tracer's background goroutine
+// labels the transaction and sets its duration when encoding it, and
+// does not reuse its data afterwards; see Transaction.release.
+func (tx *Transaction) abandon() {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.ended() {
+		return
+	}
+	tx.abandoned = true
+	tx.abandonedDuration = time.Since(tx.timestamp)
+	tx.compressedSpan.flush()
+	tx.enqueue()
+}
+
+// release returns td, the data of the ended transaction tx, to the
+// tracer's pool for reuse. The data of an abandoned transaction may
+// still be referenced by the application, so it is not reused.
+func (tx *Transaction) release(td *TransactionData) {
+	if tx.abandoned {
+		return
+	}
+	td.reset(tx.tracer)
+}
+
+// stopMaxDurationTimer stops the timer started for abandoning tx,
+// if any.
+//
+// This must be called with tx.mu held.
+func (tx *Transaction) stopMaxDurationTimer() {
+	if tx.maxDurationTimer != nil {
+		tx.maxDurationTimer.Stop()
+	}
+}
+
 func (tx *Transaction) enqueue() {
 	event := tracerEvent{eventType: transactionEvent}
 	event.tx.Transaction = tx
@@ -249,21 +370,36 @@ func (tx *Transaction) enqueue() {
 	case tx.tracer.events <- event:
 	default:
 		// Enqueuing a transaction should never block.
-		tx.tracer.breakdownMetrics.recordTransaction(tx.TransactionData)
+		if !tx.abandoned {
+			tx.tracer.breakdownMetrics.recordTransaction(tx.TransactionData)
+		}
 
 		// TODO(axw) use an atomic operation to increment.
 		tx.tracer.statsMu.Lock()
 		tx.tracer.stats.TransactionsDropped++
 		tx.tracer.statsMu.Unlock()
-		tx.reset(tx.tracer)
+		tx.release(tx.TransactionData)
+	}
+}
+
+// Ended reports whether or not End or Discard has been called on tx,
+// or tx has been abandoned for exceeding the configured maximum
+// duration. Ended returns true if tx is nil.
+func (tx *Transaction) Ended() bool {
+	if tx == nil {
+		return true
 	}
+	tx.mu.RLock()
+	defer tx.mu.RUnlock()
+	return tx.ended()
 }
 
-// ended reports whether or not End or Discard has been called.
+// ended reports whether or not End or Discard has been called,
+// or the transaction has been abandoned.
 //
 // This must be called with tx.mu held.
 func (tx *Transaction) ended() bool {
-	return tx.TransactionData == nil
+	return tx.TransactionData == nil || tx.abandoned
 }
 
 // TransactionData holds the details for a transaction, and is embedded
@@ -291,19 +427,31 @@ type TransactionData struct {
 	// Result holds the transaction result.
 	Result string
 
-	maxSpans                int
-	spanFramesMinDuration   time.Duration
-	stackTraceLimit         int
-	breakdownMetricsEnabled bool
-	propagateLegacyHeader   bool
-	timestamp               time.Time
-
-	mu            sync.Mutex
-	spansCreated  int
-	spansDropped  int
-	childrenTimer childrenTimer
-	spanTimings   spanTimingsMap
-	rand          *rand.Rand // for ID generation
+	// Outcome holds the transaction outcome: "success" or "failure".
+	// This will initially be empty, and can be set before ending the
+	// transaction. For HTTP server transactions, the outcome is derived
+	// from the response status code.
+	Outcome string
+
+	maxSpans                   int
+	spanFramesMinDuration      time.Duration
+	stackTraceLimit            int
+	breakdownMetricsEnabled    bool
+	propagateLegacyHeader      bool
+	spanCompressionEnabled     bool
+	spanCompressionMaxDuration time.Duration
+	maxDurationTimer           *time.Timer
+	timestamp                  time.Time
+	baggageDropped             int
+	baggageInvalid             int
+
+	mu             sync.Mutex
+	spansCreated   int
+	spansDropped   int
+	childrenTimer  childrenTimer
+	spanTimings    spanTimingsMap
+	compressedSpan compressedSpan
+	rand           *rand.Rand // for ID generation
 	// parentSpan holds the transaction's parent ID. It is protected by
 	// mu, since it can be updated by calling EnsureParent.
 	parentSpan SpanID
@@ -18,7 +18,9 @@
 package apm_test
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"math/rand"
 	"sync"
 	"testing"
@@ -113,6 +115,117 @@ func startTransactionIDSpecified(t *testing.T, traceContext apm.TraceContext) {
 	tx.Discard()
 }
 
+func TestStartTransactionStartTime(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	start := time.Now().Add(-time.Hour)
+	tx := tracer.StartTransactionOptions("implicit", "type", apm.TransactionOptions{Start: start})
+	tx.End()
+
+	tx = tracer.StartTransactionOptions("explicit", "type", apm.TransactionOptions{Start: start})
+	tx.Duration = 123 * time.Millisecond
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 2)
+	for _, tx := range payloads.Transactions {
+		assert.Equal(t, start.Truncate(time.Microsecond).UTC(), time.Time(tx.Timestamp).UTC())
+	}
+	assert.True(t, payloads.Transactions[0].Duration >= float64(time.Hour/time.Millisecond))
+	assert.Equal(t, 123.0, payloads.Transactions[1].Duration)
+}
+
+func TestStartTransactionFutureStartTime(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	var logger apmtest.RecordLogger
+	tracer.SetLogger(&logger)
+
+	tx := tracer.StartTransactionOptions("name", "type", apm.TransactionOptions{
+		Start: time.Now().Add(time.Hour),
+	})
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	assert.Zero(t, payloads.Transactions[0].Duration)
+
+	var warnings []string
+	for _, record := range logger.Records {
+		if record.Level == "warning" {
+			warnings = append(warnings, record.Message)
+		}
+	}
+	require.Len(t, warnings, 1)
+	assert.Regexp(t, `transaction "name" has negative duration .*, reporting zero duration`, warnings[0])
+}
+
+func TestStartTransactionForceSampled(t *testing.T) {
+	tracer := apmtest.NewDiscardTracer()
+	defer tracer.Close()
+	tracer.SetSampler(apm.NewRatioSampler(0))
+
+	assert.False(t, tracer.StartTransaction("name", "type").Sampled())
+	tx := tracer.StartTransactionOptions("name", "type", apm.TransactionOptions{ForceSampled: true})
+	assert.True(t, tx.Sampled())
+
+	// ForceSampled overrides a propagated sampling decision.
+	traceContext := tx.TraceContext()
+	traceContext.Options = traceContext.Options.WithRecorded(false)
+	tx = tracer.StartTransactionOptions("name", "type", apm.TransactionOptions{
+		TraceContext: traceContext,
+		ForceSampled: true,
+	})
+	assert.True(t, tx.Sampled())
+}
+
+func TestStartTransactionSampled(t *testing.T) {
+	tracer := apmtest.NewDiscardTracer()
+	defer tracer.Close()
+	tracer.SetSampler(apm.NewRatioSampler(0))
+
+	sampled, unsampled := true, false
+	tx := tracer.StartTransactionOptions("name", "type", apm.TransactionOptions{Sampled: &sampled})
+	assert.True(t, tx.Sampled())
+
+	// A propagated sampling decision takes precedence.
+	traceContext := tx.TraceContext()
+	traceContext.Options = traceContext.Options.WithRecorded(false)
+	tx = tracer.StartTransactionOptions("name", "type", apm.TransactionOptions{
+		TraceContext: traceContext,
+		Sampled:      &sampled,
+	})
+	assert.False(t, tx.Sampled())
+
+	tracer.SetSampler(nil)
+	tx = tracer.StartTransactionOptions("name", "type", apm.TransactionOptions{Sampled: &unsampled})
+	assert.False(t, tx.Sampled())
+}
+
+func TestTransactionTypeNormalization(t *testing.T) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	// Types are unchanged by default.
+	assert.Equal(t, " Reqest", tracer.StartTransaction("name", " Reqest").Type)
+
+	tracer.SetTransactionTypeSynonyms(map[string]string{"Reqest": "request"})
+	assert.Equal(t, "request", tracer.StartTransaction("name", " REQEST ").Type)
+	assert.Equal(t, "messaging", tracer.StartTransaction("name", "Messaging").Type)
+
+	tracer.SetAllowedTransactionTypes([]string{"request", "Scheduled"})
+	assert.Equal(t, "request", tracer.StartTransaction("name", "reqest").Type)
+	assert.Equal(t, "scheduled", tracer.StartTransaction("name", "scheduled").Type)
+	assert.Equal(t, "custom", tracer.StartTransaction("name", "messaging").Type)
+
+	tracer.SetTransactionTypeSynonyms(nil)
+	tracer.SetAllowedTransactionTypes(nil)
+	assert.Equal(t, "Messaging", tracer.StartTransaction("name", "Messaging").Type)
+}
+
 func TestTransactionEnsureParent(t *testing.T) {
 	tracer, transport := transporttest.NewRecorderTracer()
 	defer tracer.Close()
@@ -141,6 +254,147 @@ func TestTransactionEnsureParent(t *testing.T) {
 	assert.Equal(t, model.SpanID(parentSpan), payloads.Transactions[0].ParentID)
 }
 
+func TestTransactionMaxDuration(t *testing.T) {
+	tracer, r := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetTransactionMaxDuration(10 * time.Millisecond)
+
+	// Transactions ended before the max duration are unaffected.
+	tx := tracer.StartTransaction("ended", "type")
+	tx.End()
+
+	tx = tracer.StartTransaction("abandoned", "type")
+	tx.StartSpan("span", "type", nil).End()
+	deadline := time.After(10 * time.Second)
+	for {
+		tracer.Flush(nil)
+		if len(r.Payloads().Transactions) == 2 {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("timed out waiting for abandoned transaction")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	// The abandoned transaction's data, which the
+	// application still references, is left unmodified.
+	assert.Equal(t, time.Duration(-1), tx.Duration)
+
+	// Spans started after the transaction has been
+	// abandoned are not associated with it, and the
+	// transaction is not resent.
+	tx.StartSpan("ignored", "type", nil).End()
+	tx.Result = "ignored"
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := r.Payloads()
+	require.Len(t, payloads.Transactions, 2)
+	assert.Equal(t, "ended", payloads.Transactions[0].Name)
+	assert.Nil(t, payloads.Transactions[0].Context)
+
+	abandoned := payloads.Transactions[1]
+	assert.Equal(t, "abandoned", abandoned.Name)
+	assert.Equal(t, 1, abandoned.SpanCount.Started)
+	assert.InDelta(t, 10, abandoned.Duration, 1000)
+	assert.Equal(t, "", abandoned.Result)
+	assert.Equal(t, model.IfaceMap{{Key: "abandoned", Value: true}}, abandoned.Context.Tags)
+
+	require.Len(t, payloads.Spans, 2)
+	assert.Equal(t, abandoned.ID, payloads.Spans[0].ParentID)
+	assert.Equal(t, "ignored", payloads.Spans[1].Name)
+}
+
+func TestTransactionEnded(t *testing.T) {
+	tracer := apmtest.NewDiscardTracer()
+	defer tracer.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	assert.False(t, tx.Ended())
+	tx.End()
+	assert.True(t, tx.Ended())
+
+	tx = tracer.StartTransaction("name", "type")
+	tx.Discard()
+	assert.True(t, tx.Ended())
+
+	var nilTx *apm.Transaction
+	assert.True(t, nilTx.Ended())
+}
+
+func TestTransactionEndAndFlush(t *testing.T) {
+	tracer, r := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	tx.StartSpan("name", "type", nil).End()
+	result, err := tx.EndAndFlush(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, apm.FlushResult{Transactions: 1, Spans: 1}, result)
+
+	payloads := r.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	assert.Equal(t, "name", payloads.Transactions[0].Name)
+}
+
+func TestTransactionEndAndFlushInternalOperation(t *testing.T) {
+	var tracer *apm.Tracer
+	done := make(chan struct{})
+	tracer, err := apm.NewTracerOptions(apm.TracerOptions{
+		Transport: sendStreamFunc(func(ctx context.Context, r io.Reader) error {
+			select {
+			case <-done:
+			default:
+				// Ending a transaction within the tracer's loop
+				// must not wait for the flush, or it would deadlock.
+				_, err := tracer.StartTransaction("internal", "type").EndAndFlush(ctx)
+				assert.NoError(t, err)
+				close(done)
+			}
+			return nil
+		}),
+	})
+	require.NoError(t, err)
+	defer tracer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = tracer.StartTransaction("name", "type").EndAndFlush(ctx)
+	assert.NoError(t, err)
+	<-done
+}
+
+func TestTransactionEndAndFlushFromFilter(t *testing.T) {
+	tracer, r := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	var once sync.Once
+	tracer.SetTransactionFilter(func(tx *model.Transaction) bool {
+		once.Do(func() {
+			// Ending and flushing a transaction from a callback invoked
+			// by the tracer loop must not wait for the flush, or it
+			// would deadlock.
+			_, err := tracer.StartTransaction("filter", "type").EndAndFlush(context.Background())
+			assert.NoError(t, err)
+		})
+		return true
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := tracer.StartTransaction("name", "type").EndAndFlush(ctx)
+	require.NoError(t, err)
+	_, err = tracer.FlushContext(ctx)
+	require.NoError(t, err)
+
+	payloads := r.Payloads()
+	require.Len(t, payloads.Transactions, 2)
+	assert.Equal(t, "name", payloads.Transactions[0].Name)
+	assert.Equal(t, "filter", payloads.Transactions[1].Name)
+}
+
 func TestTransactionContextNotSampled(t *testing.T) {
 	tracer := apmtest.NewRecordingTracer()
 	defer tracer.Close()
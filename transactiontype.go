@@ -0,0 +1,57 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm
+
+import "strings"
+
+// defaultTransactionType is the type given to transactions whose
+// type is not in the list set by Tracer.SetAllowedTransactionTypes.
+const defaultTransactionType = "custom"
+
+// transactionTypes holds the configuration for normalizing
+// transaction types. The zero value leaves types unchanged.
+type transactionTypes struct {
+	// allowed holds the set of allowed transaction types,
+	// or nil if all transaction types are allowed.
+	allowed map[string]struct{}
+
+	// synonyms maps transaction types to their replacements.
+	synonyms map[string]string
+}
+
+// normalize returns the normalized form of transactionType.
+//
+// If any normalization is configured, transactionType is trimmed
+// of whitespace, lowercased, and replaced by its synonym, if any.
+// If the result is not an allowed type, defaultTransactionType is
+// returned.
+func (tt transactionTypes) normalize(transactionType string) string {
+	if tt.allowed == nil && tt.synonyms == nil {
+		return transactionType
+	}
+	transactionType = strings.ToLower(strings.TrimSpace(transactionType))
+	if synonym, ok := tt.synonyms[transactionType]; ok {
+		transactionType = synonym
+	}
+	if tt.allowed != nil {
+		if _, ok := tt.allowed[transactionType]; !ok {
+			return defaultTransactionType
+		}
+	}
+	return transactionType
+}
@@ -32,7 +32,6 @@ import (
 	"net/http"
 	"net/textproto"
 	"net/url"
-	"os"
 	"path"
 	"runtime"
 	"strconv"
@@ -43,6 +42,7 @@ import (
 	"github.com/pkg/errors"
 
 	"go.elastic.co/apm/apmconfig"
+	"go.elastic.co/apm/internal/apmhttputil"
 	"go.elastic.co/apm/internal/apmversion"
 	"go.elastic.co/apm/internal/configutil"
 )
@@ -58,6 +58,9 @@ const (
 	envServerTimeout    = "ELASTIC_APM_SERVER_TIMEOUT"
 	envServerCert       = "ELASTIC_APM_SERVER_CERT"
 	envVerifyServerCert = "ELASTIC_APM_VERIFY_SERVER_CERT"
+
+	envServerMaxIdleConnsPerHost = "ELASTIC_APM_SERVER_MAX_IDLE_CONNS_PER_HOST"
+	envServerIdleConnTimeout     = "ELASTIC_APM_SERVER_IDLE_CONN_TIMEOUT"
 )
 
 var (
@@ -71,6 +74,13 @@ var (
 
 // HTTPTransport is an implementation of Transport, sending payloads via
 // a net/http client.
+//
+// Events are streamed to the APM Server using the v2 intake protocol:
+// each request body is a stream of newline-delimited JSON objects, with
+// a metadata object followed by the events. Requests are kept open and
+// streamed to until the tracer's request size or duration limit is met.
+// The v1 intake protocol is not supported, so APM Server 6.5.0 or newer
+// is required.
 type HTTPTransport struct {
 	// Client exposes the http.Client used by the HTTPTransport for
 	// sending requests to the APM Server.
@@ -79,8 +89,10 @@ type HTTPTransport struct {
 	configHeaders  http.Header
 	profileHeaders http.Header
 	shuffleRand    *rand.Rand
+	unixSockets    unixSockets
 
 	urlIndex    int32
+	rootURLs    []*url.URL
 	intakeURLs  []*url.URL
 	configURLs  []*url.URL
 	profileURLs []*url.URL
@@ -94,7 +106,8 @@ type HTTPTransport struct {
 //   The transport will use this list of URLs for sending requests,
 //   switching to the next URL in the list upon error. The list will be
 //   shuffled first. If no URLs are specified, then the transport will
-//   use the default URL "http://localhost:8200".
+//   use the default URL "http://localhost:8200". URLs with the "unix"
+//   scheme identify a Unix domain socket, e.g. "unix:///run/apm.sock".
 //
 // - ELASTIC_APM_SERVER_TIMEOUT: timeout for requests to the APM Server.
 //   If not specified, defaults to 30 seconds.
@@ -111,12 +124,30 @@ type HTTPTransport struct {
 //   when using HTTPS. By default, the transport will verify server
 //   certificates.
 //
+// - ELASTIC_APM_SERVER_MAX_IDLE_CONNS_PER_HOST: the maximum number of
+//   idle (keep-alive) connections to keep open to each APM Server.
+//   If not specified, defaults to 2.
+//
+// - ELASTIC_APM_SERVER_IDLE_CONN_TIMEOUT: the amount of time an idle
+//   (keep-alive) connection to the APM Server will be kept open. If not
+//   specified, defaults to 90 seconds.
+//
 func NewHTTPTransport() (*HTTPTransport, error) {
 	verifyServerCert, err := configutil.ParseBoolEnv(envVerifyServerCert, true)
 	if err != nil {
 		return nil, err
 	}
 
+	maxIdleConnsPerHost, err := configutil.ParseIntEnv(envServerMaxIdleConnsPerHost, http.DefaultMaxIdleConnsPerHost)
+	if err != nil {
+		return nil, err
+	}
+
+	idleConnTimeout, err := configutil.ParseDurationEnv(envServerIdleConnTimeout, defaultHTTPTransport.IdleConnTimeout)
+	if err != nil {
+		return nil, err
+	}
+
 	serverTimeout, err := configutil.ParseDurationEnv(envServerTimeout, defaultServerTimeout)
 	if err != nil {
 		return nil, err
@@ -131,7 +162,7 @@ func NewHTTPTransport() (*HTTPTransport, error) {
 	}
 
 	tlsConfig := &tls.Config{InsecureSkipVerify: !verifyServerCert}
-	serverCertPath := os.Getenv(envServerCert)
+	serverCertPath := configutil.Getenv(envServerCert)
 	if serverCertPath != "" {
 		serverCert, err := loadCertificate(serverCertPath)
 		if err != nil {
@@ -145,13 +176,15 @@ func NewHTTPTransport() (*HTTPTransport, error) {
 		}
 	}
 
-	client := &http.Client{
+	t := &HTTPTransport{}
+	t.Client = &http.Client{
 		Timeout: serverTimeout,
 		Transport: &http.Transport{
-			Proxy:                 defaultHTTPTransport.Proxy,
-			DialContext:           defaultHTTPTransport.DialContext,
+			Proxy:                 t.unixSockets.proxy(defaultHTTPTransport.Proxy),
+			DialContext:           t.unixSockets.dialContext(defaultHTTPTransport.DialContext),
 			MaxIdleConns:          defaultHTTPTransport.MaxIdleConns,
-			IdleConnTimeout:       defaultHTTPTransport.IdleConnTimeout,
+			MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+			IdleConnTimeout:       idleConnTimeout,
 			TLSHandshakeTimeout:   defaultHTTPTransport.TLSHandshakeTimeout,
 			ExpectContinueTimeout: defaultHTTPTransport.ExpectContinueTimeout,
 			TLSClientConfig:       tlsConfig,
@@ -168,13 +201,10 @@ func NewHTTPTransport() (*HTTPTransport, error) {
 
 	profileHeaders := copyHeaders(commonHeaders)
 
-	t := &HTTPTransport{
-		Client:         client,
-		configHeaders:  commonHeaders,
-		intakeHeaders:  intakeHeaders,
-		profileHeaders: profileHeaders,
-	}
-	t.SetSecretToken(os.Getenv(envSecretToken))
+	t.configHeaders = commonHeaders
+	t.intakeHeaders = intakeHeaders
+	t.profileHeaders = profileHeaders
+	t.SetSecretToken(configutil.Getenv(envSecretToken))
 	t.SetServerURL(serverURLs...)
 	return t, nil
 }
@@ -182,14 +212,24 @@ func NewHTTPTransport() (*HTTPTransport, error) {
 // SetServerURL sets the APM Server URL (or URLs) for sending requests.
 // At least one URL must be specified, or the method will panic. The
 // list will be randomly shuffled.
+//
+// URLs with the "unix" scheme, e.g. "unix:///run/apm-server.sock",
+// identify a Unix domain socket on which the APM Server is listening.
+// Requests to Unix domain sockets are sent using plain HTTP, with the
+// Host header "localhost". Unix domain sockets are dialed only by the
+// http.Client created by NewHTTPTransport; see SetHTTPClient.
 func (t *HTTPTransport) SetServerURL(u ...*url.URL) {
 	if len(u) == 0 {
 		panic("SetServerURL expects at least one URL")
 	}
+	rootURLs := make([]*url.URL, len(u))
 	intakeURLs := make([]*url.URL, len(u))
 	configURLs := make([]*url.URL, len(u))
 	profileURLs := make([]*url.URL, len(u))
+	unixSocketPaths := make(map[string]string)
 	for i, u := range u {
+		u = unixSocketURL(u, i, unixSocketPaths)
+		rootURLs[i] = urlWithPath(u, "/")
 		intakeURLs[i] = urlWithPath(u, intakePath)
 		configURLs[i] = urlWithPath(u, configPath)
 		profileURLs[i] = urlWithPath(u, profilePath)
@@ -200,17 +240,51 @@ func (t *HTTPTransport) SetServerURL(u ...*url.URL) {
 		}
 		for i := n - 1; i > 0; i-- {
 			j := t.shuffleRand.Intn(i + 1)
+			rootURLs[i], rootURLs[j] = rootURLs[j], rootURLs[i]
 			intakeURLs[i], intakeURLs[j] = intakeURLs[j], intakeURLs[i]
 			configURLs[i], configURLs[j] = configURLs[j], configURLs[i]
 			profileURLs[i], profileURLs[j] = profileURLs[j], profileURLs[i]
 		}
 	}
+	t.unixSockets.setPaths(unixSocketPaths)
+	t.rootURLs = rootURLs
 	t.intakeURLs = intakeURLs
 	t.configURLs = configURLs
 	t.profileURLs = profileURLs
 	t.urlIndex = 0
 }
 
+// SetHTTPClient sets the http.Client used for sending requests to the
+// APM Server, replacing the one created by NewHTTPTransport. Headers,
+// such as User-Agent and Authorization, are still set by the transport.
+// The client's own configuration, such as its timeout and TLS settings,
+// is used in place of that derived from environment variables.
+//
+// Requests to the APM Server must not themselves be traced, as doing so
+// may lead to an unbounded feedback loop. If the client's Transport was
+// returned by apmhttp.WrapRoundTripper (or WrapClient), SetHTTPClient will
+// use a copy of the client with the underlying, untraced RoundTripper.
+// Traced RoundTrippers wrapped within other RoundTrippers cannot be
+// detected, and must be avoided.
+//
+// If the server URLs identify a Unix domain socket, the client must
+// dial the socket itself, e.g. using an http.Transport with a custom
+// DialContext which always dials the socket; socket paths are known
+// only to the client created by NewHTTPTransport.
+//
+// If client is nil, SetHTTPClient will panic.
+func (t *HTTPTransport) SetHTTPClient(client *http.Client) {
+	if client == nil {
+		panic("SetHTTPClient expects a non-nil client")
+	}
+	if rt, ok := client.Transport.(apmhttputil.TracingRoundTripper); ok {
+		clientCopy := *client
+		clientCopy.Transport = rt.UntracedRoundTripper()
+		client = &clientCopy
+	}
+	t.Client = client
+}
+
 // SetUserAgent sets the User-Agent header that will be sent with each request.
 func (t *HTTPTransport) SetUserAgent(ua string) {
 	t.setCommonHeader("User-Agent", ua)
@@ -239,6 +313,70 @@ func (t *HTTPTransport) deleteCommonHeader(key string) {
 	t.profileHeaders.Del(key)
 }
 
+// ServerInfo holds information about an APM Server, as reported by
+// the server's root endpoint.
+type ServerInfo struct {
+	// Version is the APM Server version, e.g. "7.5.0".
+	Version string
+
+	// BuildDate is the date the APM Server was built, as reported
+	// by the server.
+	BuildDate string
+
+	// BuildSHA is the commit SHA from which the APM Server was built.
+	BuildSHA string
+}
+
+// Verify checks connectivity with the APM Server currently in use, by
+// querying the server's root endpoint, and returns information about the
+// server.
+//
+// If the server could not be reached, or responds with an error, then
+// Verify returns a zero ServerInfo and a non-nil error. If the server
+// version is known to be incompatible with the intake protocol used by
+// the transport, then Verify returns the ServerInfo along with a non-nil
+// error describing the incompatibility; callers will typically log this
+// as a warning on startup.
+//
+// Older servers, or servers configured with a secret token where the
+// request is not authorized, may not report their version. In this
+// case the returned ServerInfo will have an empty Version, and no
+// compatibility error is reported.
+func (t *HTTPTransport) Verify(ctx context.Context) (ServerInfo, error) {
+	urlIndex := atomic.LoadInt32(&t.urlIndex)
+	rootURL := t.rootURLs[urlIndex]
+	req := t.newRequest("GET", rootURL)
+	req = requestWithContext(ctx, req)
+	req.Header = t.configHeaders
+
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return ServerInfo{}, errors.Wrap(err, "sending server info request failed")
+	}
+	defer closeResponseBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		return ServerInfo{}, newHTTPError(resp)
+	}
+
+	info, err := decodeServerInfo(resp.Body)
+	if err != nil {
+		return ServerInfo{}, errors.Wrap(err, "failed to decode server info")
+	}
+	if info.Version != "" {
+		major, minor, ok := parseServerVersion(info.Version)
+		if !ok {
+			return info, errors.Errorf("failed to parse APM Server version %q", info.Version)
+		}
+		if major < 6 || (major == 6 && minor < 5) {
+			return info, errors.Errorf(
+				"APM Server version %s is incompatible with the v2 intake protocol (requires APM Server 6.5.0 or newer)",
+				info.Version,
+			)
+		}
+	}
+	return info, nil
+}
+
 // SendStream sends the stream over HTTP. If SendStream returns an error and
 // the transport is configured with more than one APM Server URL, then the
 // following request will be sent to the next URL in the list.
@@ -263,7 +401,7 @@ func (t *HTTPTransport) sendStreamRequest(req *http.Request) error {
 	}
 	switch resp.StatusCode {
 	case http.StatusOK, http.StatusAccepted:
-		resp.Body.Close()
+		closeResponseBody(resp)
 		return nil
 	}
 	defer resp.Body.Close()
@@ -336,7 +474,7 @@ func (t *HTTPTransport) sendProfileRequest(req *http.Request) error {
 	}
 	switch resp.StatusCode {
 	case http.StatusOK, http.StatusAccepted:
-		resp.Body.Close()
+		closeResponseBody(resp)
 		return nil
 	}
 	defer resp.Body.Close()
@@ -472,9 +610,19 @@ func (t *HTTPTransport) newRequest(method string, url *url.URL) *http.Request {
 		ProtoMinor: 1,
 		Host:       url.Host,
 	}
+	if _, ok := t.unixSockets.lookup(url.Host); ok {
+		req.Host = "localhost"
+	}
 	return req
 }
 
+// closeResponseBody reads the remainder of the response body, if any,
+// and closes it, so that the underlying connection may be reused.
+func closeResponseBody(resp *http.Response) {
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
+
 func urlWithPath(url *url.URL, p string) *url.URL {
 	urlCopy := *url
 	urlCopy.Path = path.Clean(urlCopy.Path + p)
@@ -509,16 +657,43 @@ func (e *HTTPError) Error() string {
 	return msg
 }
 
+// RetryAfter returns the delay suggested by the server before retrying
+// the request, as specified in the Retry-After response header. Both the
+// delay-seconds and HTTP-date forms of the header are supported.
+//
+// If the header is absent or invalid, RetryAfter returns false.
+func (e *HTTPError) RetryAfter() (time.Duration, bool) {
+	return parseRetryAfter(e.Response.Header.Get("Retry-After"), time.Now())
+}
+
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	d := t.Sub(now)
+	if d < 0 {
+		d = 0
+	}
+	return d, true
+}
+
 // initServerURLs parses ELASTIC_APM_SERVER_URLS if specified,
 // otherwise parses ELASTIC_APM_SERVER_URL if specified. If
 // neither are specified, then the default localhost URL is
 // returned.
 func initServerURLs() ([]*url.URL, error) {
 	key := envServerURLs
-	value := os.Getenv(key)
+	value := configutil.Getenv(key)
 	if value == "" {
 		key = envServerURL
-		value = os.Getenv(key)
+		value = configutil.Getenv(key)
 	}
 	var urls []*url.URL
 	for _, field := range strings.Split(value, ",") {
@@ -592,6 +767,50 @@ func copyHeaders(in http.Header) http.Header {
 	return out
 }
 
+// decodeServerInfo decodes the APM Server root endpoint response.
+// Servers prior to 7.0 nest the information in an "ok" object.
+func decodeServerInfo(r io.Reader) (ServerInfo, error) {
+	type serverInfo struct {
+		Version   string `json:"version"`
+		BuildDate string `json:"build_date"`
+		BuildSHA  string `json:"build_sha"`
+	}
+	var response struct {
+		serverInfo
+		OK *serverInfo `json:"ok"`
+	}
+	if err := json.NewDecoder(r).Decode(&response); err != nil && err != io.EOF {
+		return ServerInfo{}, err
+	}
+	info := response.serverInfo
+	if response.OK != nil {
+		info = *response.OK
+	}
+	return ServerInfo{
+		Version:   info.Version,
+		BuildDate: info.BuildDate,
+		BuildSHA:  info.BuildSHA,
+	}, nil
+}
+
+// parseServerVersion parses the major and minor components of
+// a server version string, e.g. "7.5.0" or "8.0.0-SNAPSHOT".
+func parseServerVersion(version string) (major, minor int, ok bool) {
+	fields := strings.SplitN(version, ".", 3)
+	if len(fields) < 2 {
+		return 0, 0, false
+	}
+	major, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	minor, err = strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
 type configResponse struct {
 	err    error
 	attrs  map[string]string
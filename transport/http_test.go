@@ -32,7 +32,9 @@ import (
 	"net/textproto"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -52,6 +54,8 @@ func init() {
 	os.Unsetenv("ELASTIC_APM_SECRET_TOKEN")
 	os.Unsetenv("ELASTIC_APM_SERVER_CERT")
 	os.Unsetenv("ELASTIC_APM_VERIFY_SERVER_CERT")
+	os.Unsetenv("ELASTIC_APM_SERVER_MAX_IDLE_CONNS_PER_HOST")
+	os.Unsetenv("ELASTIC_APM_SERVER_IDLE_CONN_TIMEOUT")
 }
 
 func TestNewHTTPTransportDefaultURL(t *testing.T) {
@@ -95,6 +99,170 @@ func TestHTTPTransportUserAgent(t *testing.T) {
 	assert.Equal(t, "foo", h.requests[1].UserAgent())
 }
 
+func TestHTTPTransportSetHTTPClient(t *testing.T) {
+	var h recordingHandler
+	server := httptest.NewServer(&h)
+	defer server.Close()
+	defer patchEnv("ELASTIC_APM_SERVER_URLS", server.URL)()
+
+	var roundTrips int32
+	client := &http.Client{
+		Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&roundTrips, 1)
+			return http.DefaultTransport.RoundTrip(req)
+		}),
+	}
+
+	transport, err := transport.NewHTTPTransport()
+	require.NoError(t, err)
+	transport.SetSecretToken("hunter2")
+	transport.SetHTTPClient(client)
+	assert.Equal(t, client, transport.Client)
+
+	err = transport.SendStream(context.Background(), strings.NewReader(""))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&roundTrips))
+	require.Len(t, h.requests, 1)
+	assertAuthorization(t, h.requests[0], "hunter2")
+	assert.Regexp(t, "elasticapm-go/.* go/.*", h.requests[0].UserAgent())
+
+	assert.Panics(t, func() { transport.SetHTTPClient(nil) })
+}
+
+func TestHTTPTransportVerify(t *testing.T) {
+	var response string
+	var requests []*http.Request
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests = append(requests, req)
+		if req.URL.Path != "/" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write([]byte(response))
+	})
+	transport, server := newHTTPTransport(t, handler)
+	defer server.Close()
+	transport.SetUserAgent("foo")
+
+	response = `{"build_date":"2019-11-07T09:23:41Z","build_sha":"abc123","version":"7.5.0"}`
+	info, err := transport.Verify(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "7.5.0", info.Version)
+	assert.Equal(t, "2019-11-07T09:23:41Z", info.BuildDate)
+	assert.Equal(t, "abc123", info.BuildSHA)
+	require.Len(t, requests, 1)
+	assert.Equal(t, "foo", requests[0].UserAgent())
+
+	response = `{"ok":{"build_date":"2018-11-07T09:23:41Z","build_sha":"def456","version":"6.5.0"}}`
+	info, err = transport.Verify(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "6.5.0", info.Version)
+
+	response = `{"ok":{"version":"6.4.3"}}`
+	info, err = transport.Verify(context.Background())
+	assert.EqualError(t, err, "APM Server version 6.4.3 is incompatible with the v2 intake protocol (requires APM Server 6.5.0 or newer)")
+	assert.Equal(t, "6.4.3", info.Version)
+
+	// Unauthorized requests to servers with a secret token
+	// configured do not report the server version.
+	response = ""
+	info, err = transport.Verify(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "", info.Version)
+}
+
+func TestHTTPTransportVerifyError(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+	})
+	transport, server := newHTTPTransport(t, handler)
+	defer server.Close()
+
+	_, err := transport.Verify(context.Background())
+	assert.EqualError(t, err, "request failed with 401 Unauthorized: invalid token")
+}
+
+func TestHTTPTransportRetryAfter(t *testing.T) {
+	var retryAfter string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	httpTransport, server := newHTTPTransport(t, handler)
+	defer server.Close()
+
+	sendStream := func() (time.Duration, bool) {
+		err := httpTransport.SendStream(context.Background(), strings.NewReader(""))
+		require.Error(t, err)
+		require.IsType(t, &transport.HTTPError{}, err)
+		return err.(*transport.HTTPError).RetryAfter()
+	}
+
+	retryAfter = "120"
+	d, ok := sendStream()
+	assert.True(t, ok)
+	assert.Equal(t, 120*time.Second, d)
+
+	retryAfter = time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	d, ok = sendStream()
+	assert.True(t, ok)
+	assert.InDelta(t, time.Hour, d, float64(5*time.Second))
+
+	// Dates in the past imply no delay.
+	retryAfter = time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	d, ok = sendStream()
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), d)
+
+	retryAfter = "soon"
+	_, ok = sendStream()
+	assert.False(t, ok)
+
+	retryAfter = ""
+	_, ok = sendStream()
+	assert.False(t, ok)
+}
+
+func TestHTTPTransportConnectionReuse(t *testing.T) {
+	var h recordingHandler
+	server := httptest.NewUnstartedServer(&h)
+	var newConns int32
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&newConns, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+	defer patchEnv("ELASTIC_APM_SERVER_URLS", server.URL)()
+
+	httpTransport, err := transport.NewHTTPTransport()
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		err := httpTransport.SendStream(context.Background(), strings.NewReader("{}\n"))
+		require.NoError(t, err)
+	}
+	assert.Len(t, h.requests, 5)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&newConns))
+}
+
+func TestHTTPTransportEnvIdleConns(t *testing.T) {
+	defer patchEnv("ELASTIC_APM_SERVER_MAX_IDLE_CONNS_PER_HOST", "10")()
+	defer patchEnv("ELASTIC_APM_SERVER_IDLE_CONN_TIMEOUT", "5s")()
+
+	httpTransport, err := transport.NewHTTPTransport()
+	require.NoError(t, err)
+	clientTransport := httpTransport.Client.Transport.(*http.Transport)
+	assert.Equal(t, 10, clientTransport.MaxIdleConnsPerHost)
+	assert.Equal(t, 5*time.Second, clientTransport.IdleConnTimeout)
+
+	os.Setenv("ELASTIC_APM_SERVER_MAX_IDLE_CONNS_PER_HOST", "ten")
+	_, err = transport.NewHTTPTransport()
+	assert.EqualError(t, err, `failed to parse ELASTIC_APM_SERVER_MAX_IDLE_CONNS_PER_HOST: strconv.Atoi: parsing "ten": invalid syntax`)
+}
+
 func TestHTTPTransportSecretToken(t *testing.T) {
 	var h recordingHandler
 	server := httptest.NewServer(&h)
@@ -490,6 +658,35 @@ func TestNewHTTPTransportTrailingSlash(t *testing.T) {
 	assert.Equal(t, "/intake/v2/events", h.requests[0].URL.Path)
 }
 
+func TestHTTPTransportUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apm-transport-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "apm-server.sock")
+
+	listener, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	var h recordingHandler
+	server := httptest.NewUnstartedServer(&h)
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+	defer patchEnv("ELASTIC_APM_SERVER_URLS", "unix://"+socketPath)()
+
+	transport, err := transport.NewHTTPTransport()
+	require.NoError(t, err)
+	transport.SetSecretToken("hunter2")
+	err = transport.SendStream(context.Background(), strings.NewReader("{}\n"))
+	require.NoError(t, err)
+
+	require.Len(t, h.requests, 1)
+	assert.Equal(t, "POST", h.requests[0].Method)
+	assert.Equal(t, "/intake/v2/events", h.requests[0].URL.Path)
+	assert.Equal(t, "localhost", h.requests[0].Host)
+	assert.Equal(t, "deflate", h.requests[0].Header.Get("Content-Encoding"))
+	assertAuthorization(t, h.requests[0], "hunter2")
+}
+
 func TestHTTPTransportSendProfile(t *testing.T) {
 	metadata := "metadata"
 	profile1 := "profile1"
@@ -0,0 +1,103 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package transport
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+// unixSocketScheme is the URL scheme used for APM Server URLs
+// identifying a Unix domain socket, e.g. "unix:///run/apm-server.sock".
+const unixSocketScheme = "unix"
+
+// unixSockets records the Unix domain sockets identified by the
+// APM Server URLs configured for an HTTPTransport.
+//
+// Requests to Unix domain sockets are sent to URLs with synthetic
+// host names, which are mapped back to socket paths when dialing.
+// Host names in the reserved ".invalid" domain are used, so they
+// cannot collide with real server addresses.
+type unixSockets struct {
+	mu    sync.RWMutex
+	paths map[string]string // host -> socket path
+}
+
+// unixSocketURL returns a URL with a synthetic host for the Unix
+// domain socket identified by u, where i is the index of u in the
+// configured server URLs, recording the socket path in paths. If
+// u does not identify a Unix domain socket, unixSocketURL returns u.
+func unixSocketURL(u *url.URL, i int, paths map[string]string) *url.URL {
+	if u.Scheme != unixSocketScheme {
+		return u
+	}
+	host := "unix-socket-" + strconv.Itoa(i) + ".invalid"
+	paths[host] = u.Path
+	return &url.URL{Scheme: "http", Host: host}
+}
+
+// setPaths replaces the recorded Unix domain socket paths.
+func (s *unixSockets) setPaths(paths map[string]string) {
+	s.mu.Lock()
+	s.paths = paths
+	s.mu.Unlock()
+}
+
+// lookup returns the path of the Unix domain socket for the
+// given host, which may have a port, and a boolean indicating
+// whether host identifies a Unix domain socket.
+func (s *unixSockets) lookup(host string) (string, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	s.mu.RLock()
+	path, ok := s.paths[host]
+	s.mu.RUnlock()
+	return path, ok
+}
+
+// dialContext returns a function which dials Unix domain sockets
+// for synthetic socket hosts, and otherwise calls dial.
+func (s *unixSockets) dialContext(
+	dial func(ctx context.Context, network, addr string) (net.Conn, error),
+) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if path, ok := s.lookup(addr); ok {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
+// proxy returns a function which never proxies requests to Unix
+// domain sockets, and otherwise calls proxy.
+func (s *unixSockets) proxy(
+	proxy func(*http.Request) (*url.URL, error),
+) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		if _, ok := s.lookup(req.URL.Host); ok || proxy == nil {
+			return nil, nil
+		}
+		return proxy(req)
+	}
+}
@@ -0,0 +1,90 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package transport
+
+import (
+	"bufio"
+	"compress/zlib"
+	"context"
+	"io"
+	"sync"
+)
+
+// WriterTransport is a Transport which writes decompressed, newline-delimited
+// JSON streams to an io.Writer. WriterTransport is intended for local
+// development and debugging, e.g. for printing events to stdout without
+// running an APM Server.
+//
+// Each line of the stream (metadata or event) is written to the writer with
+// a single Write call, and WriterTransport is safe for concurrent use.
+type WriterTransport struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterTransport returns a new WriterTransport which writes
+// streams to w.
+func NewWriterTransport(w io.Writer) *WriterTransport {
+	return &WriterTransport{w: w}
+}
+
+// SendStream decompresses the stream, writing each line to the writer.
+// SendStream returns when the stream has been fully consumed, or the
+// first write fails.
+func (t *WriterTransport) SendStream(ctx context.Context, r io.Reader) error {
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return streamError(ctx, err)
+	}
+	defer zr.Close()
+
+	br := bufio.NewReader(zr)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			if line[len(line)-1] != '\n' {
+				line = append(line, '\n')
+			}
+			if werr := t.write(line); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return streamError(ctx, err)
+		}
+	}
+}
+
+func (t *WriterTransport) write(line []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, err := t.w.Write(line)
+	return err
+}
+
+// streamError returns ctx.Err() if err is io.ErrUnexpectedEOF and ctx
+// is done, indicating that the stream was cut short by cancellation;
+// otherwise err is returned.
+func streamError(ctx context.Context, err error) error {
+	if err == io.ErrUnexpectedEOF && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
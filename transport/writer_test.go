@@ -0,0 +1,60 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package transport_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/transport"
+)
+
+func TestWriterTransport(t *testing.T) {
+	var buf bytes.Buffer
+	tracer, err := apm.NewTracerOptions(apm.TracerOptions{
+		ServiceName: "writer_test",
+		Transport:   transport.NewWriterTransport(&buf),
+	})
+	require.NoError(t, err)
+	defer tracer.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	tx.StartSpan("span", "type", nil).End()
+	tx.End()
+	tracer.Flush(nil)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 3)
+
+	var keys []string
+	for _, line := range lines {
+		var object map[string]json.RawMessage
+		require.NoError(t, json.Unmarshal([]byte(line), &object))
+		require.Len(t, object, 1)
+		for k := range object {
+			keys = append(keys, k)
+		}
+	}
+	assert.Equal(t, []string{"metadata", "span", "transaction"}, keys)
+}
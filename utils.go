@@ -32,6 +32,7 @@ import (
 
 	"go.elastic.co/apm/internal/apmhostutil"
 	"go.elastic.co/apm/internal/apmstrings"
+	"go.elastic.co/apm/internal/configutil"
 	"go.elastic.co/apm/model"
 )
 
@@ -95,7 +96,7 @@ func makeService(name, version, environment string) model.Service {
 		Runtime:     &goRuntime,
 	}
 
-	serviceNodeName := os.Getenv(envServiceNodeName)
+	serviceNodeName := configutil.Getenv(envServiceNodeName)
 	if serviceNodeName != "" {
 		service.Node = &model.ServiceNode{ConfiguredName: truncateString(serviceNodeName)}
 	}
@@ -108,7 +109,7 @@ func getLocalSystem() model.System {
 		Architecture: runtime.GOARCH,
 		Platform:     runtime.GOOS,
 	}
-	system.Hostname = os.Getenv(envHostname)
+	system.Hostname = configutil.Getenv(envHostname)
 	if system.Hostname == "" {
 		if hostname, err := os.Hostname(); err == nil {
 			system.Hostname = hostname
@@ -18,14 +18,25 @@
 package apm
 
 import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
 	"math/rand"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/model"
 )
 
 func TestGetCurrentProcess(t *testing.T) {
@@ -41,6 +52,82 @@ func TestGetCurrentProcess(t *testing.T) {
 	assert.Equal(t, expected, process.Title)
 }
 
+func TestExecutableServiceNameNoArgs(t *testing.T) {
+	args := os.Args
+	defer func() { os.Args = args }()
+	os.Args = nil
+
+	executable, err := os.Executable()
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Base(executable), executableServiceName())
+}
+
+func TestTracerReinitialize(t *testing.T) {
+	var transport metadataRecorderTransport
+	tracer, err := NewTracerOptions(TracerOptions{Transport: &transport})
+	require.NoError(t, err)
+	defer tracer.Close()
+
+	// Simulate the process having been forked after the tracer was created:
+	// the child process has a different process ID, and the tracer loop is
+	// not running. We stop the loop by closing the tracer, and then restore
+	// the state that a forked child process would observe.
+	tracer.Close()
+	tracer.closing = make(chan struct{})
+	tracer.closed = make(chan struct{})
+	atomic.StoreInt32(&tracer.active, 1)
+	process := getCurrentProcess()
+	process.Pid = -1
+	tracer.process = &process
+
+	tracer.Reinitialize()
+	tracer.StartTransaction("name", "type").End()
+	tracer.Flush(nil)
+	assert.Equal(t, os.Getpid(), transport.process.Pid)
+	assert.Equal(t, 1, transport.transactions)
+
+	// Reinitialize has no effect if the process ID has not changed.
+	events := tracer.events
+	tracer.Reinitialize()
+	assert.Equal(t, events, tracer.events)
+}
+
+type metadataRecorderTransport struct {
+	process      model.Process
+	transactions int
+}
+
+func (r *metadataRecorderTransport) SendStream(ctx context.Context, stream io.Reader) error {
+	zr, err := zlib.NewReader(stream)
+	if err != nil {
+		return err
+	}
+	defer io.Copy(ioutil.Discard, zr)
+	var metadata struct {
+		Metadata struct {
+			Process model.Process `json:"process"`
+		} `json:"metadata"`
+	}
+	br := bufio.NewReader(zr)
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(line, &metadata); err != nil {
+		return err
+	}
+	r.process = metadata.Metadata.Process
+	for {
+		line, err := br.ReadBytes('\n')
+		if bytes.HasPrefix(line, []byte(`{"transaction":`)) {
+			r.transactions++
+		}
+		if err != nil {
+			return nil
+		}
+	}
+}
+
 func TestGracePeriod(t *testing.T) {
 	var p time.Duration = -1
 	var seq []time.Duration
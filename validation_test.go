@@ -111,6 +111,15 @@ func TestValidateDestinationSpanContext(t *testing.T) {
 	})
 }
 
+func TestValidateServiceTargetSpanContext(t *testing.T) {
+	validateSpan(t, func(s *apm.Span) {
+		s.Context.SetServiceTarget(apm.ServiceTargetSpanContext{
+			Type: strings.Repeat("x", 1025),
+			Name: strings.Repeat("x", 1025),
+		})
+	})
+}
+
 func TestValidateContextUser(t *testing.T) {
 	validateTransaction(t, func(tx *apm.Transaction) {
 		tx.Context.SetUsername(strings.Repeat("x", 1025))